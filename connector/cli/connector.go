@@ -1,8 +1,6 @@
 package cli
 
 import (
-	"fmt"
-
 	"github.com/abiosoft/ishell"
 
 	"github.com/guiflemes/ohmychat/message"
@@ -32,6 +30,10 @@ func NewCliConnector(options ...CliOption) core.Connector {
 	return conn
 }
 
+func (cli *cliConnector) Kind() message.MessageConnector {
+	return message.Cli
+}
+
 func (cli *cliConnector) Acquire(ctx *core.ChatContext, input chan<- message.Message) error {
 	cli.control.ctx = ctx
 
@@ -40,7 +42,7 @@ func (cli *cliConnector) Acquire(ctx *core.ChatContext, input chan<- message.Mes
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Println("sutdown cli connector")
+			ctx.Logger().Info("cli connector stopped")
 			return nil
 		case update, ok := <-updates:
 			if !ok {
@@ -56,8 +58,15 @@ func (cli *cliConnector) Acquire(ctx *core.ChatContext, input chan<- message.Mes
 			msg.BotID = "CLI"
 			msg.BotName = update.Message.BotName
 			msg.User.ID = "cli_id"
-
-			input <- msg
+			core.StartTrace(&msg)
+			ctx.Logger().Debug("message received", core.MessageLogAttrs(msg)...)
+
+			select {
+			case input <- msg:
+			case <-ctx.Done():
+				ctx.Logger().Info("cli connector stopped")
+				return nil
+			}
 
 		default:
 		}
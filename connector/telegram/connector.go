@@ -1,8 +1,12 @@
 package telegram
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"strconv"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
@@ -19,17 +23,25 @@ func NewTelegramConnector(client *tgbotapi.BotAPI) core.Connector {
 	return &telegram{client: client}
 }
 
+func (t *telegram) Kind() message.MessageConnector {
+	return message.Telegram
+}
+
 func (t *telegram) Acquire(ctx *core.ChatContext, input chan<- message.Message) error {
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
+	component := core.ConnectorHealth(message.Telegram)
+	ctx.ReportHealth(component, core.HealthDegraded, "connecting")
 	user, err := t.client.GetMe()
 	if err != nil {
-		return err
+		return fmt.Errorf("telegram: get bot: %w", err)
 	}
+	ctx.ReportHealth(component, core.HealthUp, "")
 
 	updates := t.client.GetUpdatesChan(u)
+	ctx.Logger().Info("telegram connector started", slog.String("bot", user.UserName))
 
 	for {
 		select {
@@ -54,6 +66,8 @@ func (t *telegram) Acquire(ctx *core.ChatContext, input chan<- message.Message)
 				continue
 			}
 
+			from := update.SentFrom()
+
 			msg := message.NewMessage()
 			msg.Type = message.MsgTypeUnknown
 			msg.Connector = message.Telegram
@@ -63,10 +77,22 @@ func (t *telegram) Acquire(ctx *core.ChatContext, input chan<- message.Message)
 			msg.ChannelID = strconv.FormatInt(m.Chat.ID, 10)
 			msg.BotID = strconv.FormatInt(user.ID, 10)
 			msg.BotName = user.UserName
-
-			input <- msg
+			msg.User.ID = msg.ChannelID
+			if from != nil {
+				msg.User.ID = strconv.FormatInt(from.ID, 10)
+			}
+			core.StartTrace(&msg)
+			ctx.Logger().Debug("message received", core.MessageLogAttrs(msg)...)
+
+			select {
+			case input <- msg:
+			case <-ctx.Done():
+				ctx.Logger().Info("telegram connector stopped")
+				return nil
+			}
 
 		case <-ctx.Done():
+			ctx.Logger().Info("telegram connector stopped")
 			return nil
 		}
 
@@ -75,8 +101,7 @@ func (t *telegram) Acquire(ctx *core.ChatContext, input chan<- message.Message)
 func (t *telegram) Dispatch(message message.Message) error {
 	chatID, err := strconv.ParseInt(message.ChannelID, 10, 64)
 	if err != nil {
-		log.Printf("telegram: error parsing chat_id | %s", err)
-		return err
+		return core.Permanent(fmt.Errorf("telegram: parse chat id %q: %w", message.ChannelID, err))
 	}
 
 	msg := tgbotapi.NewMessage(chatID, message.Output)
@@ -84,12 +109,30 @@ func (t *telegram) Dispatch(message message.Message) error {
 
 	_, err = t.client.Send(msg)
 	if err != nil {
-		log.Printf("telegram: error sending message '%s' | %s", message.ID, err)
-		return err
+		return sendError(err)
 	}
 	return nil
 }
 
+// sendError tells apart the failures worth retrying: Telegram answers 429
+// with how long to wait, and other 4xx to requests that will never succeed.
+func sendError(err error) error {
+	err = fmt.Errorf("telegram: send message: %w", err)
+
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		return &core.RetryAfterError{After: time.Duration(apiErr.RetryAfter) * time.Second, Err: err}
+	case apiErr.Code >= 400 && apiErr.Code < 500:
+		return core.Permanent(err)
+	default:
+		return err
+	}
+}
+
 func (t *telegram) formatResponse(responseMsg *tgbotapi.MessageConfig, msg message.Message) {
 	switch msg.ResponseType {
 	case message.OptionResponse:
@@ -0,0 +1,18 @@
+package core
+
+import "time"
+
+// Clock abstracts time so the code waiting on it, such as retries, schedules
+// and rate limits, can be tested deterministically.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the wall time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
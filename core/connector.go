@@ -2,12 +2,25 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+
 	"github.com/guiflemes/ohmychat/message"
 )
 
+var (
+	ErrNoConnector         = errors.New("no connector registered")
+	ErrDuplicatedConnector = errors.New("connector already registered")
+	ErrUnknownConnector    = errors.New("unknown connector")
+)
+
 type Connector interface {
 	Acquire(ctx *ChatContext, input chan<- message.Message) error
 	Dispatch(message message.Message) error
+	Kind() message.MessageConnector
 }
 
 type ConnectorConfig struct {
@@ -15,39 +28,144 @@ type ConnectorConfig struct {
 }
 
 type multiChannelConnector struct {
-	config    ConnectorConfig
-	connector Connector
+	config     ConnectorConfig
+	connectors map[message.MessageConnector]Connector
+	order      []message.MessageConnector
+}
+
+func NewMuitiChannelConnector(conns ...Connector) (*multiChannelConnector, error) {
+	if len(conns) == 0 {
+		return nil, ErrNoConnector
+	}
+
+	c := &multiChannelConnector{
+		config:     ConnectorConfig{ResponseMaxPool: 5},
+		connectors: make(map[message.MessageConnector]Connector, len(conns)),
+	}
+
+	for _, conn := range conns {
+		kind := conn.Kind()
+		if _, ok := c.connectors[kind]; ok {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicatedConnector, kind)
+		}
+		c.connectors[kind] = conn
+		c.order = append(c.order, kind)
+	}
+
+	return c, nil
 }
 
-func NewMuitiChannelConnector(conn Connector) *multiChannelConnector {
-	return &multiChannelConnector{connector: conn, config: ConnectorConfig{ResponseMaxPool: 5}}
+// Supports reports whether a connector of the given kind is registered.
+func (c *multiChannelConnector) Supports(kind message.MessageConnector) bool {
+	_, ok := c.connectors[kind]
+	return ok
 }
 
-func (c *multiChannelConnector) Request(ctx *ChatContext, input chan<- message.Message) {
-	err := c.connector.Acquire(ctx, input)
-	if err != nil {
-		ctx.SendEvent(NewEventError(err))
+func (c *multiChannelConnector) SetConfig(config ConnectorConfig) {
+	c.config = config
+}
+
+// Request runs the Acquire loop of every registered connector and returns
+// once all of them have returned. A failing connector stops the others and
+// its error is returned.
+func (c *multiChannelConnector) Request(ctx *ChatContext, input chan<- message.Message) error {
+	acquireCtx := ctx.WithCancel()
+	defer acquireCtx.Shutdown()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for _, kind := range c.order {
+		wg.Add(1)
+		go func(conn Connector) {
+			defer wg.Done()
+			err := c.acquire(acquireCtx, conn, input)
+			if err != nil {
+				ctx.Logger().Error("connector stopped", slog.String(LogConnector, string(conn.Kind())), slog.Any("error", err))
+				ctx.SendEvent(NewEventError(err))
+				once.Do(func() {
+					firstErr = err
+					acquireCtx.Shutdown()
+				})
+			}
+		}(c.connectors[kind])
 	}
+	wg.Wait()
+
+	return firstErr
 }
 
+// Response dispatches every output message through the connector it was
+// acquired from, selected by message.Message.Connector. Once output is closed
+// it waits for the pending dispatches before returning.
 func (c *multiChannelConnector) Response(ctx *ChatContext, output <-chan message.Message) {
 	sem := make(chan struct{}, c.config.ResponseMaxPool)
+	ctx.metrics.poolSize.Set(float64(c.config.ResponseMaxPool), poolResponse)
+	var wg sync.WaitGroup
 	for {
 		select {
 		case msg, ok := <-output:
 			if !ok {
+				wg.Wait()
 				return
 			}
+			wg.Add(1)
 			go func(m message.Message) {
+				defer wg.Done()
 				sem <- struct{}{}
 				defer func() { <-sem }()
-				err := c.connector.Dispatch(msg)
+				defer ctx.metrics.acquire(poolResponse)()
+
+				if ctx.delivery != nil {
+					m = withDeliveryID(m)
+					if err := ctx.delivery.Accept(ctx.Context(), m); err != nil {
+						ctx.Logger().Error("accept delivery", append(replyLogAttrs(m), slog.Any("error", err))...)
+					}
+				}
 
-				event := NewEvent(msg)
+				defer func() {
+					if r := recover(); r != nil {
+						stack := debug.Stack()
+						ctx.Logger().Error("connector dispatch panicked", append(replyLogAttrs(m), slog.Any("panic", r))...)
+						if ctx.delivery != nil {
+							ctx.delivery.Failed(ctx.Context(), m, &PanicError{Value: r, Stack: stack})
+						}
+						ctx.SendEvent(NewEventPanic(&m, r, stack))
+					}
+				}()
+
+				span := ctx.startSpan(spanFromMessage(m), "connector.dispatch", &m)
+				err := c.dispatch(m)
+				span.end(err)
+				var event Event
+				if err != nil {
+					retry := ctx.delivery != nil && ctx.delivery.Failed(ctx.Context(), m, err)
+					ctx.Logger().Error("dispatch message", append(replyLogAttrs(m), slog.Any("error", err), slog.Bool("retry", retry))...)
+					ctx.metrics.dispatchErrors.Add(1, string(m.Connector))
+					event = NewPayloadEvent(&m, DispatchFailed{Message: m, Err: err, Retry: retry})
+					event.Error = err
+				} else {
+					event = NewPayloadEvent(&m, Dispatched{Message: m})
+					ctx.Logger().Debug("message dispatched", replyLogAttrs(m)...)
+					ctx.metrics.sent.Add(1, string(m.Connector))
+					if ctx.delivery != nil {
+						if err := ctx.delivery.Delivered(ctx.Context(), m); err != nil {
+							ctx.Logger().Error("complete delivery", append(replyLogAttrs(m), slog.Any("error", err))...)
+						}
+					}
+				}
+				entry := transcriptEntry(m, Outbound, m.Output, ctx.now())
+				for _, opt := range m.Options {
+					entry.Options = append(entry.Options, opt.ID)
+				}
 				if err != nil {
-					event.WithError(err)
+					entry.Error = err.Error()
 				}
-				ctx.SendEvent(*event)
+				ctx.record(entry)
+				ctx.SendEvent(event)
 
 			}(msg)
 		case <-ctx.Done():
@@ -55,3 +173,32 @@ func (c *multiChannelConnector) Response(ctx *ChatContext, output <-chan message
 		}
 	}
 }
+
+// acquire runs the Acquire loop of conn, turning a panic into an error, and
+// reports the connector down once it returns.
+func (c *multiChannelConnector) acquire(ctx *ChatContext, conn Connector, input chan<- message.Message) (err error) {
+	component := ConnectorHealth(conn.Kind())
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+		if err != nil {
+			ctx.ReportHealth(component, HealthDown, err.Error())
+		} else {
+			ctx.ReportHealth(component, HealthDown, "stopped")
+		}
+		ctx.emit(nil, ConnectorStopped{Connector: conn.Kind(), Err: err})
+	}()
+
+	ctx.ReportHealth(component, HealthUp, "")
+	ctx.emit(nil, ConnectorStarted{Connector: conn.Kind()})
+	return conn.Acquire(ctx, input)
+}
+
+func (c *multiChannelConnector) dispatch(msg message.Message) error {
+	conn, ok := c.connectors[msg.Connector]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownConnector, msg.Connector)
+	}
+	return conn.Dispatch(msg)
+}
@@ -28,12 +28,15 @@ func TestMultiChannelConnector(t *testing.T) {
 		input := make(chan message.Message)
 
 		mockConnector.EXPECT().
-			Acquire(chatCtx, input).
+			Acquire(gomock.Any(), input).
 			Return(nil).
 			Times(1)
 
-		mc := core.NewMuitiChannelConnector(mockConnector)
-		mc.Request(chatCtx, input)
+		mockConnector.EXPECT().Kind().Return(message.Test).AnyTimes()
+
+		mc, err := core.NewMuitiChannelConnector(mockConnector)
+		assert.NoError(t, err)
+		assert.NoError(t, mc.Request(chatCtx, input))
 	})
 
 	t.Run("calls Acquire on connector when Request is invoked with error", func(t *testing.T) {
@@ -48,11 +51,14 @@ func TestMultiChannelConnector(t *testing.T) {
 		input := make(chan message.Message)
 
 		mockConnector.EXPECT().
-			Acquire(chatCtx, input).
+			Acquire(gomock.Any(), input).
 			Return(assert.AnError).
 			Times(1)
 
-		mc := core.NewMuitiChannelConnector(mockConnector)
+		mockConnector.EXPECT().Kind().Return(message.Test).AnyTimes()
+
+		mc, err := core.NewMuitiChannelConnector(mockConnector)
+		assert.NoError(t, err)
 		go mc.Request(chatCtx, input)
 
 		select {
@@ -88,8 +94,8 @@ func TestMultiChannelConnector(t *testing.T) {
 			close(event)
 		}()
 
-		msg1 := message.Message{User: message.User{ID: "a"}, Input: "Hello"}
-		msg2 := message.Message{User: message.User{ID: "b"}, Input: "World"}
+		msg1 := message.Message{User: message.User{ID: "a"}, Input: "Hello", Connector: message.Test}
+		msg2 := message.Message{User: message.User{ID: "b"}, Input: "World", Connector: message.Test}
 
 		mockConnector.EXPECT().
 			Dispatch(msg1).
@@ -101,7 +107,10 @@ func TestMultiChannelConnector(t *testing.T) {
 			Return(nil).
 			Times(1)
 
-		mc := core.NewMuitiChannelConnector(mockConnector)
+		mockConnector.EXPECT().Kind().Return(message.Test).AnyTimes()
+
+		mc, err := core.NewMuitiChannelConnector(mockConnector)
+		assert.NoError(t, err)
 
 		output <- msg1
 		output <- msg2
@@ -125,7 +134,10 @@ func TestMultiChannelConnector(t *testing.T) {
 
 		output := make(chan message.Message)
 
-		mc := core.NewMuitiChannelConnector(mockConnector)
+		mockConnector.EXPECT().Kind().Return(message.Test).AnyTimes()
+
+		mc, err := core.NewMuitiChannelConnector(mockConnector)
+		assert.NoError(t, err)
 
 		chatCtx.Shutdown()
 
@@ -142,4 +154,188 @@ func TestMultiChannelConnector(t *testing.T) {
 			t.Error("Response did not return after context shutdown")
 		}
 	})
+	t.Run("fails without connectors", func(t *testing.T) {
+		t.Parallel()
+
+		mc, err := core.NewMuitiChannelConnector()
+		assert.Nil(t, mc)
+		assert.ErrorIs(t, err, core.ErrNoConnector)
+	})
+
+	t.Run("fails on duplicated connector kind", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		first := mocks.NewMockConnector(ctrl)
+		second := mocks.NewMockConnector(ctrl)
+		first.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		second.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+
+		mc, err := core.NewMuitiChannelConnector(first, second)
+		assert.Nil(t, mc)
+		assert.ErrorIs(t, err, core.ErrDuplicatedConnector)
+	})
+
+	t.Run("runs Acquire on every connector", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		telegram := mocks.NewMockConnector(ctrl)
+		cli := mocks.NewMockConnector(ctrl)
+		telegram.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		cli.EXPECT().Kind().Return(message.Cli).AnyTimes()
+
+		chatCtx := core.NewChatContext(make(chan core.Event))
+		input := make(chan message.Message)
+
+		telegram.EXPECT().Acquire(gomock.Any(), input).Return(nil).Times(1)
+		cli.EXPECT().Acquire(gomock.Any(), input).Return(nil).Times(1)
+
+		mc, err := core.NewMuitiChannelConnector(telegram, cli)
+		assert.NoError(t, err)
+		assert.NoError(t, mc.Request(chatCtx, input))
+	})
+
+	t.Run("stops the other connectors when one fails", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		telegram := mocks.NewMockConnector(ctrl)
+		cli := mocks.NewMockConnector(ctrl)
+		telegram.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		cli.EXPECT().Kind().Return(message.Cli).AnyTimes()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		defer chatCtx.Shutdown()
+		input := make(chan message.Message)
+
+		telegram.EXPECT().Acquire(gomock.Any(), input).Return(assert.AnError).Times(1)
+		cli.EXPECT().Acquire(gomock.Any(), input).DoAndReturn(
+			func(ctx *core.ChatContext, _ chan<- message.Message) error {
+				<-ctx.Done()
+				return nil
+			}).Times(1)
+
+		mc, err := core.NewMuitiChannelConnector(telegram, cli)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, mc.Request(chatCtx, input), assert.AnError)
+		assert.True(t, chatCtx.IsActive())
+	})
+
+	t.Run("dispatches each message through its own connector", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		telegram := mocks.NewMockConnector(ctrl)
+		cli := mocks.NewMockConnector(ctrl)
+		telegram.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		cli.EXPECT().Kind().Return(message.Cli).AnyTimes()
+
+		event := make(chan core.Event, 3)
+		chatCtx := core.NewChatContext(event)
+		defer chatCtx.Shutdown()
+
+		telegramMsg := message.Message{User: message.User{ID: "a"}, Output: "oi", Connector: message.Telegram}
+		cliMsg := message.Message{User: message.User{ID: "b"}, Output: "hi", Connector: message.Cli}
+		unknownMsg := message.Message{User: message.User{ID: "c"}, Output: "??", Connector: "slack"}
+
+		telegram.EXPECT().Dispatch(telegramMsg).Return(nil).Times(1)
+		cli.EXPECT().Dispatch(cliMsg).Return(nil).Times(1)
+
+		mc, err := core.NewMuitiChannelConnector(telegram, cli)
+		assert.NoError(t, err)
+
+		output := make(chan message.Message, 3)
+		output <- telegramMsg
+		output <- cliMsg
+		output <- unknownMsg
+		close(output)
+
+		mc.Response(chatCtx, output)
+
+		var failed []core.Event
+		for range 3 {
+			select {
+			case evt := <-event:
+				if evt.Error != nil {
+					failed = append(failed, evt)
+				} else {
+					assert.Equal(t, core.EventReplyDispatched, evt.Type)
+				}
+			case <-time.After(200 * time.Millisecond):
+				t.Fatal("expected event, but none was received")
+			}
+		}
+
+		if assert.Len(t, failed, 1) {
+			assert.ErrorIs(t, failed[0].Error, core.ErrUnknownConnector)
+			assert.Equal(t, "c", failed[0].Msg.User.ID)
+			assert.Equal(t, core.EventDispatchFailed, failed[0].Type)
+			payload, ok := failed[0].Payload.(core.DispatchFailed)
+			assert.True(t, ok)
+			assert.False(t, payload.Retry)
+		}
+	})
+	t.Run("recovers a panicking dispatch", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+
+		msg := message.Message{User: message.User{ID: "ace"}, Connector: message.Test}
+		conn.EXPECT().Dispatch(msg).DoAndReturn(func(message.Message) error {
+			panic("dispatch boom")
+		})
+
+		event := make(chan core.Event, 1)
+		chatCtx := core.NewChatContext(event)
+		defer chatCtx.Shutdown()
+
+		mc, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+
+		output := make(chan message.Message, 1)
+		output <- msg
+		close(output)
+		mc.Response(chatCtx, output)
+
+		evt := <-event
+		assert.Equal(t, core.EventPanic, evt.Type)
+		assert.Equal(t, "ace", evt.Msg.User.ID)
+	})
+
+	t.Run("turns a panicking acquire into an error", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(*core.ChatContext, chan<- message.Message) error {
+				panic("acquire boom")
+			})
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		defer chatCtx.Shutdown()
+
+		mc, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+
+		var panicErr *core.PanicError
+		assert.ErrorAs(t, mc.Request(chatCtx, make(chan message.Message)), &panicErr)
+		assert.Equal(t, "acquire boom", panicErr.Value)
+	})
 }
@@ -3,15 +3,29 @@ package core
 
 import (
 	"context"
-	"github.com/guiflemes/ohmychat/message"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
 	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
 )
 
 const (
 	ReplyDispatched = 1 << 0
 )
 
+const DefaultHandlerTimeout = 60 * time.Second
+
+// ErrReplyDropped reports a reply sent once its message was done being
+// handled.
+var ErrReplyDropped = errors.New("reply dropped")
+
 type SessionAdapter interface {
+	// GetOrCreate returns the session of sessionID, creating it with
+	// NewSession(ctx, ...) if there is none.
 	GetOrCreate(ctx context.Context, sessionID string) (*Session, error)
 	Save(ctx context.Context, session *Session) error
 }
@@ -24,57 +38,235 @@ func WithSessionAdapter(adapater SessionAdapter) ChatContextOption {
 	}
 }
 
+// WithClock sets the clock used for session activity and expiry, time.Now by
+// default. Tests use it to expire sessions without waiting.
+func WithClock(now func() time.Time) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.now = now
+	}
+}
+
+// WithEventBus publishes the events on bus instead of sending them to the
+// event channel, so handling a message never waits on a slow subscriber
+// beyond its overflow policy. The lifecycle events, such as
+// EventMessageReceived or EventStateChanged, are only published when bus has
+// a subscriber for them.
+func WithEventBus(bus *EventBus) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.events = bus
+	}
+}
+
+// WithValues sets the global scope of values, for services provided before
+// the ChatContext is created.
+func WithValues(values *Values) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.values = values
+	}
+}
+
+// WithHandlerTimeout sets how long a single message may be handled before its
+// child Context is cancelled.
+func WithHandlerTimeout(timeout time.Duration) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.handlerTimeout = timeout
+	}
+}
+
 type ChatContext struct {
 	ctx            context.Context
 	cancel         context.CancelFunc
-	metadata       map[string]any
+	values         *Values
 	shutdownCh     chan struct{}
+	shutdownOnce   *sync.Once
 	eventCh        chan<- Event
+	events         *EventBus
 	sessionAdapter SessionAdapter
+	sessionLocks   *sessionLocks
+	// unwatch removes the hooks watchSessions registered on the session
+	// adapter, nil on the copies of WithCancel.
+	unwatch         func()
+	scheduler       Scheduler
+	handlerTimeout  time.Duration
+	metricsRegistry metrics.Registry
+	metrics         *pipelineMetrics
+	spanExporter    SpanExporter
+	logger          *slog.Logger
+	transcript      TranscriptStore
+	delivery        Delivery
+	health          *Health
+	now             func() time.Time
 }
 
 func NewChatContext(eventCh chan<- Event, options ...ChatContextOption) *ChatContext {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	chatCtx := &ChatContext{
-		ctx:        ctx,
-		cancel:     cancel,
-		metadata:   make(map[string]any),
-		shutdownCh: make(chan struct{}),
-		eventCh:    eventCh,
+		ctx:            ctx,
+		cancel:         cancel,
+		shutdownCh:     make(chan struct{}),
+		shutdownOnce:   &sync.Once{},
+		eventCh:        eventCh,
+		sessionLocks:   newSessionLocks(),
+		handlerTimeout: DefaultHandlerTimeout,
+		now:            time.Now,
 	}
 
 	for _, opt := range options {
 		opt(chatCtx)
 	}
 
+	if chatCtx.values == nil {
+		chatCtx.values = NewValues()
+	}
+
 	if chatCtx.sessionAdapter == nil {
 		chatCtx.sessionAdapter = NewInMemorySessionRepo()
 	}
 
+	chatCtx.checkSessions()
+	chatCtx.watchSessions()
+
+	if chatCtx.logger == nil {
+		chatCtx.logger = slog.New(discardHandler{})
+	}
+
+	if chatCtx.metricsRegistry == nil {
+		chatCtx.metricsRegistry = metrics.Nop{}
+	}
+	chatCtx.metrics = newPipelineMetrics(chatCtx.metricsRegistry)
+	registerSessions(chatCtx.metricsRegistry, chatCtx.sessionAdapter)
+	if chatCtx.events != nil {
+		registerEvents(chatCtx.metricsRegistry, chatCtx.events)
+		chatCtx.checkEvents()
+	}
+
 	return chatCtx
 }
 
+// WithCancel returns a copy of c sharing its events, sessions and values
+// whose Done channel is closed when c is shut down or when Shutdown is called
+// on the copy, leaving c untouched.
+func (c *ChatContext) WithCancel() *ChatContext {
+	ctx, cancel := context.WithCancel(c.ctx)
+	child := *c
+	child.ctx = ctx
+	child.cancel = cancel
+	child.shutdownCh = make(chan struct{})
+	child.shutdownOnce = &sync.Once{}
+	child.unwatch = nil
+	return &child
+}
+
+// SendEvent publishes event on the event bus, if any. Otherwise it queues
+// event on the event channel, waiting for room until c is done. An event
+// there is room for is queued even once c is done, so events about stopping,
+// such as EventConnectorStopped, are not lost.
 func (c *ChatContext) SendEvent(event Event) {
-	c.eventCh <- event
+	if c.events != nil {
+		c.events.Publish(event)
+		return
+	}
+
+	select {
+	case c.eventCh <- event:
+		return
+	default:
+	}
+
+	select {
+	case c.eventCh <- event:
+	case <-c.ctx.Done():
+	}
+}
+
+// emit sends a lifecycle event if the event bus has a subscriber for it.
+func (c *ChatContext) emit(msg *message.Message, payload EventPayload) {
+	if c.events == nil || !c.events.Wants(payload.EventType()) {
+		return
+	}
+	c.SendEvent(NewPayloadEvent(msg, payload))
+}
+
+// watchSessions keeps the adapter from expiring the sessions in use and turns
+// the sessions it creates and expires into events, until c is shut down.
+func (c *ChatContext) watchSessions() {
+	var unregister []func()
+	c.unwatch = func() {
+		for _, fn := range unregister {
+			fn()
+		}
+	}
+
+	if adapter, ok := c.sessionAdapter.(GuardedSessionAdapter); ok {
+		unregister = append(unregister, adapter.GuardSessions(c.sessionLocks.held))
+	}
+	if c.events == nil {
+		return
+	}
+	if adapter, ok := c.sessionAdapter.(CreatingSessionAdapter); ok {
+		unregister = append(unregister, adapter.OnCreated(func(sess Session) {
+			c.emit(nil, SessionCreated{Session: sess})
+		}))
+	}
+	if adapter, ok := c.sessionAdapter.(ExpiringSessionAdapter); ok {
+		unregister = append(unregister, adapter.OnExpired(func(sess Session) {
+			c.emit(nil, SessionExpired{Session: sess})
+		}))
+	}
 }
 
 func (c *ChatContext) SaveSession(ctx context.Context, session *Session) error {
-	session.LastActivityAt = time.Now()
+	span := c.startSpan(spanFromContext(ctx), "session.save", nil)
+	span.setAttribute("user.id", session.UserID)
+
+	session.LastActivityAt = c.now()
 	err := c.sessionAdapter.Save(ctx, session)
+	span.end(err)
 	if err != nil {
+		attrs := append([]any{
+			slog.String(LogUserID, session.UserID),
+			slog.String(LogConnector, string(session.Connector)),
+		}, SessionLogAttrs(session)...)
+		c.logger.Error("save session", append(attrs, slog.Any("error", err))...)
 		c.SendEvent(NewEventError(err))
 	}
 	return err
 }
 
+// UpdateSession runs fn on the session identified by key while none of its
+// messages is being handled, then saves it.
+func (c *ChatContext) UpdateSession(ctx context.Context, key string, fn func(*Session)) error {
+	unlock := c.lockSession(key)
+	defer unlock()
+
+	sess, err := c.sessionAdapter.GetOrCreate(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	fn(sess)
+	return c.SaveSession(ctx, sess)
+}
+
+func (c *ChatContext) lockSession(key string) (unlock func()) {
+	return c.sessionLocks.lock(key)
+}
+
 func (c *ChatContext) Context() context.Context {
 	return c.ctx
 }
 
+// Shutdown cancels c. Shutting down the ChatContext NewChatContext returned
+// also unregisters it from the session adapter.
 func (c *ChatContext) Shutdown() {
-	c.cancel()
-	close(c.shutdownCh)
+	c.shutdownOnce.Do(func() {
+		c.cancel()
+		close(c.shutdownCh)
+		if c.unwatch != nil {
+			c.unwatch()
+		}
+	})
 }
 
 func (c *ChatContext) Done() <-chan struct{} {
@@ -90,31 +282,53 @@ func (c *ChatContext) IsActive() bool {
 	}
 }
 
+// Set stores value under key in the global scope of values.
 func (c *ChatContext) Set(key string, value any) {
-	c.metadata[key] = value
+	c.values.set(valueKey{name: key}, value)
 }
 
 func (c *ChatContext) Get(key string) (any, bool) {
-	v, ok := c.metadata[key]
-	return v, ok
+	return c.values.lookup(valueKey{name: key})
+}
+
+// Values returns the global scope of values, shared by every session.
+func (c *ChatContext) Values() *Values {
+	return c.values
+}
+
+func (c *ChatContext) lookup(key valueKey) (any, bool) {
+	return c.values.lookup(key)
 }
 
 func (c *ChatContext) NewChildContext(msg message.Message, outputCh chan<- message.Message) (*Context, error) {
-	ctx, cancel := context.WithTimeout(c.ctx, 60*time.Second)
+	ctx, cancel := context.WithTimeout(c.ctx, c.handlerTimeout)
+	if sc := spanFromMessage(msg); sc.traceID != "" {
+		ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	}
 
-	sess, err := c.sessionAdapter.GetOrCreate(ctx, msg.User.ID)
+	span := c.startSpan(spanFromMessage(msg), "session.load", &msg)
+	sess, err := c.sessionAdapter.GetOrCreate(withSessionMessage(ctx, msg), SessionKey(msg))
+	span.end(err)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
+	sess.UserID = msg.User.ID
+	sess.Connector = msg.Connector
+	sess.ChannelID = msg.ChannelID
+	sess.now = c.now
+	if sess.values == nil {
+		sess.values = NewValues()
+	}
 
 	return &Context{
-		ctx:             ctx,
-		cancel:          cancel,
-		parent:          c,
-		session:         sess,
-		outputCh:        outputCh,
-		replyDispatched: ReplyDispatched,
+		ctx:      ctx,
+		cancel:   cancel,
+		parent:   c,
+		msg:      msg,
+		session:  sess,
+		outputCh: outputCh,
+		values:   NewValues(),
 	}, nil
 }
 
@@ -123,8 +337,11 @@ type Context struct {
 	cancel          context.CancelFunc
 	session         *Session
 	parent          *ChatContext
+	msg             message.Message
 	outputCh        chan<- message.Message
 	replyDispatched uint8
+	transferTo      *string
+	values          *Values
 }
 
 func (c *Context) Context() context.Context {
@@ -148,16 +365,79 @@ func (c *Context) Session() *Session {
 	return c.session
 }
 
+// Values returns the values of the message being handled, gone once it is.
+// Middlewares use it to hand values, such as the authenticated account, to
+// the actions.
+func (c *Context) Values() *Values {
+	return c.values
+}
+
+// SessionValues returns the values of the session, kept as long as the
+// session adapter keeps the session.
+func (c *Context) SessionValues() *Values {
+	return c.session.values
+}
+
+func (c *Context) lookup(key valueKey) (any, bool) {
+	if value, ok := c.values.lookup(key); ok {
+		return value, true
+	}
+	if value, ok := c.session.values.lookup(key); ok {
+		return value, true
+	}
+	return c.parent.lookup(key)
+}
+
 func (c *Context) SetSessionState(state SessionState) {
 	c.session.State = state
 }
 
+func (c *Context) SendEvent(event Event) {
+	c.parent.SendEvent(event)
+}
+
+// TransferTo hands the conversation over to the engine registered under name
+// in the router engine serving this message. An empty name lets the router
+// pick the engine again on the next message.
+func (c *Context) TransferTo(engine string) {
+	c.transferTo = &engine
+}
+
+// ConsumeTransfer returns the engine requested through TransferTo, if any,
+// and clears the request.
+func (c *Context) ConsumeTransfer() (string, bool) {
+	if c.transferTo == nil {
+		return "", false
+	}
+	engine := *c.transferTo
+	c.transferTo = nil
+	return engine, true
+}
+
 func (c *Context) MessageHasBeenReplyed() bool {
 	return c.replyDispatched != 0
 }
 
+// SendOutput saves the session and hands msg to the dispatcher. A reply
+// there is no room for once the message is done being handled, e.g. after
+// the handler timed out, is dropped and reported through an error event.
 func (c *Context) SendOutput(msg *message.Message) {
 	c.parent.SaveSession(c.Context(), c.session)
-	c.replyDispatched |= ReplyDispatched
-	c.outputCh <- *msg
+	reply := withSessionMeta(*msg, c.session)
+
+	select {
+	case c.outputCh <- reply:
+		c.replyDispatched |= ReplyDispatched
+		return
+	default:
+	}
+
+	select {
+	case c.outputCh <- reply:
+		c.replyDispatched |= ReplyDispatched
+	case <-c.ctx.Done():
+		err := fmt.Errorf("%w: %w", ErrReplyDropped, c.ctx.Err())
+		c.Logger().Warn("reply dropped", slog.Any("error", err))
+		c.SendEvent(NewEventErrorWithMessage(*msg, err))
+	}
 }
@@ -1,6 +1,7 @@
 package core_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -40,7 +41,7 @@ func TestChatContextAndContext(t *testing.T) {
 		session := &core.Session{UserID: "abc", Memory: make(map[string]any), State: core.IdleState{}}
 
 		mockAdapter.EXPECT().
-			GetOrCreate(gomock.Any(), "abc").
+			GetOrCreate(gomock.Any(), "telegram:abc").
 			Return(session, nil).
 			Times(1)
 
@@ -49,13 +50,14 @@ func TestChatContextAndContext(t *testing.T) {
 			core.WithSessionAdapter(mockAdapter),
 		)
 
-		msg := message.Message{User: message.User{ID: "abc"}}
+		msg := message.Message{User: message.User{ID: "abc"}, Connector: message.Telegram}
 		output := make(chan message.Message, 1)
 
 		child, err := chatCtx.NewChildContext(msg, output)
 		assert.NoError(t, err)
 		assert.NotNil(t, child)
 		assert.Equal(t, session, child.Session())
+		assert.False(t, child.MessageHasBeenReplyed())
 	})
 
 	t.Run("send output sends message and saves session", func(t *testing.T) {
@@ -68,7 +70,7 @@ func TestChatContextAndContext(t *testing.T) {
 		session := &core.Session{UserID: "kizaru", Memory: make(map[string]any), State: core.IdleState{}}
 
 		mockAdapter.EXPECT().
-			GetOrCreate(gomock.Any(), "kizaru").
+			GetOrCreate(gomock.Any(), "telegram:kizaru").
 			Return(session, nil).
 			Times(1)
 
@@ -82,7 +84,7 @@ func TestChatContextAndContext(t *testing.T) {
 			core.WithSessionAdapter(mockAdapter),
 		)
 
-		msg := message.Message{User: message.User{ID: "kizaru"}}
+		msg := message.Message{User: message.User{ID: "kizaru"}, Connector: message.Telegram}
 		output := make(chan message.Message, 1)
 
 		childCtx, err := chatCtx.NewChildContext(msg, output)
@@ -90,6 +92,7 @@ func TestChatContextAndContext(t *testing.T) {
 
 		toSend := &message.Message{User: message.User{ID: "kizaru"}, Input: "hello!"}
 		childCtx.SendOutput(toSend)
+		assert.True(t, childCtx.MessageHasBeenReplyed())
 
 		select {
 		case received := <-output:
@@ -99,4 +102,238 @@ func TestChatContextAndContext(t *testing.T) {
 			t.Fatal("expected message on output channel")
 		}
 	})
+
+	t.Run("send output reports a reply dropped after the handler is done", func(t *testing.T) {
+		t.Parallel()
+
+		events := make(chan core.Event, 1)
+		chatCtx := core.NewChatContext(events)
+		defer chatCtx.Shutdown()
+
+		msg := message.Message{User: message.User{ID: "aokiji"}, Connector: message.Cli}
+		childCtx, err := chatCtx.NewChildContext(msg, make(chan message.Message))
+		assert.NoError(t, err)
+		childCtx.Cancel()
+
+		childCtx.SendOutput(&message.Message{User: msg.User, Output: "too late"})
+		assert.False(t, childCtx.MessageHasBeenReplyed())
+
+		event := <-events
+		assert.Equal(t, core.EventError, event.Type)
+		assert.ErrorIs(t, event.Error, core.ErrReplyDropped)
+		assert.Equal(t, "too late", event.Msg.Output)
+	})
+
+	t.Run("shutdown unregisters from the session adapter", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute))
+		bus := core.NewEventBus()
+		created := 0
+		bus.Subscribe(func(core.Event) { created++ }, core.SubscribeWithTypes(core.EventSessionCreated))
+
+		chatCtx := core.NewChatContext(nil, core.WithSessionAdapter(repo), core.WithEventBus(bus))
+		chatCtx.WithCancel().Shutdown()
+		_, _ = repo.GetOrCreate(context.Background(), "still watched")
+		chatCtx.Shutdown()
+		_, _ = repo.GetOrCreate(context.Background(), "not watched")
+
+		assert.NoError(t, bus.Flush(context.Background()))
+		assert.Equal(t, 1, created)
+	})
+
+	t.Run("cancelled copy does not stop its parent", func(t *testing.T) {
+		t.Parallel()
+
+		parent := core.NewChatContext(make(chan core.Event))
+		child := parent.WithCancel()
+
+		child.Shutdown()
+		child.Shutdown()
+		assert.False(t, child.IsActive())
+		assert.True(t, parent.IsActive())
+
+		other := parent.WithCancel()
+		parent.Shutdown()
+		assert.False(t, other.IsActive())
+	})
+
+	t.Run("send event does not block once shut down", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := core.NewChatContext(make(chan core.Event))
+		ctx.Shutdown()
+
+		done := make(chan struct{})
+		go func() {
+			ctx.SendEvent(core.NewEventError(assert.AnError))
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("SendEvent blocked after shutdown")
+		}
+	})
+	t.Run("child context honours the handler timeout", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event), core.WithHandlerTimeout(time.Second))
+		defer chatCtx.Shutdown()
+
+		child, err := chatCtx.NewChildContext(message.Message{User: message.User{ID: "jinbe"}}, nil)
+		assert.NoError(t, err)
+		defer child.Cancel()
+
+		deadline, ok := child.Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+	})
+	t.Run("sessions are not expired while a message is being handled", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute))
+		chatCtx := core.NewChatContext(make(chan core.Event), core.WithSessionAdapter(repo))
+		defer chatCtx.Shutdown()
+
+		handling := make(chan struct{})
+		release := make(chan struct{})
+		engine := core.EngineFunc(func(*core.Context, *message.Message) {
+			close(handling)
+			<-release
+		})
+
+		input := make(chan message.Message, 1)
+		input <- message.Message{User: message.User{ID: "ace"}, Connector: message.Cli}
+		close(input)
+		done := make(chan struct{})
+		go func() {
+			core.NewProcessor(engine).Process(chatCtx, input, make(chan message.Message))
+			close(done)
+		}()
+		<-handling
+
+		assert.Zero(t, repo.Sweep(time.Now().Add(time.Hour)))
+		close(release)
+		<-done
+		assert.Equal(t, 1, repo.Sweep(time.Now().Add(time.Hour)))
+	})
+
+	t.Run("update session waits for the message being handled", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo()
+		chatCtx := core.NewChatContext(make(chan core.Event), core.WithSessionAdapter(repo))
+		defer chatCtx.Shutdown()
+
+		handling := make(chan struct{})
+		release := make(chan struct{})
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			close(handling)
+			<-release
+			ctx.SetSessionState(core.WaitingInputState{})
+		})
+
+		input := make(chan message.Message, 1)
+		msg := message.Message{User: message.User{ID: "marco"}, Connector: message.Cli}
+		input <- msg
+		go core.NewProcessor(engine).Process(chatCtx, input, make(chan message.Message))
+		<-handling
+
+		updated := make(chan struct{})
+		go func() {
+			err := chatCtx.UpdateSession(context.Background(), core.SessionKey(msg), func(s *core.Session) {
+				s.State = core.IdleState{}
+			})
+			assert.NoError(t, err)
+			close(updated)
+		}()
+
+		select {
+		case <-updated:
+			t.Fatal("session updated while a message was being handled")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		<-updated
+
+		sess, _ := repo.GetOrCreate(context.Background(), core.SessionKey(msg))
+		assert.IsType(t, core.IdleState{}, sess.State)
+	})
+	t.Run("schedules messages through the configured scheduler", func(t *testing.T) {
+		t.Parallel()
+
+		scheduler := &stubScheduler{}
+		chatCtx := core.NewChatContext(make(chan core.Event), core.WithScheduler(scheduler))
+		defer chatCtx.Shutdown()
+
+		child, err := chatCtx.NewChildContext(message.Message{User: message.User{ID: "boa"}}, nil)
+		assert.NoError(t, err)
+		defer child.Cancel()
+
+		id, err := child.Schedule(time.Hour, &message.Message{Output: "ainda está aí?"})
+		assert.NoError(t, err)
+		assert.Equal(t, "job-1", id)
+		assert.Equal(t, time.Hour, scheduler.after)
+		assert.Equal(t, "ainda está aí?", scheduler.msg.Output)
+
+		assert.NoError(t, child.CancelSchedule(id))
+		assert.Equal(t, "job-1", scheduler.cancelled)
+	})
+
+	t.Run("schedule fails without a scheduler", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event))
+		defer chatCtx.Shutdown()
+
+		child, err := chatCtx.NewChildContext(message.Message{User: message.User{ID: "boa"}}, nil)
+		assert.NoError(t, err)
+		defer child.Cancel()
+
+		_, err = child.Schedule(time.Hour, &message.Message{})
+		assert.ErrorIs(t, err, core.ErrNoScheduler)
+		assert.ErrorIs(t, child.CancelSchedule("x"), core.ErrNoScheduler)
+	})
+
+	t.Run("transfer request is consumed once", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		defer chatCtx.Shutdown()
+
+		child, err := chatCtx.NewChildContext(message.Message{User: message.User{ID: "franky"}}, nil)
+		assert.NoError(t, err)
+		defer child.Cancel()
+
+		_, ok := child.ConsumeTransfer()
+		assert.False(t, ok)
+
+		child.TransferTo("orders")
+		engine, ok := child.ConsumeTransfer()
+		assert.True(t, ok)
+		assert.Equal(t, "orders", engine)
+
+		_, ok = child.ConsumeTransfer()
+		assert.False(t, ok)
+	})
+}
+
+type stubScheduler struct {
+	after     time.Duration
+	msg       message.Message
+	cancelled string
+}
+
+func (s *stubScheduler) Schedule(_ context.Context, after time.Duration, msg message.Message) (string, error) {
+	s.after = after
+	s.msg = msg
+	return "job-1", nil
+}
+
+func (s *stubScheduler) Cancel(_ context.Context, id string) error {
+	s.cancelled = id
+	return nil
 }
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// DeliveryIDMeta is the metadata entry identifying a reply across its
+// dispatch attempts, as replies to the same message share its ID.
+const DeliveryIDMeta = "delivery_id"
+
+// Delivery keeps track of the replies handed to the connectors, so the ones
+// failing to dispatch can be retried instead of being lost.
+type Delivery interface {
+	// Accept is called before every dispatch attempt of msg.
+	Accept(ctx context.Context, msg message.Message) error
+	// Delivered is called once msg has been dispatched.
+	Delivered(ctx context.Context, msg message.Message) error
+	// Failed is called when dispatching msg failed and reports whether it
+	// will be attempted again.
+	Failed(ctx context.Context, msg message.Message, err error) (retry bool)
+}
+
+func WithDelivery(delivery Delivery) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.delivery = delivery
+	}
+}
+
+// DeliveryID returns the delivery ID carried by msg, if any.
+func DeliveryID(msg message.Message) string {
+	return msg.Meta.Get(DeliveryIDMeta)
+}
+
+// withDeliveryID gives msg a delivery ID unless it already has one.
+func withDeliveryID(msg message.Message) message.Message {
+	if DeliveryID(msg) != "" {
+		return msg
+	}
+	msg.SetMeta(DeliveryIDMeta, randomID(16))
+	return msg
+}
+
+// RetryAfterError is returned by connectors told by their service to wait
+// before sending again, e.g. on a Telegram 429.
+type RetryAfterError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", e.Err, e.After)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter returns the wait asked for by err, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) {
+		return retryErr.After, true
+	}
+	return 0, false
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a dispatch error that retrying cannot fix, such as an
+// invalid chat or a user blocking the bot.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked by Permanent, or comes from a
+// connector that is not registered.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent) || errors.Is(err, ErrUnknownConnector)
+}
@@ -0,0 +1,136 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type deliveryRecorder struct {
+	mu        sync.Mutex
+	accepted  []message.Message
+	delivered []message.Message
+	failed    []error
+}
+
+func (d *deliveryRecorder) Accept(_ context.Context, msg message.Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.accepted = append(d.accepted, msg)
+	return nil
+}
+
+func (d *deliveryRecorder) Delivered(_ context.Context, msg message.Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delivered = append(d.delivered, msg)
+	return nil
+}
+
+func (d *deliveryRecorder) Failed(_ context.Context, _ message.Message, err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failed = append(d.failed, err)
+	return true
+}
+
+func TestDelivery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("tracks every dispatch attempt by delivery ID", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		boom := errors.New("boom")
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			if m.Output == "falha" {
+				return boom
+			}
+			return nil
+		}).Times(2)
+
+		recorder := &deliveryRecorder{}
+		chatCtx := core.NewChatContext(make(chan core.Event, 2), core.WithDelivery(recorder))
+		defer chatCtx.Shutdown()
+
+		mc, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+
+		output := make(chan message.Message, 2)
+		output <- message.Message{ID: "1", Output: "ok", Connector: message.Test}
+		output <- message.Message{ID: "1", Output: "falha", Connector: message.Test}
+		close(output)
+		mc.Response(chatCtx, output)
+
+		assert.Len(t, recorder.accepted, 2)
+		assert.NotEqual(t, core.DeliveryID(recorder.accepted[0]), core.DeliveryID(recorder.accepted[1]))
+		if assert.Len(t, recorder.delivered, 1) {
+			assert.NotEmpty(t, core.DeliveryID(recorder.delivered[0]))
+			assert.Equal(t, "ok", recorder.delivered[0].Output)
+		}
+		if assert.Len(t, recorder.failed, 1) {
+			assert.ErrorIs(t, recorder.failed[0], boom)
+		}
+	})
+
+	t.Run("keeps the delivery ID of a retried reply", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Dispatch(gomock.Any()).Return(nil)
+
+		recorder := &deliveryRecorder{}
+		chatCtx := core.NewChatContext(make(chan core.Event, 1), core.WithDelivery(recorder))
+		defer chatCtx.Shutdown()
+
+		mc, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+
+		msg := message.Message{Connector: message.Test}
+		msg.SetMeta(core.DeliveryIDMeta, "d-1")
+
+		output := make(chan message.Message, 1)
+		output <- msg
+		close(output)
+		mc.Response(chatCtx, output)
+
+		assert.Equal(t, "d-1", core.DeliveryID(recorder.delivered[0]))
+	})
+
+	t.Run("tells retry-after and permanent errors apart", func(t *testing.T) {
+		t.Parallel()
+
+		boom := errors.New("boom")
+
+		after, ok := core.RetryAfter(fmt.Errorf("send: %w", &core.RetryAfterError{After: 3 * time.Second, Err: boom}))
+		assert.True(t, ok)
+		assert.Equal(t, 3*time.Second, after)
+
+		_, ok = core.RetryAfter(boom)
+		assert.False(t, ok)
+
+		assert.True(t, core.IsPermanent(fmt.Errorf("send: %w", core.Permanent(boom))))
+		assert.ErrorIs(t, core.Permanent(boom), boom)
+		assert.True(t, core.IsPermanent(core.ErrUnknownConnector))
+		assert.False(t, core.IsPermanent(boom))
+		assert.NoError(t, core.Permanent(nil))
+	})
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
-	"github.com/guiflemes/ohmychat/message"
+	"context"
+	"fmt"
 	"time"
+
+	"github.com/guiflemes/ohmychat/message"
 )
 
 type EventType uint8
@@ -10,13 +13,65 @@ type EventType uint8
 const (
 	EventSuccess EventType = iota
 	EventError
+	EventTimeout
+	EventPanic
+	EventRateLimited
+	EventMessageReceived
+	EventHandlerStarted
+	EventHandlerFinished
+	EventReplyDispatched
+	EventDispatchFailed
+	EventSessionCreated
+	EventStateChanged
+	EventSessionExpired
+	EventConnectorStarted
+	EventConnectorStopped
 )
 
+var eventTypeNames = [...]string{
+	EventSuccess:          "success",
+	EventError:            "error",
+	EventTimeout:          "timeout",
+	EventPanic:            "panic",
+	EventRateLimited:      "rate_limited",
+	EventMessageReceived:  "message_received",
+	EventHandlerStarted:   "handler_started",
+	EventHandlerFinished:  "handler_finished",
+	EventReplyDispatched:  "reply_dispatched",
+	EventDispatchFailed:   "dispatch_failed",
+	EventSessionCreated:   "session_created",
+	EventStateChanged:     "state_changed",
+	EventSessionExpired:   "session_expired",
+	EventConnectorStarted: "connector_started",
+	EventConnectorStopped: "connector_stopped",
+}
+
+func (t EventType) String() string {
+	if int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return fmt.Sprintf("EventType(%d)", t)
+}
+
+// PanicError carries a value recovered from a panicking goroutine together
+// with the stack trace at the moment of the panic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
 type Event struct {
 	Type  EventType
 	Msg   *message.Message
 	Error error
 	Time  time.Time
+	// Payload holds the details of lifecycle events, one type per EventType.
+	// It is nil for the other events.
+	Payload EventPayload
 }
 
 func (e *Event) WithError(err error) {
@@ -50,9 +105,38 @@ func NewEventErrorWithMessage(msg message.Message, err error) Event {
 	}
 }
 
+func NewEventTimeout(msg message.Message, err error) Event {
+	return Event{
+		Type:  EventTimeout,
+		Msg:   &msg,
+		Error: err,
+		Time:  time.Now(),
+	}
+}
+
+// NewEventPanic builds an event for a recovered panic. msg is the message being
+// handled when the goroutine panicked, if any.
+func NewEventPanic(msg *message.Message, value any, stack []byte) Event {
+	return Event{
+		Type:  EventPanic,
+		Msg:   msg,
+		Error: &PanicError{Value: value, Stack: stack},
+		Time:  time.Now(),
+	}
+}
+
+func NewEventRateLimited(msg message.Message, err error) Event {
+	return Event{
+		Type:  EventRateLimited,
+		Msg:   &msg,
+		Error: err,
+		Time:  time.Now(),
+	}
+}
+
 func NewEventSuccess(msg message.Message) Event {
 	return Event{
-		Type:  EventError,
+		Type:  EventSuccess,
 		Msg:   &msg,
 		Error: nil,
 		Time:  time.Now(),
@@ -61,21 +145,34 @@ func NewEventSuccess(msg message.Message) Event {
 
 type EventHandlerOption func(h *EventHandler)
 
+// EventWithMaxPool sets how many calls of the callback may run at once, 5 by
+// default.
 func EventWithMaxPool(maxPool uint8) EventHandlerOption {
 	return func(h *EventHandler) {
 		h.maxPool = maxPool
 	}
 }
 
+// EventWithCallback subscribes callback to every event.
 func EventWithCallback(callback OnEvent) EventHandlerOption {
 	return func(h *EventHandler) {
 		h.onEvent = callback
 	}
 }
 
+// EventWithBus publishes the events on bus instead of a bus of its own, so
+// subscribers can be added before and while the handler runs.
+func EventWithBus(bus *EventBus) EventHandlerOption {
+	return func(h *EventHandler) {
+		h.bus = bus
+	}
+}
+
 type EventHandler struct {
-	maxPool uint8
-	onEvent OnEvent
+	maxPool     uint8
+	onEvent     OnEvent
+	bus         *EventBus
+	unsubscribe func()
 }
 
 func NewEventHandler(options ...EventHandlerOption) *EventHandler {
@@ -85,28 +182,64 @@ func NewEventHandler(options ...EventHandlerOption) *EventHandler {
 		opt(handler)
 	}
 
+	if handler.bus == nil {
+		handler.bus = NewEventBus()
+	}
+	handler.SetCallback(handler.onEvent)
+
 	return handler
 }
 
-func (e *EventHandler) SetCallback(cb func(Event)) {
-	e.onEvent = cb
+// SetCallback replaces the callback subscribed through EventWithCallback or a
+// previous SetCallback. A nil cb only removes it.
+func (h *EventHandler) SetCallback(cb func(Event)) {
+	if h.unsubscribe != nil {
+		h.unsubscribe()
+		h.unsubscribe = nil
+	}
+	h.onEvent = cb
+	if cb != nil {
+		h.unsubscribe = h.bus.Subscribe(cb, SubscribeWithWorkers(int(h.maxPool)))
+	}
+}
+
+// Bus returns the bus the events are published on.
+func (h *EventHandler) Bus() *EventBus {
+	return h.bus
 }
 
+// Handler publishes events on the bus until cCtx is done or eventCh is
+// closed. Events already buffered when cCtx is done are still published, and
+// Handler waits for the subscribers to be done with them before returning.
 func (h *EventHandler) Handler(cCtx *ChatContext, eventCh <-chan Event) {
-	sem := make(chan struct{}, h.maxPool)
+	defer func() {
+		h.bus.Flush(context.Background())
+		cCtx.ReportHealth(HealthEvents, HealthDown, "stopped")
+	}()
+
+	cCtx.checkHealth(HealthEvents, func(context.Context) ComponentHealth {
+		return queueHealth(len(eventCh), cap(eventCh), cap(eventCh) > 0 && len(eventCh) == cap(eventCh))
+	})
+
 	for {
 		select {
-		case e := <-eventCh:
-			go func(event Event) {
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				if h.onEvent != nil {
-					h.onEvent(event)
-				}
-			}(e)
+		case e, ok := <-eventCh:
+			if !ok {
+				return
+			}
+			h.bus.Publish(e)
 		case <-cCtx.Done():
-			return
+			for {
+				select {
+				case e, ok := <-eventCh:
+					if !ok {
+						return
+					}
+					h.bus.Publish(e)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
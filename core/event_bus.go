@@ -0,0 +1,370 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy tells what a subscriber queue does with an event published
+// while it is full.
+type OverflowPolicy uint8
+
+const (
+	// DropOldest makes room by dropping the oldest queued event.
+	DropOldest OverflowPolicy = iota
+	// DropNewest drops the event being published.
+	DropNewest
+	// Block makes the publisher wait for room, dropping the event being
+	// published once the block timeout expires.
+	Block
+)
+
+const (
+	DefaultEventQueueSize = 256
+	DefaultBlockTimeout   = 100 * time.Millisecond
+)
+
+type EventBusOption func(b *EventBus)
+
+// EventBusWithQueue sets the queue size and overflow policy of subscribers
+// not choosing their own, DefaultEventQueueSize and DropOldest by default.
+func EventBusWithQueue(size int, policy OverflowPolicy) EventBusOption {
+	return func(b *EventBus) {
+		b.queueSize = size
+		b.policy = policy
+	}
+}
+
+type SubscribeOption func(s *subscription)
+
+// SubscribeWithTypes only delivers events of the given types. Every event is
+// delivered by default.
+func SubscribeWithTypes(types ...EventType) SubscribeOption {
+	return func(s *subscription) {
+		s.types = slices.Clone(types)
+	}
+}
+
+// SubscribeWithQueue sets the size of the subscriber queue and what to do
+// when it is full.
+func SubscribeWithQueue(size int, policy OverflowPolicy) SubscribeOption {
+	return func(s *subscription) {
+		s.size = size
+		s.policy = policy
+	}
+}
+
+// SubscribeWithBlockTimeout sets how long a publisher may wait for room with
+// the Block policy, DefaultBlockTimeout by default.
+func SubscribeWithBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(s *subscription) {
+		s.timeout = timeout
+	}
+}
+
+// SubscribeWithWorkers serves the subscriber with n goroutines, so up to n
+// events are delivered at once and not necessarily in order. One by default.
+func SubscribeWithWorkers(n int) SubscribeOption {
+	return func(s *subscription) {
+		s.workers = n
+	}
+}
+
+// SubscribeWithName names the subscriber in Stats.
+func SubscribeWithName(name string) SubscribeOption {
+	return func(s *subscription) {
+		s.name = name
+	}
+}
+
+// SubscriberStats describes the queue of a subscriber.
+type SubscriberStats struct {
+	Name     string
+	Queued   int
+	Capacity int
+	// Dropped counts the events lost to a full queue.
+	Dropped uint64
+}
+
+// EventBus fans events out to its subscribers, each receiving the event types
+// it subscribed to. Every subscriber has a bounded queue served by its own
+// goroutine, so a slow subscriber neither delays the publisher, within its
+// overflow policy, nor the other subscribers.
+type EventBus struct {
+	queueSize int
+	policy    OverflowPolicy
+
+	mu     sync.RWMutex
+	subs   []*subscription
+	seq    int
+	wanted [len(eventTypeNames)]int
+	all    int
+
+	dropped atomic.Uint64
+
+	pendingMu sync.Mutex
+	pending   int
+	// drained is closed once no event is pending.
+	drained chan struct{}
+}
+
+type subscription struct {
+	name    string
+	fn      OnEvent
+	types   []EventType
+	size    int
+	policy  OverflowPolicy
+	timeout time.Duration
+	workers int
+	queue   chan Event
+	dropped atomic.Uint64
+
+	// done is closed on unsubscribe, releasing the publishers waiting for
+	// room. mu keeps the queue from being closed while they send to it.
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (s *subscription) wants(t EventType) bool {
+	return len(s.types) == 0 || slices.Contains(s.types, t)
+}
+
+func NewEventBus(opts ...EventBusOption) *EventBus {
+	b := &EventBus{
+		queueSize: DefaultEventQueueSize,
+		policy:    DropOldest,
+		drained:   make(chan struct{}),
+	}
+	close(b.drained)
+
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscribe calls fn with the published events, in order, from a goroutine
+// of its own, unless SubscribeWithWorkers says otherwise. Events still queued
+// when unsubscribe is called are delivered before the goroutines stop.
+func (b *EventBus) Subscribe(fn OnEvent, opts ...SubscribeOption) (unsubscribe func()) {
+	sub := &subscription{
+		fn:      fn,
+		size:    b.queueSize,
+		policy:  b.policy,
+		timeout: DefaultBlockTimeout,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	sub.queue = make(chan Event, max(sub.size, 1))
+	sub.done = make(chan struct{})
+
+	b.mu.Lock()
+	b.seq++
+	if sub.name == "" {
+		sub.name = fmt.Sprintf("subscriber-%d", b.seq)
+	}
+	b.subs = append(slices.Clip(b.subs), sub)
+	b.count(sub, 1)
+	b.mu.Unlock()
+
+	for range max(sub.workers, 1) {
+		go b.serve(sub)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			i := slices.Index(b.subs, sub)
+			b.subs = slices.Delete(slices.Clone(b.subs), i, i+1)
+			b.count(sub, -1)
+			b.mu.Unlock()
+
+			close(sub.done)
+			sub.mu.Lock()
+			defer sub.mu.Unlock()
+			sub.closed = true
+			close(sub.queue)
+		})
+	}
+}
+
+func (b *EventBus) count(sub *subscription, delta int) {
+	if len(sub.types) == 0 {
+		b.all += delta
+		return
+	}
+	for _, t := range slices.Compact(slices.Sorted(slices.Values(sub.types))) {
+		if int(t) < len(b.wanted) {
+			b.wanted[t] += delta
+		}
+	}
+}
+
+// Wants reports whether any subscriber receives events of type t.
+func (b *EventBus) Wants(t EventType) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.all > 0 || (int(t) < len(b.wanted) && b.wanted[t] > 0)
+}
+
+// Publish queues event for the subscribers of event.Type. It only waits for
+// subscribers with the Block policy and a full queue.
+func (b *EventBus) Publish(event Event) {
+	// subs is replaced rather than modified, so the snapshot can be used
+	// without the lock while waiting for room
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.wants(event.Type) {
+			b.enqueue(sub, event)
+		}
+	}
+}
+
+// enqueue queues event for sub, unless sub was unsubscribed since Publish
+// took its snapshot.
+func (b *EventBus) enqueue(sub *subscription, event Event) {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+
+	if sub.closed {
+		return
+	}
+	b.track(1)
+
+	select {
+	case sub.queue <- event:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case DropNewest:
+		b.drop(sub)
+	case Block:
+		timer := time.NewTimer(sub.timeout)
+		defer timer.Stop()
+		select {
+		case sub.queue <- event:
+		case <-timer.C:
+			b.drop(sub)
+		case <-sub.done:
+			b.drop(sub)
+		}
+	default:
+		for {
+			select {
+			case <-sub.queue:
+				b.drop(sub)
+			default:
+			}
+			select {
+			case sub.queue <- event:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func (b *EventBus) drop(sub *subscription) {
+	sub.dropped.Add(1)
+	b.dropped.Add(1)
+	b.track(-1)
+}
+
+// track counts the events queued and not delivered yet.
+func (b *EventBus) track(delta int) {
+	b.pendingMu.Lock()
+	defer b.pendingMu.Unlock()
+
+	if b.pending == 0 && delta > 0 {
+		b.drained = make(chan struct{})
+	}
+	b.pending += delta
+	if b.pending == 0 {
+		close(b.drained)
+	}
+}
+
+func (b *EventBus) serve(sub *subscription) {
+	for event := range sub.queue {
+		b.deliver(sub, event)
+		b.track(-1)
+	}
+}
+
+// deliver calls the subscriber, reporting a panic through a panic event. A
+// panic raised while delivering a panic event is dropped.
+func (b *EventBus) deliver(sub *subscription, event Event) {
+	defer func() {
+		if r := recover(); r != nil && event.Type != EventPanic {
+			b.Publish(NewEventPanic(event.Msg, r, debug.Stack()))
+		}
+	}()
+
+	sub.fn(event)
+}
+
+// Flush waits until the events published so far, and those their
+// subscribers publish in turn, are delivered or dropped, or until ctx is
+// done.
+func (b *EventBus) Flush(ctx context.Context) error {
+	for {
+		b.pendingMu.Lock()
+		pending, drained := b.pending, b.drained
+		b.pendingMu.Unlock()
+
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Dropped returns how many events the subscriber queues dropped so far.
+func (b *EventBus) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Stats describes the queue of every subscriber.
+func (b *EventBus) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := make([]SubscriberStats, 0, len(b.subs))
+	for _, sub := range b.subs {
+		stats = append(stats, SubscriberStats{
+			Name:     sub.name,
+			Queued:   len(sub.queue),
+			Capacity: cap(sub.queue),
+			Dropped:  sub.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// On subscribes fn to the events carrying a payload of type P.
+func On[P EventPayload](bus *EventBus, fn func(event Event, payload P), opts ...SubscribeOption) (unsubscribe func()) {
+	var zero P
+	opts = append(slices.Clip(opts), SubscribeWithTypes(zero.EventType()))
+	return bus.Subscribe(func(event Event) {
+		if payload, ok := event.Payload.(P); ok {
+			fn(event, payload)
+		}
+	}, opts...)
+}
@@ -0,0 +1,362 @@
+package core_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventBus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("delivers events to the subscribers of their type", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		var all, errs []core.EventType
+		bus.Subscribe(func(e core.Event) { all = append(all, e.Type) })
+		bus.Subscribe(func(e core.Event) { errs = append(errs, e.Type) }, core.SubscribeWithTypes(core.EventError, core.EventTimeout))
+
+		bus.Publish(core.NewEventError(assert.AnError))
+		bus.Publish(core.NewEventSuccess(message.Message{}))
+		bus.Publish(core.NewEventTimeout(message.Message{}, assert.AnError))
+		assert.NoError(t, bus.Flush(context.Background()))
+
+		assert.Equal(t, []core.EventType{core.EventError, core.EventSuccess, core.EventTimeout}, all)
+		assert.Equal(t, []core.EventType{core.EventError, core.EventTimeout}, errs)
+	})
+
+	t.Run("stops delivering once unsubscribed", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		count := 0
+		unsubscribe := bus.Subscribe(func(core.Event) { count++ }, core.SubscribeWithTypes(core.EventStateChanged))
+		assert.True(t, bus.Wants(core.EventStateChanged))
+		assert.False(t, bus.Wants(core.EventSessionCreated))
+
+		bus.Publish(core.NewPayloadEvent(nil, core.StateChanged{}))
+		unsubscribe()
+		unsubscribe()
+		bus.Publish(core.NewPayloadEvent(nil, core.StateChanged{}))
+		assert.NoError(t, bus.Flush(context.Background()))
+
+		assert.Equal(t, 1, count)
+		assert.False(t, bus.Wants(core.EventStateChanged))
+	})
+
+	t.Run("serves a subscriber with several workers", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		var started sync.WaitGroup
+		started.Add(2)
+		bus.Subscribe(func(core.Event) {
+			started.Done()
+			started.Wait()
+		}, core.SubscribeWithWorkers(2))
+
+		bus.Publish(core.NewEventError(assert.AnError))
+		bus.Publish(core.NewEventError(assert.AnError))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, bus.Flush(ctx), "both events are delivered at once")
+	})
+
+	t.Run("passes typed payloads", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		var changes []core.StateChanged
+		core.On(bus, func(e core.Event, p core.StateChanged) {
+			assert.Equal(t, core.EventStateChanged, e.Type)
+			changes = append(changes, p)
+		})
+
+		bus.Publish(core.NewPayloadEvent(nil, core.StateChanged{UserID: "luffy", Old: core.IdleState{}, New: core.WaitingInputState{}}))
+		bus.Publish(core.NewPayloadEvent(nil, core.SessionCreated{}))
+		assert.NoError(t, bus.Flush(context.Background()))
+
+		if assert.Len(t, changes, 1) {
+			assert.Equal(t, "luffy", changes[0].UserID)
+			assert.IsType(t, core.WaitingInputState{}, changes[0].New)
+		}
+	})
+
+	t.Run("reports a panicking subscriber", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		var panics []core.Event
+		bus.Subscribe(func(core.Event) { panic("boom") }, core.SubscribeWithTypes(core.EventError, core.EventPanic))
+		bus.Subscribe(func(e core.Event) { panics = append(panics, e) }, core.SubscribeWithTypes(core.EventPanic))
+
+		bus.Publish(core.NewEventError(assert.AnError))
+		assert.NoError(t, bus.Flush(context.Background()))
+
+		if assert.Len(t, panics, 1) {
+			var panicErr *core.PanicError
+			assert.ErrorAs(t, panics[0].Error, &panicErr)
+			assert.Equal(t, "boom", panicErr.Value)
+		}
+	})
+
+	t.Run("reports the sessions created and expired", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute), core.WithSessionClock(func() time.Time { return now }))
+		bus := core.NewEventBus()
+		eventCh := make(chan core.Event, 2)
+		bus.Subscribe(func(e core.Event) { eventCh <- e }, core.SubscribeWithTypes(core.EventSessionCreated, core.EventSessionExpired))
+
+		ctx := core.NewChatContext(nil, core.WithSessionAdapter(repo), core.WithEventBus(bus))
+		defer ctx.Shutdown()
+
+		msg := message.Message{User: message.User{ID: "nami"}, Connector: message.Cli, ChannelID: "CLI"}
+		_, err := ctx.NewChildContext(msg, nil)
+		assert.NoError(t, err)
+		repo.Sweep(now.Add(2 * time.Minute))
+
+		created, expired := <-eventCh, <-eventCh
+		assert.Equal(t, core.EventSessionCreated, created.Type)
+		sess := created.Payload.(core.SessionCreated).Session
+		assert.Equal(t, core.SessionKey(msg), sess.ID)
+		assert.Equal(t, "nami", sess.UserID)
+		assert.Equal(t, message.Cli, sess.Connector)
+		assert.Equal(t, "CLI", sess.ChannelID)
+		assert.Equal(t, core.EventSessionExpired, expired.Type)
+		assert.Equal(t, "nami", expired.Payload.(core.SessionExpired).Session.UserID)
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				bus.Subscribe(func(core.Event) {}, core.SubscribeWithTypes(core.EventError))()
+			}()
+			go func() {
+				defer wg.Done()
+				bus.Publish(core.NewEventError(assert.AnError))
+			}()
+		}
+		wg.Wait()
+		assert.False(t, bus.Wants(core.EventError))
+		assert.NoError(t, bus.Flush(context.Background()))
+	})
+}
+
+// stalled subscribes a subscriber stuck on its first event until release is
+// called, with a queue of two events. It returns once the first event,
+// msg-0, is being delivered.
+func stalled(t *testing.T, opts ...core.SubscribeOption) (bus *core.EventBus, delivered func() []string, release func()) {
+	t.Helper()
+
+	bus = core.NewEventBus()
+	var (
+		mu   sync.Mutex
+		ids  []string
+		gate = make(chan struct{})
+		busy = make(chan struct{})
+		once sync.Once
+	)
+	bus.Subscribe(func(e core.Event) {
+		once.Do(func() {
+			close(busy)
+			<-gate
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, e.Msg.ID)
+	}, opts...)
+
+	bus.Publish(*core.NewEvent(message.Message{ID: "msg-0"}))
+	<-busy
+
+	delivered = func() []string {
+		assert.NoError(t, bus.Flush(context.Background()))
+		mu.Lock()
+		defer mu.Unlock()
+		return ids
+	}
+	return bus, delivered, func() { close(gate) }
+}
+
+func publish(bus *core.EventBus, ids ...int) {
+	for _, id := range ids {
+		bus.Publish(*core.NewEvent(message.Message{ID: fmt.Sprintf("msg-%d", id)}))
+	}
+}
+
+func TestEventBus_Overflow(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drops the oldest queued event", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t, core.SubscribeWithQueue(2, core.DropOldest))
+		publish(bus, 1, 2, 3)
+		release()
+
+		assert.Equal(t, []string{"msg-0", "msg-2", "msg-3"}, delivered())
+		assert.Equal(t, uint64(1), bus.Dropped())
+	})
+
+	t.Run("drops the newest event", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t, core.SubscribeWithQueue(2, core.DropNewest), core.SubscribeWithName("audit"))
+		publish(bus, 1, 2, 3)
+
+		stats := bus.Stats()
+		if assert.Len(t, stats, 1) {
+			assert.Equal(t, core.SubscriberStats{Name: "audit", Queued: 2, Capacity: 2, Dropped: 1}, stats[0])
+		}
+		release()
+
+		assert.Equal(t, []string{"msg-0", "msg-1", "msg-2"}, delivered())
+	})
+
+	t.Run("blocks until the timeout", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t, core.SubscribeWithQueue(2, core.Block), core.SubscribeWithBlockTimeout(10*time.Millisecond))
+		start := time.Now()
+		publish(bus, 1, 2, 3)
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+		release()
+
+		assert.Equal(t, []string{"msg-0", "msg-1", "msg-2"}, delivered())
+		assert.Equal(t, uint64(1), bus.Dropped())
+	})
+
+	t.Run("blocks until there is room", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t, core.SubscribeWithQueue(2, core.Block), core.SubscribeWithBlockTimeout(time.Minute))
+		publish(bus, 1, 2)
+		time.AfterFunc(10*time.Millisecond, release)
+		publish(bus, 3)
+
+		assert.Equal(t, []string{"msg-0", "msg-1", "msg-2", "msg-3"}, delivered())
+		assert.Zero(t, bus.Dropped())
+	})
+
+	t.Run("a blocked publisher does not hold up subscribing", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t, core.SubscribeWithQueue(2, core.Block), core.SubscribeWithBlockTimeout(time.Minute))
+		publish(bus, 1, 2)
+		published := make(chan struct{})
+		go func() {
+			defer close(published)
+			publish(bus, 3)
+		}()
+
+		subscribed := make(chan struct{})
+		go func() {
+			defer close(subscribed)
+			bus.Subscribe(func(core.Event) {})()
+		}()
+		select {
+		case <-subscribed:
+		case <-time.After(time.Second):
+			t.Fatal("subscribing waited for the blocked publisher")
+		}
+
+		release()
+		<-published
+		assert.Equal(t, []string{"msg-0", "msg-1", "msg-2", "msg-3"}, delivered())
+	})
+
+	t.Run("unsubscribing releases a blocked publisher", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		gate := make(chan struct{})
+		defer close(gate)
+		unsubscribe := bus.Subscribe(func(core.Event) { <-gate }, core.SubscribeWithQueue(1, core.Block), core.SubscribeWithBlockTimeout(time.Minute))
+		publish(bus, 0, 1)
+
+		published := make(chan struct{})
+		go func() {
+			defer close(published)
+			publish(bus, 2)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		go unsubscribe()
+
+		select {
+		case <-published:
+		case <-time.After(time.Second):
+			t.Fatal("publisher still blocked after unsubscribe")
+		}
+	})
+
+	t.Run("gives up flushing when the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		bus, delivered, release := stalled(t)
+		publish(bus, 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, bus.Flush(ctx), context.DeadlineExceeded)
+
+		release()
+		assert.Equal(t, []string{"msg-0", "msg-1"}, delivered())
+	})
+}
+
+func TestChatContext_SendEvent(t *testing.T) {
+	t.Parallel()
+
+	t.Run("does not wait for a slow subscriber", func(t *testing.T) {
+		t.Parallel()
+
+		bus := core.NewEventBus()
+		gate := make(chan struct{})
+		defer close(gate)
+		bus.Subscribe(func(core.Event) { <-gate }, core.SubscribeWithQueue(1, core.DropOldest))
+
+		ctx := core.NewChatContext(nil, core.WithEventBus(bus))
+		defer ctx.Shutdown()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range 10 {
+				ctx.SendEvent(core.NewEventError(assert.AnError))
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("SendEvent waited for the subscriber")
+		}
+		assert.NotZero(t, bus.Dropped())
+	})
+}
+
+func TestNewEventSuccess(t *testing.T) {
+	t.Parallel()
+
+	event := core.NewEventSuccess(message.Message{ID: "1"})
+	assert.Equal(t, core.EventSuccess, event.Type)
+	assert.NoError(t, event.Error)
+	assert.Equal(t, "success", event.Type.String())
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// EventPayload is the typed content of a lifecycle event.
+type EventPayload interface {
+	EventType() EventType
+}
+
+// NewPayloadEvent builds the event carrying payload. msg is the message the
+// event is about, if any.
+func NewPayloadEvent(msg *message.Message, payload EventPayload) Event {
+	return Event{
+		Type:    payload.EventType(),
+		Msg:     msg,
+		Time:    time.Now(),
+		Payload: payload,
+	}
+}
+
+// MessageReceived is published when the processor picks up an inbound
+// message.
+type MessageReceived struct {
+	Message message.Message
+}
+
+// HandlerStarted is published before the engine handles a message.
+type HandlerStarted struct {
+	Message message.Message
+	State   SessionState
+}
+
+// HandlerFinished is published once the engine is done with a message.
+type HandlerFinished struct {
+	Message  message.Message
+	Duration time.Duration
+	Replied  bool
+	Panicked bool
+	// Err is set when the handler ran out of time.
+	Err error
+}
+
+// Dispatched is published when a connector delivered a reply.
+type Dispatched struct {
+	Message message.Message
+}
+
+// DispatchFailed is published when a connector could not deliver a reply.
+type DispatchFailed struct {
+	Message message.Message
+	Err     error
+	// Retry tells whether the delivery will be attempted again.
+	Retry bool
+}
+
+// SessionCreated is published when the session adapter creates a session.
+type SessionCreated struct {
+	Session Session
+}
+
+// StateChanged is published when handling a message left its session in
+// another state.
+type StateChanged struct {
+	UserID string
+	Old    SessionState
+	New    SessionState
+}
+
+// SessionExpired is published when the session adapter removes a session
+// for inactivity.
+type SessionExpired struct {
+	Session Session
+}
+
+// ConnectorStarted is published when a connector starts acquiring messages.
+type ConnectorStarted struct {
+	Connector message.MessageConnector
+}
+
+// ConnectorStopped is published when the Acquire loop of a connector
+// returned. Err is nil when it stopped because the bot did.
+type ConnectorStopped struct {
+	Connector message.MessageConnector
+	Err       error
+}
+
+func (MessageReceived) EventType() EventType  { return EventMessageReceived }
+func (HandlerStarted) EventType() EventType   { return EventHandlerStarted }
+func (HandlerFinished) EventType() EventType  { return EventHandlerFinished }
+func (Dispatched) EventType() EventType       { return EventReplyDispatched }
+func (DispatchFailed) EventType() EventType   { return EventDispatchFailed }
+func (SessionCreated) EventType() EventType   { return EventSessionCreated }
+func (StateChanged) EventType() EventType     { return EventStateChanged }
+func (SessionExpired) EventType() EventType   { return EventSessionExpired }
+func (ConnectorStarted) EventType() EventType { return EventConnectorStarted }
+func (ConnectorStopped) EventType() EventType { return EventConnectorStopped }
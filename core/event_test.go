@@ -0,0 +1,136 @@
+package core_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("delivers events to the callback", func(t *testing.T) {
+		t.Parallel()
+
+		received := make(chan core.Event, 1)
+		handler := core.NewEventHandler(core.EventWithCallback(func(e core.Event) {
+			received <- e
+		}))
+
+		eventCh := make(chan core.Event)
+		ctx := core.NewChatContext(eventCh)
+		defer ctx.Shutdown()
+
+		go handler.Handler(ctx, eventCh)
+		eventCh <- core.NewEventError(assert.AnError)
+
+		select {
+		case evt := <-received:
+			assert.ErrorIs(t, evt.Error, assert.AnError)
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("expected event, but none was received")
+		}
+	})
+
+	t.Run("flushes buffered events on shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu    sync.Mutex
+			count int
+		)
+		handler := core.NewEventHandler(core.EventWithCallback(func(e core.Event) {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}))
+
+		eventCh := make(chan core.Event, 5)
+		ctx := core.NewChatContext(eventCh)
+		for range 5 {
+			eventCh <- core.NewEventError(assert.AnError)
+		}
+		ctx.Shutdown()
+
+		handler.Handler(ctx, eventCh)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("returns when the event channel is closed", func(t *testing.T) {
+		t.Parallel()
+
+		handler := core.NewEventHandler()
+		eventCh := make(chan core.Event)
+		ctx := core.NewChatContext(eventCh)
+		defer ctx.Shutdown()
+
+		close(eventCh)
+
+		done := make(chan struct{})
+		go func() {
+			handler.Handler(ctx, eventCh)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("Handler did not return after the channel was closed")
+		}
+	})
+	t.Run("reports a panicking callback as a panic event", func(t *testing.T) {
+		t.Parallel()
+
+		received := make(chan core.Event, 1)
+		handler := core.NewEventHandler(core.EventWithCallback(func(e core.Event) {
+			if e.Type != core.EventPanic {
+				panic("callback boom")
+			}
+			received <- e
+		}))
+
+		eventCh := make(chan core.Event)
+		ctx := core.NewChatContext(eventCh)
+		defer ctx.Shutdown()
+
+		go handler.Handler(ctx, eventCh)
+
+		msg := message.Message{User: message.User{ID: "shanks"}}
+		eventCh <- *core.NewEvent(msg)
+
+		select {
+		case evt := <-received:
+			assert.Equal(t, "shanks", evt.Msg.User.ID)
+			assert.ErrorContains(t, evt.Error, "callback boom")
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("expected panic event, but none was received")
+		}
+	})
+
+	t.Run("drops a panic raised while delivering a panic event", func(t *testing.T) {
+		t.Parallel()
+
+		calls := make(chan struct{}, 2)
+		handler := core.NewEventHandler(core.EventWithCallback(func(e core.Event) {
+			calls <- struct{}{}
+			panic("always")
+		}))
+
+		eventCh := make(chan core.Event, 1)
+		ctx := core.NewChatContext(eventCh)
+		eventCh <- core.NewEventError(assert.AnError)
+		ctx.Shutdown()
+
+		handler.Handler(ctx, eventCh)
+		assert.Len(t, calls, 2)
+	})
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// GateAction is what a Gate does with a message.
+type GateAction uint8
+
+const (
+	// Admit queues the message for its worker.
+	Admit GateAction = iota
+	// Reject discards the message, answering it with the verdict reply if
+	// there is one.
+	Reject
+	// Retry puts the message through the gates again once the verdict delay
+	// has passed.
+	Retry
+)
+
+// Verdict is what a Gate decided for a message.
+type Verdict struct {
+	Action GateAction
+	// Reply answers a rejected message when not empty.
+	Reply string
+	// After is how long a retried message waits.
+	After time.Duration
+}
+
+// Gate screens the inbound messages before they are queued for their worker,
+// so the messages it rejects or retries neither hold a worker nor load or
+// save a session. It runs on the goroutine feeding the workers and must not
+// block. It may change msg, e.g. to remember across retries when it first
+// saw it.
+type Gate func(ctx *ChatContext, msg *message.Message) Verdict
+
+// gateReply is the answer to a message rejected with reply.
+func gateReply(msg message.Message, reply string) message.Message {
+	msg.Output = reply
+	msg.Options = nil
+	msg.ResponseType = message.TextResponse
+	return msg
+}
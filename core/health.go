@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Components reporting to the health registry, besides one per connector.
+const (
+	HealthProcessor = "processor"
+	HealthEvents    = "events"
+	HealthSessions  = "sessions"
+)
+
+// DefaultPingTimeout bounds how long the session adapter may take to answer
+// a health check.
+const DefaultPingTimeout = 2 * time.Second
+
+type HealthStatus string
+
+const (
+	HealthUp       HealthStatus = "up"
+	HealthDegraded HealthStatus = "degraded"
+	HealthDown     HealthStatus = "down"
+)
+
+// worse reports whether s is worse than other.
+func (s HealthStatus) worse(other HealthStatus) bool {
+	rank := map[HealthStatus]int{HealthUp: 0, HealthDegraded: 1, HealthDown: 2}
+	return rank[s] > rank[other]
+}
+
+// Pinger is implemented by session adapters able to tell whether their
+// backend is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ComponentHealth is the state of a part of the pipeline.
+type ComponentHealth struct {
+	Status  HealthStatus   `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+	Since   time.Time      `json:"since"`
+}
+
+// HealthReport is the state of every component. Status is the worst of them.
+type HealthReport struct {
+	Status     HealthStatus               `json:"status"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// Ready reports whether no component is down.
+func (r HealthReport) Ready() bool {
+	return r.Status != HealthDown
+}
+
+// Health keeps the state of the components of a running pipeline. Components
+// either report their state as it changes, through Set, or are checked every
+// time a report is asked for, through Check.
+type Health struct {
+	mu       sync.Mutex
+	reported map[string]ComponentHealth
+	checks   map[string]func(ctx context.Context) ComponentHealth
+	// checked keeps the last result of each check, to tell since when its
+	// status holds.
+	checked map[string]ComponentHealth
+}
+
+func NewHealth() *Health {
+	return &Health{
+		reported: make(map[string]ComponentHealth),
+		checks:   make(map[string]func(ctx context.Context) ComponentHealth),
+		checked:  make(map[string]ComponentHealth),
+	}
+}
+
+// Set records the state of component, replacing its check if it had one.
+func (h *Health) Set(component string, status HealthStatus, msg string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.checks, component)
+	delete(h.checked, component)
+	since := time.Now()
+	if prev, ok := h.reported[component]; ok && prev.Status == status {
+		since = prev.Since
+	}
+	h.reported[component] = ComponentHealth{Status: status, Message: msg, Since: since}
+}
+
+// Check makes fn tell the state of component on every report. Since is filled
+// in by the registry.
+func (h *Health) Check(component string, fn func(ctx context.Context) ComponentHealth) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.reported, component)
+	h.checks[component] = fn
+}
+
+// Remove forgets component.
+func (h *Health) Remove(component string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.reported, component)
+	delete(h.checks, component)
+	delete(h.checked, component)
+}
+
+// Report returns the state of every component, running their checks.
+func (h *Health) Report(ctx context.Context) HealthReport {
+	h.mu.Lock()
+	report := HealthReport{Status: HealthUp, Components: make(map[string]ComponentHealth, len(h.reported)+len(h.checks))}
+	for name, c := range h.reported {
+		report.Components[name] = c
+	}
+	checks := make(map[string]func(ctx context.Context) ComponentHealth, len(h.checks))
+	for name, fn := range h.checks {
+		checks[name] = fn
+	}
+	h.mu.Unlock()
+
+	results := make(map[string]ComponentHealth, len(checks))
+	for name, fn := range checks {
+		results[name] = fn(ctx)
+	}
+
+	h.mu.Lock()
+	for name, c := range results {
+		c.Since = time.Now()
+		if prev, ok := h.checked[name]; ok && prev.Status == c.Status {
+			c.Since = prev.Since
+		}
+		if _, ok := h.checks[name]; ok {
+			h.checked[name] = c
+		}
+		report.Components[name] = c
+	}
+	h.mu.Unlock()
+
+	for _, c := range report.Components {
+		if c.Status.worse(report.Status) {
+			report.Status = c.Status
+		}
+	}
+	return report
+}
+
+// WithHealth makes the pipeline keep h up to date.
+func WithHealth(h *Health) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.health = h
+	}
+}
+
+// ConnectorHealth returns the component name of the connector of kind.
+func ConnectorHealth(kind message.MessageConnector) string {
+	return "connector." + string(kind)
+}
+
+// ReportHealth records the state of component, e.g. a connector losing its
+// link to the chat service. It does nothing without WithHealth.
+func (c *ChatContext) ReportHealth(component string, status HealthStatus, msg string) {
+	if c.health == nil {
+		return
+	}
+	c.health.Set(component, status, msg)
+}
+
+func (c *ChatContext) checkHealth(component string, fn func(ctx context.Context) ComponentHealth) {
+	if c.health == nil {
+		return
+	}
+	c.health.Check(component, fn)
+}
+
+// checkSessions checks the session adapter through Ping, when it has one.
+func (c *ChatContext) checkSessions() {
+	pinger, ok := c.sessionAdapter.(Pinger)
+	if !ok {
+		c.ReportHealth(HealthSessions, HealthUp, "")
+		return
+	}
+
+	c.checkHealth(HealthSessions, func(ctx context.Context) ComponentHealth {
+		ctx, cancel := context.WithTimeout(ctx, DefaultPingTimeout)
+		defer cancel()
+
+		if err := pinger.Ping(ctx); err != nil {
+			return ComponentHealth{Status: HealthDown, Message: err.Error()}
+		}
+		return ComponentHealth{Status: HealthUp}
+	})
+}
+
+// checkEvents checks the subscriber queues of the event bus, degraded while
+// any of them is full.
+func (c *ChatContext) checkEvents() {
+	c.checkHealth(HealthEvents, func(context.Context) ComponentHealth {
+		var depth, capacity int
+		full := false
+		for _, s := range c.events.Stats() {
+			depth += s.Queued
+			capacity += s.Capacity
+			full = full || s.Queued == s.Capacity
+		}
+		h := queueHealth(depth, capacity, full)
+		h.Data["dropped"] = c.events.Dropped()
+		return h
+	})
+}
+
+// queueHealth describes queues holding depth items out of capacity, degraded
+// once any of them is full.
+func queueHealth(depth, capacity int, full bool) ComponentHealth {
+	h := ComponentHealth{
+		Status: HealthUp,
+		Data:   map[string]any{"queue_depth": depth, "queue_capacity": capacity},
+	}
+	if full {
+		h.Status = HealthDegraded
+		h.Message = "queue full"
+	}
+	return h
+}
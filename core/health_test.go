@@ -0,0 +1,155 @@
+package core_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type pingingRepo struct {
+	*core.InMemorySessionRepo
+	err error
+}
+
+func (r *pingingRepo) Ping(context.Context) error {
+	return r.err
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("reports the worst status", func(t *testing.T) {
+		t.Parallel()
+
+		h := core.NewHealth()
+		report := h.Report(ctx)
+		assert.Equal(t, core.HealthUp, report.Status)
+		assert.True(t, report.Ready())
+
+		h.Set("a", core.HealthUp, "")
+		h.Check("b", func(context.Context) core.ComponentHealth {
+			return core.ComponentHealth{Status: core.HealthDegraded, Message: "slow"}
+		})
+		report = h.Report(ctx)
+		assert.Equal(t, core.HealthDegraded, report.Status)
+		assert.True(t, report.Ready())
+		assert.Equal(t, "slow", report.Components["b"].Message)
+		assert.False(t, report.Components["b"].Since.IsZero())
+
+		h.Set("b", core.HealthDown, "gone")
+		report = h.Report(ctx)
+		assert.Equal(t, core.HealthDown, report.Status)
+		assert.False(t, report.Ready())
+
+		h.Remove("b")
+		assert.Equal(t, core.HealthUp, h.Report(ctx).Status)
+	})
+
+	t.Run("keeps since while the status holds", func(t *testing.T) {
+		t.Parallel()
+
+		h := core.NewHealth()
+		h.Set("a", core.HealthDegraded, "connecting")
+		since := h.Report(ctx).Components["a"].Since
+
+		h.Set("a", core.HealthDegraded, "still connecting")
+		assert.Equal(t, since, h.Report(ctx).Components["a"].Since)
+
+		h.Check("b", func(context.Context) core.ComponentHealth { return core.ComponentHealth{Status: core.HealthUp} })
+		since = h.Report(ctx).Components["b"].Since
+		assert.Equal(t, since, h.Report(ctx).Components["b"].Since)
+	})
+
+	t.Run("pings the session adapter", func(t *testing.T) {
+		t.Parallel()
+
+		h := core.NewHealth()
+		repo := &pingingRepo{InMemorySessionRepo: core.NewInMemorySessionRepo()}
+		chatCtx := core.NewChatContext(make(chan core.Event), core.WithSessionAdapter(repo), core.WithHealth(h))
+		defer chatCtx.Shutdown()
+
+		assert.Equal(t, core.HealthUp, h.Report(ctx).Components[core.HealthSessions].Status)
+
+		repo.err = errors.New("unreachable")
+		sessions := h.Report(ctx).Components[core.HealthSessions]
+		assert.Equal(t, core.HealthDown, sessions.Status)
+		assert.Equal(t, "unreachable", sessions.Message)
+	})
+
+	t.Run("follows the connector acquire loop", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		h := core.NewHealth()
+		chatCtx := core.NewChatContext(make(chan core.Event, 1), core.WithHealth(h))
+		defer chatCtx.Shutdown()
+
+		component := core.ConnectorHealth(message.Test)
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(*core.ChatContext, chan<- message.Message) error {
+				assert.Equal(t, core.HealthUp, h.Report(ctx).Components[component].Status)
+				return errors.New("lost")
+			})
+
+		mc, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+		assert.Error(t, mc.Request(chatCtx, make(chan message.Message)))
+
+		down := h.Report(ctx).Components[component]
+		assert.Equal(t, core.HealthDown, down.Status)
+		assert.Equal(t, "lost", down.Message)
+	})
+
+	t.Run("reports full processor queues as degraded", func(t *testing.T) {
+		t.Parallel()
+
+		h := core.NewHealth()
+		chatCtx := core.NewChatContext(make(chan core.Event, 1), core.WithHealth(h))
+		defer chatCtx.Shutdown()
+
+		var once sync.Once
+		started := make(chan struct{})
+		release := make(chan struct{})
+		engine := core.EngineFunc(func(*core.Context, *message.Message) {
+			once.Do(func() { close(started) })
+			<-release
+		})
+		processor := core.NewProcessor(engine, core.ProcessWithMaxPool(1), core.ProcessWithQueueSize(1))
+
+		input := make(chan message.Message, 2)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			processor.Process(chatCtx, input, make(chan message.Message, 2))
+		}()
+
+		input <- message.Message{User: message.User{ID: "a"}}
+		<-started
+		input <- message.Message{User: message.User{ID: "a"}}
+
+		assert.Eventually(t, func() bool {
+			return h.Report(ctx).Components[core.HealthProcessor].Status == core.HealthDegraded
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, 1, h.Report(ctx).Components[core.HealthProcessor].Data["queue_depth"])
+
+		close(release)
+		close(input)
+		<-done
+		assert.Equal(t, core.HealthDown, h.Report(ctx).Components[core.HealthProcessor].Status)
+	})
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"slices"
+	"sync"
+)
+
+// Hooks holds the functions registered on a session adapter, such as those
+// of OnCreated, until they are unregistered. The zero value is ready to use,
+// and it is safe for concurrent use.
+type Hooks[F any] struct {
+	mu    sync.Mutex
+	hooks []*hook[F]
+}
+
+// hook is a registered function, kept by pointer so it can be unregistered.
+type hook[F any] struct {
+	fn F
+}
+
+// Add registers fn and returns the func unregistering it.
+func (h *Hooks[F]) Add(fn F) (unregister func()) {
+	added := &hook[F]{fn: fn}
+
+	h.mu.Lock()
+	h.hooks = append(slices.Clip(h.hooks), added)
+	h.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.hooks = slices.DeleteFunc(slices.Clone(h.hooks), func(other *hook[F]) bool { return other == added })
+		})
+	}
+}
+
+// All returns the registered functions, in the order they were registered.
+func (h *Hooks[F]) All() []F {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fns := make([]F, 0, len(h.hooks))
+	for _, registered := range h.hooks {
+		fns = append(fns, registered.fn)
+	}
+	return fns
+}
@@ -0,0 +1,32 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	var hooks core.Hooks[func() string]
+	assert.Empty(t, hooks.All())
+
+	unregisterA := hooks.Add(func() string { return "a" })
+	hooks.Add(func() string { return "b" })
+
+	names := func() []string {
+		var got []string
+		for _, fn := range hooks.All() {
+			got = append(got, fn())
+		}
+		return got
+	}
+	assert.Equal(t, []string{"a", "b"}, names())
+
+	unregisterA()
+	unregisterA()
+	assert.Equal(t, []string{"b"}, names())
+}
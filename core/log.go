@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Keys of the attributes every log line about a message carries.
+const (
+	LogMessageID    = "message_id"
+	LogUserID       = "user_id"
+	LogConnector    = "connector"
+	LogSessionState = "session_state"
+	LogEngine       = "engine"
+)
+
+// Meta keys carrying the session state and engine of the message a reply
+// answers, so the log lines about its dispatch describe the session too.
+const (
+	SessionStateMeta = "session_state"
+	EngineMeta       = "engine"
+)
+
+// WithLogger sets the logger of the pipeline. Connectors, engines and actions
+// reach it through ChatContext.Logger and Context.Logger. Nothing is logged
+// by default.
+func WithLogger(logger *slog.Logger) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.logger = logger
+	}
+}
+
+// MessageLogAttrs returns the standard attributes identifying msg.
+func MessageLogAttrs(msg message.Message) []any {
+	return []any{
+		slog.String(LogMessageID, msg.ID),
+		slog.String(LogUserID, msg.User.ID),
+		slog.String(LogConnector, string(msg.Connector)),
+	}
+}
+
+// SessionLogAttrs returns the standard attributes describing sess.
+func SessionLogAttrs(sess *Session) []any {
+	return []any{
+		slog.String(LogSessionState, StateName(sess.State)),
+		slog.String(LogEngine, sess.Engine),
+	}
+}
+
+// replyLogAttrs returns the standard attributes of a reply being dispatched,
+// those of its session included when it was sent through Context.SendOutput.
+func replyLogAttrs(msg message.Message) []any {
+	attrs := MessageLogAttrs(msg)
+	if state := msg.Meta.Get(SessionStateMeta); state != "" {
+		attrs = append(attrs, slog.String(LogSessionState, state))
+	}
+	if engine := msg.Meta.Get(EngineMeta); engine != "" {
+		attrs = append(attrs, slog.String(LogEngine, engine))
+	}
+	return attrs
+}
+
+// withSessionMeta returns msg carrying the state and engine of sess in its own
+// copy of Meta.
+func withSessionMeta(msg message.Message, sess *Session) message.Message {
+	msg.SetMeta(SessionStateMeta, StateName(sess.State))
+	if sess.Engine != "" {
+		msg.SetMeta(EngineMeta, sess.Engine)
+	}
+	return msg
+}
+
+func (c *ChatContext) Logger() *slog.Logger {
+	return c.logger
+}
+
+// Logger returns the pipeline logger carrying the standard attributes of the
+// message being handled and of its session, as they are when called.
+func (c *Context) Logger() *slog.Logger {
+	return c.parent.logger.With(append(MessageLogAttrs(c.msg), SessionLogAttrs(c.session)...)...)
+}
+
+// discardHandler drops every record, to serve as the default logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
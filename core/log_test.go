@@ -0,0 +1,154 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// logBuffer captures JSON log lines written from several goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func newCapturedLogger(level slog.Level) (*slog.Logger, *logBuffer) {
+	buf := &logBuffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level})), buf
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("context logger carries the standard fields", func(t *testing.T) {
+		t.Parallel()
+
+		logger, buf := newCapturedLogger(slog.LevelInfo)
+		chatCtx := core.NewChatContext(make(chan core.Event, 1), core.WithLogger(logger))
+		defer chatCtx.Shutdown()
+
+		msg := message.Message{ID: "m1", User: message.User{ID: "ace"}, Connector: message.Cli}
+		ctx, err := chatCtx.NewChildContext(msg, nil)
+		assert.NoError(t, err)
+		defer ctx.Cancel()
+
+		ctx.Session().Engine = "faq"
+		ctx.SetSessionState(core.WaitingInputState{})
+		ctx.Logger().Info("hello")
+		ctx.Logger().Debug("filtered out")
+
+		lines := buf.lines(t)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "hello", lines[0]["msg"])
+		assert.Equal(t, "m1", lines[0][core.LogMessageID])
+		assert.Equal(t, "ace", lines[0][core.LogUserID])
+		assert.Equal(t, "cli", lines[0][core.LogConnector])
+		assert.Equal(t, "core.WaitingInputState", lines[0][core.LogSessionState])
+		assert.Equal(t, "faq", lines[0][core.LogEngine])
+	})
+
+	t.Run("processor logs engine panics", func(t *testing.T) {
+		t.Parallel()
+
+		logger, buf := newCapturedLogger(slog.LevelInfo)
+		chatCtx := core.NewChatContext(make(chan core.Event, 2), core.WithLogger(logger))
+		defer chatCtx.Shutdown()
+
+		proc := core.NewProcessor(core.EngineFunc(func(*core.Context, *message.Message) {
+			panic("kaboom")
+		}))
+
+		input := make(chan message.Message, 1)
+		input <- message.Message{ID: "m2", User: message.User{ID: "sabo"}, Connector: message.Test}
+		close(input)
+		proc.Process(chatCtx, input, make(chan message.Message, 1))
+
+		lines := buf.lines(t)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "ERROR", lines[0]["level"])
+		assert.Equal(t, "engine panicked", lines[0]["msg"])
+		assert.Equal(t, "kaboom", lines[0]["panic"])
+		assert.Equal(t, "m2", lines[0][core.LogMessageID])
+		assert.Equal(t, "core.IdleState", lines[0][core.LogSessionState])
+	})
+
+	t.Run("dispatch errors carry the session fields of the reply", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Dispatch(gomock.Any()).Return(assert.AnError)
+
+		multi, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+
+		logger, buf := newCapturedLogger(slog.LevelInfo)
+		chatCtx := core.NewChatContext(make(chan core.Event, 2), core.WithLogger(logger))
+		defer chatCtx.Shutdown()
+
+		msg := message.Message{ID: "m3", User: message.User{ID: "zoro"}, Connector: message.Test}
+		output := make(chan message.Message, 1)
+		ctx, err := chatCtx.NewChildContext(msg, output)
+		assert.NoError(t, err)
+		defer ctx.Cancel()
+
+		ctx.Session().Engine = "faq"
+		ctx.SendOutput(&msg)
+		close(output)
+		multi.Response(chatCtx, output)
+
+		lines := buf.lines(t)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "dispatch message", lines[0]["msg"])
+		assert.Equal(t, "m3", lines[0][core.LogMessageID])
+		assert.Equal(t, "core.IdleState", lines[0][core.LogSessionState])
+		assert.Equal(t, "faq", lines[0][core.LogEngine])
+	})
+
+	t.Run("logs nothing by default", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		defer chatCtx.Shutdown()
+
+		assert.False(t, chatCtx.Logger().Enabled(context.Background(), slog.LevelError))
+	})
+}
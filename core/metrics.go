@@ -0,0 +1,85 @@
+package core
+
+import (
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
+)
+
+const (
+	poolProcessor = "processor"
+	poolResponse  = "response"
+)
+
+// WithMetrics makes the processor, the connectors' responses, the event bus
+// and the session adapter report to reg.
+func WithMetrics(reg metrics.Registry) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.metricsRegistry = reg
+	}
+}
+
+// pipelineMetrics are the instruments shared by the pipeline stages.
+type pipelineMetrics struct {
+	received        metrics.Counter
+	sent            metrics.Counter
+	dispatchErrors  metrics.Counter
+	handlerDuration metrics.Histogram
+	poolSize        metrics.Gauge
+	poolInUse       metrics.Gauge
+}
+
+func newPipelineMetrics(reg metrics.Registry) *pipelineMetrics {
+	return &pipelineMetrics{
+		received: reg.Counter(
+			"ohmychat_messages_received_total", "Messages received from the users.", "connector"),
+		sent: reg.Counter(
+			"ohmychat_messages_sent_total", "Messages dispatched to the users.", "connector"),
+		dispatchErrors: reg.Counter(
+			"ohmychat_dispatch_errors_total", "Messages the connector failed to dispatch.", "connector"),
+		handlerDuration: reg.Histogram(
+			"ohmychat_handler_duration_seconds", "Time the engine took to handle a message.", metrics.DefBuckets, "connector"),
+		poolSize: reg.Gauge(
+			"ohmychat_pool_size", "Workers available to a pipeline stage.", "pool"),
+		poolInUse: reg.Gauge(
+			"ohmychat_pool_in_use", "Workers busy in a pipeline stage; equal to the size when saturated.", "pool"),
+	}
+}
+
+// registerSessions reports how many sessions the adapter keeps, when it can
+// tell.
+func registerSessions(reg metrics.Registry, adapter SessionAdapter) {
+	counted, ok := adapter.(interface{ Len() int })
+	if !ok {
+		return
+	}
+	reg.GaugeFunc("ohmychat_sessions_active", "Sessions kept by the session adapter.", func() float64 {
+		return float64(counted.Len())
+	})
+}
+
+// registerEvents reports the events waiting in and dropped by the
+// subscriber queues of bus.
+func registerEvents(reg metrics.Registry, bus *EventBus) {
+	reg.GaugeFunc("ohmychat_events_queued", "Events waiting in the event subscriber queues.", func() float64 {
+		queued := 0
+		for _, s := range bus.Stats() {
+			queued += s.Queued
+		}
+		return float64(queued)
+	})
+	reg.CounterFunc("ohmychat_events_dropped_total", "Events dropped by full event subscriber queues.", func() float64 {
+		return float64(bus.Dropped())
+	})
+}
+
+// acquire marks a worker of pool busy until release is called.
+func (m *pipelineMetrics) acquire(pool string) (release func()) {
+	m.poolInUse.Add(1, pool)
+	return func() { m.poolInUse.Add(-1, pool) }
+}
+
+func (m *pipelineMetrics) observeHandler(msg message.Message, start time.Time) {
+	m.handlerDuration.Observe(time.Since(start).Seconds(), string(msg.Connector))
+}
@@ -0,0 +1,85 @@
+package core_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conn := mocks.NewMockConnector(ctrl)
+	conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+	conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+		if m.Input == "fail" {
+			return assert.AnError
+		}
+		return nil
+	}).Times(2)
+
+	multi, err := core.NewMuitiChannelConnector(conn)
+	assert.NoError(t, err)
+	multi.SetConfig(core.ConnectorConfig{ResponseMaxPool: 3})
+
+	reg := metrics.NewPrometheusRegistry()
+	events := make(chan core.Event, 4)
+	chatCtx := core.NewChatContext(events, core.WithMetrics(reg))
+	defer chatCtx.Shutdown()
+
+	proc := core.NewProcessor(core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+		ctx.SendOutput(msg)
+	}), core.ProcessWithMaxPool(2))
+
+	input := make(chan message.Message, 2)
+	output := make(chan message.Message, 2)
+	input <- message.Message{User: message.User{ID: "ace"}, Connector: message.Test, Input: "ok"}
+	input <- message.Message{User: message.User{ID: "sabo"}, Connector: message.Test, Input: "fail"}
+	close(input)
+
+	proc.Process(chatCtx, input, output)
+	close(output)
+	multi.Response(chatCtx, output)
+
+	var sb strings.Builder
+	_, err = reg.WriteTo(&sb)
+	assert.NoError(t, err)
+	text := sb.String()
+
+	assert.Contains(t, text, `ohmychat_messages_received_total{connector="testConn"} 2`)
+	assert.Contains(t, text, `ohmychat_messages_sent_total{connector="testConn"} 1`)
+	assert.Contains(t, text, `ohmychat_dispatch_errors_total{connector="testConn"} 1`)
+	assert.Contains(t, text, `ohmychat_handler_duration_seconds_count{connector="testConn"} 2`)
+	assert.Contains(t, text, `ohmychat_pool_size{pool="processor"} 2`)
+	assert.Contains(t, text, `ohmychat_pool_size{pool="response"} 3`)
+	assert.Contains(t, text, `ohmychat_pool_in_use{pool="processor"} 0`)
+	assert.Contains(t, text, `ohmychat_pool_in_use{pool="response"} 0`)
+	assert.Contains(t, text, "ohmychat_sessions_active 2")
+}
+
+func TestMetrics_Events(t *testing.T) {
+	t.Parallel()
+
+	reg := metrics.NewPrometheusRegistry()
+	bus := core.NewEventBus()
+	chatCtx := core.NewChatContext(nil, core.WithEventBus(bus), core.WithMetrics(reg))
+	defer chatCtx.Shutdown()
+
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	assert.NoError(t, err)
+	text := sb.String()
+
+	assert.Contains(t, text, "# TYPE ohmychat_events_queued gauge\nohmychat_events_queued 0\n")
+	assert.Contains(t, text, "# TYPE ohmychat_events_dropped_total counter\nohmychat_events_dropped_total 0\n")
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// EngineFunc adapts a plain function to the Engine interface.
+type EngineFunc func(*Context, *message.Message)
+
+func (f EngineFunc) HandleMessage(ctx *Context, msg *message.Message) {
+	f(ctx, msg)
+}
+
+// Middleware wraps an Engine. It may inspect or modify the message before
+// calling next, reply through Context.SendOutput without calling next to
+// short-circuit the handler, and run code once next has returned.
+type Middleware func(next Engine) Engine
+
+// Chain wraps engine with middlewares, the first one being the outermost.
+func Chain(engine Engine, middlewares ...Middleware) Engine {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		engine = middlewares[i](engine)
+	}
+	return engine
+}
+
+// LoggingMiddleware logs every handled message, the session it left, and how
+// long it took.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next Engine) Engine {
+		return EngineFunc(func(ctx *Context, msg *message.Message) {
+			start := time.Now()
+			next.HandleMessage(ctx, msg)
+			attrs := append(MessageLogAttrs(*msg), SessionLogAttrs(ctx.Session())...)
+			logger.Info("message handled", append(attrs,
+				slog.Bool("replied", ctx.MessageHasBeenReplyed()),
+				slog.Duration("elapsed", time.Since(start)),
+			)...)
+		})
+	}
+}
+
+// TrimInputMiddleware removes leading and trailing white space from the input.
+func TrimInputMiddleware() Middleware {
+	return func(next Engine) Engine {
+		return EngineFunc(func(ctx *Context, msg *message.Message) {
+			msg.Input = strings.TrimSpace(msg.Input)
+			next.HandleMessage(ctx, msg)
+		})
+	}
+}
+
+// TimingMiddleware reports how long the wrapped engine took for each message.
+func TimingMiddleware(observe func(msg message.Message, elapsed time.Duration)) Middleware {
+	return func(next Engine) Engine {
+		return EngineFunc(func(ctx *Context, msg *message.Message) {
+			start := time.Now()
+			next.HandleMessage(ctx, msg)
+			observe(*msg, time.Since(start))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestContext(t *testing.T, msg message.Message, output chan<- message.Message) *core.Context {
+	t.Helper()
+
+	chatCtx := core.NewChatContext(make(chan core.Event, 1))
+	t.Cleanup(chatCtx.Shutdown)
+
+	ctx, err := chatCtx.NewChildContext(msg, output)
+	assert.NoError(t, err)
+	t.Cleanup(ctx.Cancel)
+	return ctx
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	t.Run("chain runs middlewares outermost first", func(t *testing.T) {
+		t.Parallel()
+
+		var calls []string
+		trace := func(name string) core.Middleware {
+			return func(next core.Engine) core.Engine {
+				return core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+					calls = append(calls, "before "+name)
+					next.HandleMessage(ctx, msg)
+					calls = append(calls, "after "+name)
+				})
+			}
+		}
+
+		engine := core.Chain(
+			core.EngineFunc(func(*core.Context, *message.Message) { calls = append(calls, "engine") }),
+			trace("first"),
+			trace("second"),
+		)
+
+		msg := message.Message{User: message.User{ID: "brook"}}
+		engine.HandleMessage(newTestContext(t, msg, nil), &msg)
+
+		assert.Equal(t, []string{
+			"before first",
+			"before second",
+			"engine",
+			"after second",
+			"after first",
+		}, calls)
+	})
+
+	t.Run("middleware can short-circuit with a reply", func(t *testing.T) {
+		t.Parallel()
+
+		deny := func(next core.Engine) core.Engine {
+			return core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				if msg.User.ID != "admin" {
+					msg.Output = "not allowed"
+					ctx.SendOutput(msg)
+					return
+				}
+				next.HandleMessage(ctx, msg)
+			})
+		}
+
+		called := false
+		engine := core.Chain(core.EngineFunc(func(*core.Context, *message.Message) { called = true }), deny)
+
+		output := make(chan message.Message, 1)
+		msg := message.Message{User: message.User{ID: "buggy"}}
+		engine.HandleMessage(newTestContext(t, msg, output), &msg)
+
+		assert.False(t, called)
+		assert.Equal(t, "not allowed", (<-output).Output)
+	})
+
+	t.Run("trim input middleware", func(t *testing.T) {
+		t.Parallel()
+
+		var got string
+		engine := core.Chain(
+			core.EngineFunc(func(_ *core.Context, msg *message.Message) { got = msg.Input }),
+			core.TrimInputMiddleware(),
+		)
+
+		msg := message.Message{User: message.User{ID: "chopper"}, Input: "  fazer pedido \n"}
+		engine.HandleMessage(newTestContext(t, msg, nil), &msg)
+
+		assert.Equal(t, "fazer pedido", got)
+	})
+
+	t.Run("timing middleware", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			observed message.Message
+			elapsed  time.Duration
+		)
+		engine := core.Chain(
+			core.EngineFunc(func(*core.Context, *message.Message) { time.Sleep(5 * time.Millisecond) }),
+			core.TimingMiddleware(func(msg message.Message, d time.Duration) {
+				observed = msg
+				elapsed = d
+			}),
+		)
+
+		msg := message.Message{ID: "42", User: message.User{ID: "sanji"}}
+		engine.HandleMessage(newTestContext(t, msg, nil), &msg)
+
+		assert.Equal(t, "42", observed.ID)
+		assert.GreaterOrEqual(t, elapsed, 5*time.Millisecond)
+	})
+
+	t.Run("logging middleware", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+		engine := core.Chain(
+			core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				ctx.Session().Engine = "faq"
+				ctx.SetSessionState(core.WaitingInputState{})
+				ctx.SendOutput(msg)
+			}),
+			core.LoggingMiddleware(logger),
+		)
+
+		msg := message.Message{ID: "7", User: message.User{ID: "vivi"}, Connector: message.Cli}
+		output := make(chan message.Message, 1)
+		engine.HandleMessage(newTestContext(t, msg, output), &msg)
+
+		var line map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+		assert.Equal(t, "message handled", line["msg"])
+		assert.Equal(t, "7", line[core.LogMessageID])
+		assert.Equal(t, "vivi", line[core.LogUserID])
+		assert.Equal(t, "cli", line[core.LogConnector])
+		assert.Equal(t, "core.WaitingInputState", line[core.LogSessionState])
+		assert.Equal(t, "faq", line[core.LogEngine])
+		assert.Equal(t, true, line["replied"])
+
+		// the reply carries the session for the log lines about its dispatch
+		reply := <-output
+		assert.Equal(t, "core.WaitingInputState", reply.Meta.Get(core.SessionStateMeta))
+		assert.Equal(t, "faq", reply.Meta.Get(core.EngineMeta))
+	})
+}
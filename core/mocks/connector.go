@@ -62,3 +62,17 @@ func (mr *MockConnectorMockRecorder) Dispatch(message interface{}) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dispatch", reflect.TypeOf((*MockConnector)(nil).Dispatch), message)
 }
+
+// Kind mocks base method.
+func (m *MockConnector) Kind() message.MessageConnector {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Kind")
+	ret0, _ := ret[0].(message.MessageConnector)
+	return ret0
+}
+
+// Kind indicates an expected call of Kind.
+func (mr *MockConnectorMockRecorder) Kind() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Kind", reflect.TypeOf((*MockConnector)(nil).Kind))
+}
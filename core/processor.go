@@ -2,27 +2,96 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
 	"github.com/guiflemes/ohmychat/message"
 )
 
 type ProcessConfig struct {
-	MaxPool uint8
+	MaxPool   uint8
+	QueueSize uint8
+	// PanicReply, when set, is sent to the user whose message made the
+	// engine panic.
+	PanicReply string
 }
 
 type Engine interface {
 	HandleMessage(*Context, *message.Message)
 }
 
+type ProcessorOption func(p *processor)
+
+func ProcessWithMaxPool(maxPool uint8) ProcessorOption {
+	return func(p *processor) {
+		p.config.MaxPool = maxPool
+	}
+}
+
+// ProcessWithMiddleware wraps the engine with middlewares, the first one being
+// the outermost.
+func ProcessWithMiddleware(middlewares ...Middleware) ProcessorOption {
+	return func(p *processor) {
+		p.middlewares = append(p.middlewares, middlewares...)
+	}
+}
+
+// ProcessWithGate screens the inbound messages with gates, in order, before
+// they are queued for their worker.
+func ProcessWithGate(gates ...Gate) ProcessorOption {
+	return func(p *processor) {
+		p.gates = append(p.gates, gates...)
+	}
+}
+
+// ProcessWithPanicReply sets the reply sent to a user whose message made the
+// engine panic.
+func ProcessWithPanicReply(reply string) ProcessorOption {
+	return func(p *processor) {
+		p.config.PanicReply = reply
+	}
+}
+
+func ProcessWithQueueSize(size uint8) ProcessorOption {
+	return func(p *processor) {
+		p.config.QueueSize = size
+	}
+}
+
+// processor handles inbound messages on a fixed pool of workers. Messages are
+// sharded by session key, so a session is always served by the same worker:
+// messages from one user are handled strictly in order while different users
+// run in parallel.
 type processor struct {
-	config ProcessConfig
-	engine Engine
+	config      ProcessConfig
+	engine      Engine
+	middlewares []Middleware
+	gates       []Gate
 }
 
-func NewProcessor(engine Engine) *processor {
-	return &processor{
+func NewProcessor(engine Engine, options ...ProcessorOption) *processor {
+	p := &processor{
 		engine: engine,
-		config: ProcessConfig{MaxPool: 5},
+		config: ProcessConfig{MaxPool: 5, QueueSize: 10},
 	}
+
+	for _, opt := range options {
+		opt(p)
+	}
+
+	if p.config.MaxPool == 0 {
+		p.config.MaxPool = 1
+	}
+
+	p.engine = Chain(p.engine, p.middlewares...)
+
+	return p
 }
 
 func (p *processor) Process(
@@ -30,36 +99,235 @@ func (p *processor) Process(
 	inputMsg <-chan message.Message,
 	outputMsg chan<- message.Message,
 ) {
-	sem := make(chan struct{}, p.config.MaxPool)
+	queues := make([]chan message.Message, p.config.MaxPool)
+	ctx.metrics.poolSize.Set(float64(p.config.MaxPool), poolProcessor)
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan message.Message, p.config.QueueSize)
+		wg.Add(1)
+		go func(queue <-chan message.Message) {
+			defer wg.Done()
+			for msg := range queue {
+				if !ctx.IsActive() {
+					continue
+				}
+				// a session that cannot be loaded is already reported as an event
+				_, _ = p.Handle(ctx, msg, outputMsg)
+			}
+		}(queues[i])
+	}
+
+	ctx.checkHealth(HealthProcessor, func(context.Context) ComponentHealth {
+		var depth, capacity int
+		full := false
+		for _, queue := range queues {
+			depth += len(queue)
+			capacity += cap(queue)
+			full = full || (cap(queue) > 0 && len(queue) == cap(queue))
+		}
+		return queueHealth(depth, capacity, full)
+	})
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+		ctx.ReportHealth(HealthProcessor, HealthDown, "stopped")
+	}()
+
+	// retried messages still waiting once the processor stops are dropped
+	retries := make(chan message.Message)
+	stop := make(chan struct{})
+	defer close(stop)
+
 	for {
+		var msg message.Message
 		select {
-		case msg, ok := <-inputMsg:
+		case m, ok := <-inputMsg:
 			if !ok {
 				return
 			}
-			go func(m message.Message) {
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				childCtx, err := ctx.NewChildContext(m, outputMsg)
-				if err != nil {
-					ctx.SendEvent(NewEventErrorWithMessage(msg, err))
-					return
-				}
+			msg = m
+		case msg = <-retries:
+		case <-ctx.Done():
+			return
+		}
 
-				p.engine.HandleMessage(childCtx, &m)
+		if !p.admit(ctx, &msg, outputMsg, retries, stop) {
+			continue
+		}
+		select {
+		case queues[p.shard(msg)] <- msg:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-				if !childCtx.MessageHasBeenReplyed() {
-					if err = ctx.SaveSession(childCtx.Context(), childCtx.Session()); err != nil {
-						ctx.SendEvent(NewEventErrorWithMessage(msg, err))
-					}
+// admit puts msg through the gates and reports whether it may be queued. A
+// rejected message is answered when the gate says so, and a retried one comes
+// back on retries once its delay has passed, unless stop is closed first.
+func (p *processor) admit(
+	ctx *ChatContext,
+	msg *message.Message,
+	outputMsg chan<- message.Message,
+	retries chan<- message.Message,
+	stop <-chan struct{},
+) bool {
+	for _, gate := range p.gates {
+		verdict := gate(ctx, msg)
+		switch verdict.Action {
+		case Reject:
+			if verdict.Reply != "" {
+				select {
+				case outputMsg <- gateReply(*msg, verdict.Reply):
+				case <-ctx.Done():
+				}
+			}
+			return false
+		case Retry:
+			retried := *msg
+			time.AfterFunc(verdict.After, func() {
+				select {
+				case retries <- retried:
+				case <-stop:
 				}
+			})
+			return false
+		}
+	}
+	return true
+}
 
-				childCtx.Cancel()
-			}(msg)
+func (p *processor) shard(msg message.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(SessionKey(msg)))
+	return int(h.Sum32() % uint32(p.config.MaxPool))
+}
 
-		case <-ctx.Done():
-			return
+// MessageHandler runs one message at a time through the engine, as the
+// processor returned by NewProcessor does.
+type MessageHandler interface {
+	Handle(ctx *ChatContext, msg message.Message, outputMsg chan<- message.Message) (Handled, error)
+}
+
+// Handled is what handling a message did to the session of its user.
+type Handled struct {
+	// StateBefore is the state the user was in when the message came in.
+	StateBefore SessionState
+	// Session is the session once the message was handled.
+	Session Session
+}
+
+// Handle runs msg through the engine as a worker of Process does, on the
+// calling goroutine: the session is locked and loaded, panics and timeouts are
+// reported, the transcript is recorded and the session is saved. Replies are
+// sent to outputMsg. The error tells the session could not be loaded, in
+// which case the engine is not run.
+func (p *processor) Handle(ctx *ChatContext, msg message.Message, outputMsg chan<- message.Message) (Handled, error) {
+	received := ctx.now()
+	ctx.metrics.received.Add(1, string(msg.Connector))
+	defer ctx.metrics.acquire(poolProcessor)()
+
+	msg, span := ctx.traceMessage(msg)
+	defer span.end(nil)
+	ctx.emit(&msg, MessageReceived{Message: msg})
+
+	unlock := ctx.lockSession(SessionKey(msg))
+	defer unlock()
+
+	childCtx, err := ctx.NewChildContext(msg, outputMsg)
+	if err != nil {
+		ctx.Logger().Error("load session", append(MessageLogAttrs(msg), slog.Any("error", err))...)
+		ctx.SendEvent(NewEventErrorWithMessage(msg, err))
+		return Handled{}, err
+	}
+	defer childCtx.Cancel()
+
+	childCtx.Logger().Debug("handling message")
+
+	stateBefore := childCtx.Session().State
+	entry := transcriptEntry(msg, Inbound, msg.Input, received)
+	entry.StateBefore = StateName(stateBefore)
+	defer func() {
+		stateAfter := childCtx.Session().State
+		entry.StateAfter = StateName(stateAfter)
+		ctx.record(entry)
+		if entry.StateAfter != entry.StateBefore {
+			ctx.emit(&msg, StateChanged{UserID: childCtx.Session().UserID, Old: stateBefore, New: stateAfter})
 		}
+	}()
+
+	ctx.emit(&msg, HandlerStarted{Message: msg, State: stateBefore})
+	started := time.Now()
+	panicked := p.safeHandle(ctx, childCtx, msg)
+
+	finished := HandlerFinished{
+		Message:  msg,
+		Duration: time.Since(started),
+		Replied:  childCtx.MessageHasBeenReplyed(),
+		Panicked: panicked,
 	}
+	if err := childCtx.Context().Err(); errors.Is(err, context.DeadlineExceeded) {
+		finished.Err = err
+	}
+	ctx.emit(&msg, finished)
+
+	if panicked {
+		return Handled{StateBefore: stateBefore, Session: *childCtx.Session()}, nil
+	}
+
+	if finished.Err != nil {
+		childCtx.Logger().Warn("handler timed out", slog.Duration("timeout", ctx.handlerTimeout))
+		ctx.SendEvent(NewEventTimeout(msg, finished.Err))
+	}
+
+	if !childCtx.MessageHasBeenReplyed() {
+		if err = ctx.SaveSession(childCtx.Context(), childCtx.Session()); err != nil {
+			ctx.SendEvent(NewEventErrorWithMessage(msg, err))
+		}
+	}
+	return Handled{StateBefore: stateBefore, Session: *childCtx.Session()}, nil
+}
+
+// safeHandle runs the engine and reports whether it panicked. A panic is
+// turned into an event, the session is reset to IdleState so the user is not
+// stuck, and the configured apology is sent.
+func (p *processor) safeHandle(ctx *ChatContext, childCtx *Context, msg message.Message) (panicked bool) {
+	original := msg
+	span := ctx.startSpan(spanFromMessage(msg), "engine.handle", &msg)
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			span.end(childCtx.Context().Err())
+			return
+		}
+		panicked = true
+		span.end(fmt.Errorf("panic: %v", r))
+		childCtx.Logger().Error("engine panicked", slog.Any("panic", r))
+
+		ctx.SendEvent(NewEventPanic(&original, r, debug.Stack()))
+		childCtx.SetSessionState(IdleState{})
+
+		if p.config.PanicReply == "" {
+			if err := ctx.SaveSession(childCtx.Context(), childCtx.Session()); err != nil {
+				ctx.SendEvent(NewEventErrorWithMessage(original, err))
+			}
+			return
+		}
+
+		reply := original
+		reply.Output = p.config.PanicReply
+		reply.Options = nil
+		reply.ResponseType = message.TextResponse
+		childCtx.SendOutput(&reply)
+	}()
+
+	defer ctx.metrics.observeHandler(original, time.Now())
+
+	p.engine.HandleMessage(childCtx, &msg)
+	return false
 }
@@ -1,6 +1,10 @@
 package core_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -56,7 +60,7 @@ func TestProcessor_Process(t *testing.T) {
 		mockSessionAdapter := mocks.NewMockSessionAdapter(ctrl)
 
 		mockEngine.EXPECT().HandleMessage(gomock.Any(), gomock.Any()).Times(0)
-		mockSessionAdapter.EXPECT().GetOrCreate(gomock.Any(), "user123").Return(nil, assert.AnError).Times(1)
+		mockSessionAdapter.EXPECT().GetOrCreate(gomock.Any(), ":user123").Return(nil, assert.AnError).Times(1)
 
 		proc := core.NewProcessor(mockEngine)
 
@@ -88,4 +92,325 @@ func TestProcessor_Process(t *testing.T) {
 		ctx.Shutdown()
 
 	})
+	t.Run("emits a timeout event when the handler exceeds its deadline", func(t *testing.T) {
+		t.Parallel()
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			<-ctx.Context().Done()
+		})
+
+		proc := core.NewProcessor(engine)
+		event := make(chan core.Event, 1)
+		ctx := core.NewChatContext(event, core.WithHandlerTimeout(10*time.Millisecond))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 1)
+		input <- message.Message{User: message.User{ID: "franky"}}
+
+		go proc.Process(ctx, input, make(chan message.Message))
+
+		select {
+		case evt := <-event:
+			assert.Equal(t, core.EventTimeout, evt.Type)
+			assert.ErrorIs(t, evt.Error, context.DeadlineExceeded)
+			assert.Equal(t, "franky", evt.Msg.User.ID)
+		case <-time.After(time.Second):
+			t.Fatal("expected timeout event, but none was received")
+		}
+	})
+
+	t.Run("wraps the engine with middlewares", func(t *testing.T) {
+		t.Parallel()
+
+		handled := make(chan string, 1)
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			handled <- msg.Input
+		})
+
+		proc := core.NewProcessor(engine, core.ProcessWithMiddleware(core.TrimInputMiddleware()))
+		ctx := core.NewChatContext(make(chan core.Event, 1))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 1)
+		input <- message.Message{User: message.User{ID: "yamato"}, Input: " oi "}
+
+		go proc.Process(ctx, input, make(chan message.Message))
+
+		select {
+		case in := <-handled:
+			assert.Equal(t, "oi", in)
+		case <-time.After(time.Second):
+			t.Fatal("message was not handled")
+		}
+	})
+
+	t.Run("gates messages before loading their session", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		adapter := mocks.NewMockSessionAdapter(ctrl)
+		adapter.EXPECT().GetOrCreate(gomock.Any(), ":admitted").Return(&core.Session{ID: ":admitted", State: core.IdleState{}, Memory: map[string]any{}}, nil)
+		adapter.EXPECT().GetOrCreate(gomock.Any(), ":retried").Return(&core.Session{ID: ":retried", State: core.IdleState{}, Memory: map[string]any{}}, nil)
+		adapter.EXPECT().Save(gomock.Any(), gomock.Any()).AnyTimes()
+
+		handled := make(chan string, 3)
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			handled <- msg.User.ID
+		})
+
+		var retried atomic.Bool
+		gate := func(ctx *core.ChatContext, msg *message.Message) core.Verdict {
+			switch msg.User.ID {
+			case "rejected":
+				return core.Verdict{Action: core.Reject, Reply: "calma!"}
+			case "retried":
+				if !retried.Swap(true) {
+					return core.Verdict{Action: core.Retry, After: 10 * time.Millisecond}
+				}
+			}
+			return core.Verdict{Action: core.Admit}
+		}
+
+		proc := core.NewProcessor(engine, core.ProcessWithGate(gate))
+		ctx := core.NewChatContext(make(chan core.Event, 10), core.WithSessionAdapter(adapter))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 3)
+		output := make(chan message.Message, 1)
+		for _, id := range []string{"retried", "rejected", "admitted"} {
+			input <- message.Message{User: message.User{ID: id}}
+		}
+		go proc.Process(ctx, input, output)
+
+		assert.Equal(t, "calma!", (<-output).Output)
+		assert.Equal(t, "admitted", <-handled)
+		assert.Equal(t, "retried", <-handled)
+		assert.Empty(t, handled)
+	})
+
+	t.Run("recovers a panicking engine and resets the session", func(t *testing.T) {
+		t.Parallel()
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SetSessionState(core.WaitingInputState{})
+			msg.Input = "changed"
+			panic("boom")
+		})
+
+		repo := core.NewInMemorySessionRepo()
+		proc := core.NewProcessor(engine, core.ProcessWithPanicReply("sorry"))
+		event := make(chan core.Event, 1)
+		ctx := core.NewChatContext(event, core.WithSessionAdapter(repo))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 1)
+		output := make(chan message.Message, 1)
+		input <- message.Message{User: message.User{ID: "law"}, Input: "hi", Options: []message.Option{{ID: "x"}}}
+
+		go proc.Process(ctx, input, output)
+
+		select {
+		case evt := <-event:
+			assert.Equal(t, core.EventPanic, evt.Type)
+			assert.Equal(t, "hi", evt.Msg.Input)
+			var panicErr *core.PanicError
+			assert.ErrorAs(t, evt.Error, &panicErr)
+			assert.Equal(t, "boom", panicErr.Value)
+			assert.NotEmpty(t, panicErr.Stack)
+		case <-time.After(time.Second):
+			t.Fatal("expected panic event, but none was received")
+		}
+
+		select {
+		case reply := <-output:
+			assert.Equal(t, "sorry", reply.Output)
+			assert.Equal(t, message.TextResponse, reply.ResponseType)
+			assert.Empty(t, reply.Options)
+		case <-time.After(time.Second):
+			t.Fatal("expected apology reply")
+		}
+
+		sess, _ := repo.GetOrCreate(context.Background(), ":law")
+		assert.IsType(t, core.IdleState{}, sess.State)
+	})
+
+	t.Run("keeps serving after a panic", func(t *testing.T) {
+		t.Parallel()
+
+		handled := make(chan string, 1)
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			if msg.Input == "panic" {
+				panic(assert.AnError)
+			}
+			handled <- msg.Input
+		})
+
+		proc := core.NewProcessor(engine, core.ProcessWithMaxPool(1))
+		event := make(chan core.Event, 1)
+		ctx := core.NewChatContext(event)
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 2)
+		input <- message.Message{User: message.User{ID: "kid"}, Input: "panic"}
+		input <- message.Message{User: message.User{ID: "kid"}, Input: "ok"}
+
+		go proc.Process(ctx, input, make(chan message.Message))
+
+		select {
+		case in := <-handled:
+			assert.Equal(t, "ok", in)
+		case <-time.After(time.Second):
+			t.Fatal("processor stopped after a panic")
+		}
+		assert.Equal(t, core.EventPanic, (<-event).Type)
+	})
+
+	t.Run("handles messages from the same session in order", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu       sync.Mutex
+			received = make(map[string][]string)
+			active   = make(map[string]*int32)
+			overlap  atomic.Bool
+		)
+
+		users := []string{"luffy", "zoro", "nami"}
+		for _, u := range users {
+			active[u] = new(int32)
+		}
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			counter := active[msg.User.ID]
+			if atomic.AddInt32(counter, 1) > 1 {
+				overlap.Store(true)
+			}
+			defer atomic.AddInt32(counter, -1)
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			received[msg.User.ID] = append(received[msg.User.ID], msg.Input)
+			mu.Unlock()
+		})
+
+		proc := core.NewProcessor(engine, core.ProcessWithMaxPool(2))
+		ctx := core.NewChatContext(make(chan core.Event))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message)
+		output := make(chan message.Message)
+
+		done := make(chan struct{})
+		go func() {
+			proc.Process(ctx, input, output)
+			close(done)
+		}()
+
+		const perUser = 20
+		for i := range perUser {
+			for _, u := range users {
+				input <- message.Message{User: message.User{ID: u}, Input: fmt.Sprint(i)}
+			}
+		}
+		close(input)
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("processor did not finish")
+		}
+
+		assert.False(t, overlap.Load(), "same session handled concurrently")
+		for _, u := range users {
+			assert.Len(t, received[u], perUser)
+			for i, in := range received[u] {
+				assert.Equal(t, fmt.Sprint(i), in)
+			}
+		}
+	})
+
+	t.Run("handles different sessions in parallel", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		handled := make(chan string, 8)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			if msg.User.ID == "blocked" {
+				<-release
+				return
+			}
+			handled <- msg.User.ID
+		})
+
+		proc := core.NewProcessor(engine, core.ProcessWithMaxPool(4))
+		ctx := core.NewChatContext(make(chan core.Event))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message, 9)
+		output := make(chan message.Message)
+
+		done := make(chan struct{})
+		go func() {
+			proc.Process(ctx, input, output)
+			close(done)
+		}()
+
+		input <- message.Message{User: message.User{ID: "blocked"}}
+		for i := range 8 {
+			input <- message.Message{User: message.User{ID: fmt.Sprintf("user%d", i)}}
+		}
+
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("no other session was handled while one was blocked")
+		}
+
+		close(release)
+		close(input)
+		<-done
+	})
+
+	t.Run("never exceeds the worker pool", func(t *testing.T) {
+		t.Parallel()
+
+		var current, peak int32
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			n := atomic.AddInt32(&current, 1)
+			defer atomic.AddInt32(&current, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+		})
+
+		proc := core.NewProcessor(engine, core.ProcessWithMaxPool(2))
+		ctx := core.NewChatContext(make(chan core.Event))
+		defer ctx.Shutdown()
+
+		input := make(chan message.Message)
+		output := make(chan message.Message)
+
+		done := make(chan struct{})
+		go func() {
+			proc.Process(ctx, input, output)
+			close(done)
+		}()
+
+		for i := range 50 {
+			input <- message.Message{User: message.User{ID: fmt.Sprintf("user%d", i)}}
+		}
+		close(input)
+		<-done
+
+		assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(2))
+	})
 }
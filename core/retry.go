@@ -0,0 +1,63 @@
+package core
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy tells how failed attempts, such as dispatching a reply or
+// delivering a scheduled message, are retried.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts, the first one included. Zero retries
+	// forever.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts, unless the failure
+	// asked for a longer one through RetryAfterError.
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after every failed attempt.
+	Multiplier float64
+	// Jitter randomises the wait by up to this fraction of it, so attempts
+	// failing together are not retried together.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     5 * time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns how long to wait after the given failed attempt, starting
+// at 1, honouring the wait asked for by err if it is longer.
+func (p RetryPolicy) Backoff(attempt int, err error) time.Duration {
+	multiplier := max(p.Multiplier, 1)
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(max(attempt-1, 0)))
+	// the wait is kept finite, then within range of a time.Duration
+	limit := float64(math.MaxInt64)
+	if p.MaxBackoff > 0 {
+		limit = float64(p.MaxBackoff)
+	}
+	wait = min(wait, limit)
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	backoff := time.Duration(math.MaxInt64)
+	if wait < float64(math.MaxInt64) {
+		backoff = time.Duration(wait)
+	}
+
+	if after, ok := RetryAfter(err); ok && after > backoff {
+		return after
+	}
+	return backoff
+}
+
+// Exhausted reports whether an attempt failing attempts times is given up on.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
@@ -0,0 +1,38 @@
+package core_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	t.Parallel()
+
+	noJitter := core.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}
+	assert.Equal(t, time.Second, noJitter.Backoff(1, nil))
+	assert.Equal(t, 4*time.Second, noJitter.Backoff(3, nil))
+	assert.Equal(t, time.Minute, noJitter.Backoff(10, nil))
+
+	retryAfter := &core.RetryAfterError{After: 2 * time.Minute, Err: errors.New("429")}
+	assert.Equal(t, 2*time.Minute, noJitter.Backoff(1, retryAfter))
+
+	unbounded := core.RetryPolicy{InitialBackoff: time.Second, Multiplier: 2}
+	assert.Equal(t, time.Duration(math.MaxInt64), unbounded.Backoff(100, nil))
+
+	jittered := core.DefaultRetryPolicy.Backoff(1, nil)
+	assert.InDelta(t, float64(time.Second), float64(jittered), float64(200*time.Millisecond))
+}
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, core.RetryPolicy{MaxAttempts: 3}.Exhausted(2))
+	assert.True(t, core.RetryPolicy{MaxAttempts: 3}.Exhausted(3))
+	assert.False(t, core.RetryPolicy{}.Exhausted(100))
+}
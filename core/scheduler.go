@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrNoScheduler = errors.New("no scheduler configured")
+
+// Scheduler delivers messages through the dispatch path at a later time.
+type Scheduler interface {
+	// Schedule queues msg to be dispatched after the given delay and returns
+	// the job ID that can be used to cancel it.
+	Schedule(ctx context.Context, after time.Duration, msg message.Message) (string, error)
+	Cancel(ctx context.Context, id string) error
+}
+
+func WithScheduler(scheduler Scheduler) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.scheduler = scheduler
+	}
+}
+
+// Schedule dispatches msg to the user after the given delay, e.g. to remind
+// them of something or to ask again when they have not answered.
+func (c *Context) Schedule(after time.Duration, msg *message.Message) (string, error) {
+	if c.parent.scheduler == nil {
+		return "", ErrNoScheduler
+	}
+	return c.parent.scheduler.Schedule(c.ctx, after, *msg)
+}
+
+// CancelSchedule cancels a message queued by Schedule.
+func (c *Context) CancelSchedule(id string) error {
+	if c.parent.scheduler == nil {
+		return ErrNoScheduler
+	}
+	return c.parent.scheduler.Cancel(c.ctx, id)
+}
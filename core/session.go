@@ -1,47 +1,335 @@
 package core
 
 import (
+	"container/list"
 	"context"
 	"sync"
 	"time"
+
+	"github.com/guiflemes/ohmychat/message"
 )
 
 const SessionExpiresAt = time.Duration(5) * time.Minute
 
+// SessionKey returns the key identifying the session a message belongs to.
+// Users are told apart by connector, so the same ID on two connectors gets
+// two sessions.
+func SessionKey(msg message.Message) string {
+	return string(msg.Connector) + ":" + msg.User.ID
+}
+
+type sessionMessageKey struct{}
+
+// withSessionMessage returns ctx telling the session adapter which message
+// the session it gets or creates is for.
+func withSessionMessage(ctx context.Context, msg message.Message) context.Context {
+	return context.WithValue(ctx, sessionMessageKey{}, msg)
+}
+
+// NewSession returns a new idle session of id, last active at now. Session
+// adapters create their sessions with it in GetOrCreate: when the session is
+// loaded for a message, it already carries the user, connector and channel of
+// the message when the OnCreated hooks see it.
+func NewSession(ctx context.Context, id string, now time.Time) *Session {
+	sess := &Session{ID: id, State: IdleState{}, Memory: make(map[string]any), LastActivityAt: now}
+	if msg, ok := ctx.Value(sessionMessageKey{}).(message.Message); ok {
+		sess.UserID = msg.User.ID
+		sess.Connector = msg.Connector
+		sess.ChannelID = msg.ChannelID
+	}
+	return sess
+}
+
 type Session struct {
+	// ID is the key the session adapter keeps the session under, see
+	// SessionKey.
+	ID             string
 	UserID         string
+	Connector      message.MessageConnector
+	ChannelID      string
+	Engine         string
 	State          SessionState
 	Memory         map[string]any
 	LastActivityAt time.Time
+
+	// now is the clock of the ChatContext the session was loaded by.
+	now func() time.Time
+	// values are the values of the session scope, set when the session is
+	// loaded.
+	values *Values
 }
 
 func (s *Session) IsExpired(timeout time.Duration) bool {
-	return time.Since(s.LastActivityAt) > timeout
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	return now().Sub(s.LastActivityAt) > timeout
+}
+
+// ExpiringSessionAdapter is a SessionAdapter removing idle sessions by itself.
+type ExpiringSessionAdapter interface {
+	SessionAdapter
+	// RunSweeper removes idle sessions until ctx is done.
+	RunSweeper(ctx context.Context)
+	// OnExpired registers fn to be called with every session removed for
+	// inactivity, e.g. to tell a user stuck in WaitingInputState that their
+	// session timed out, until unregister is called.
+	OnExpired(fn func(sess Session)) (unregister func())
+}
+
+// GuardedSessionAdapter is an ExpiringSessionAdapter told which sessions are
+// in use, so it does not expire a session while one of its messages is being
+// handled. The ChatContext guards its sessions.
+type GuardedSessionAdapter interface {
+	ExpiringSessionAdapter
+	// GuardSessions makes the sweeper leave alone the sessions inUse reports,
+	// until unregister is called.
+	GuardSessions(inUse func(id string) bool) (unregister func())
+}
+
+// CreatingSessionAdapter is a SessionAdapter telling when it creates a
+// session.
+type CreatingSessionAdapter interface {
+	SessionAdapter
+	// OnCreated registers fn to be called with every session GetOrCreate
+	// creates, until unregister is called.
+	OnCreated(fn func(sess Session)) (unregister func())
+}
+
+type InMemorySessionOption func(r *InMemorySessionRepo)
+
+// WithSessionTTL makes the sweeper remove sessions idle for longer than ttl.
+func WithSessionTTL(ttl time.Duration) InMemorySessionOption {
+	return func(r *InMemorySessionRepo) {
+		r.ttl = ttl
+	}
+}
+
+// WithMaxSessions bounds how many sessions are kept, evicting the least
+// recently used one when a new session would exceed it.
+func WithMaxSessions(max int) InMemorySessionOption {
+	return func(r *InMemorySessionRepo) {
+		r.maxEntries = max
+	}
+}
+
+func WithSweepInterval(interval time.Duration) InMemorySessionOption {
+	return func(r *InMemorySessionRepo) {
+		r.sweepInterval = interval
+	}
+}
+
+// WithSessionClock sets the clock stamping new sessions and driving the
+// sweeper, time.Now by default.
+func WithSessionClock(now func() time.Time) InMemorySessionOption {
+	return func(r *InMemorySessionRepo) {
+		r.now = now
+	}
 }
 
 type InMemorySessionRepo struct {
-	mu    sync.Mutex
-	store map[string]*Session
+	mu            sync.Mutex
+	store         map[string]*list.Element
+	lru           *list.List
+	ttl           time.Duration
+	maxEntries    int
+	sweepInterval time.Duration
+	onExpired     Hooks[func(sess Session)]
+	onCreated     Hooks[func(sess Session)]
+	guards        Hooks[func(id string) bool]
+	now           func() time.Time
 }
 
-func NewInMemorySessionRepo() *InMemorySessionRepo {
-	return &InMemorySessionRepo{
-		store: make(map[string]*Session),
+// memoryEntry is a session kept by the InMemorySessionRepo.
+type memoryEntry struct {
+	session *Session
+	// lastActivity is the activity of the session as last saved, or when it
+	// was created. The sweeper reads it rather than the session, which
+	// belongs to whoever handles its messages.
+	lastActivity time.Time
+}
+
+func NewInMemorySessionRepo(opts ...InMemorySessionOption) *InMemorySessionRepo {
+	r := &InMemorySessionRepo{
+		store:         make(map[string]*list.Element),
+		lru:           list.New(),
+		sweepInterval: time.Minute,
+		now:           time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
-func (r *InMemorySessionRepo) GetOrCreate(_ context.Context, id string) (*Session, error) {
+func (r *InMemorySessionRepo) GetOrCreate(ctx context.Context, id string) (*Session, error) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	if s, ok := r.store[id]; ok {
-		return s, nil
+	if e, ok := r.store[id]; ok {
+		r.lru.MoveToFront(e)
+		r.mu.Unlock()
+		return e.Value.(*memoryEntry).session, nil
+	}
+	s := NewSession(ctx, id, r.now())
+	r.put(&memoryEntry{session: s, lastActivity: s.LastActivityAt})
+	created := *s
+
+	r.mu.Unlock()
+
+	for _, fn := range r.onCreated.All() {
+		fn(created)
 	}
-	s := &Session{UserID: id, State: IdleState{}, Memory: make(map[string]any), LastActivityAt: time.Now()}
-	r.store[id] = s
 	return s, nil
 }
 
+// Save keeps session, putting it back if it was removed while in use.
 func (r *InMemorySessionRepo) Save(_ context.Context, session *Session) error {
+	entry := &memoryEntry{session: session, lastActivity: session.LastActivityAt}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.store[session.ID]; ok {
+		e.Value = entry
+		r.lru.MoveToFront(e)
+		return nil
+	}
+	r.put(entry)
 	return nil
 }
+
+func (r *InMemorySessionRepo) put(entry *memoryEntry) {
+	r.store[entry.session.ID] = r.lru.PushFront(entry)
+
+	for r.maxEntries > 0 && r.lru.Len() > r.maxEntries {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.store, oldest.Value.(*memoryEntry).session.ID)
+	}
+}
+
+// Len returns how many sessions are kept.
+func (r *InMemorySessionRepo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.Len()
+}
+
+func (r *InMemorySessionRepo) OnCreated(fn func(sess Session)) (unregister func()) {
+	return r.onCreated.Add(fn)
+}
+
+func (r *InMemorySessionRepo) OnExpired(fn func(sess Session)) (unregister func()) {
+	return r.onExpired.Add(fn)
+}
+
+func (r *InMemorySessionRepo) GuardSessions(inUse func(id string) bool) (unregister func()) {
+	return r.guards.Add(inUse)
+}
+
+func (r *InMemorySessionRepo) inUse(id string) bool {
+	for _, guard := range r.guards.All() {
+		if guard(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sweep removes the sessions idle for longer than the TTL at now, but those
+// in use, and returns how many were removed. It does nothing when no TTL is
+// set.
+func (r *InMemorySessionRepo) Sweep(now time.Time) int {
+	r.mu.Lock()
+
+	if r.ttl <= 0 {
+		r.mu.Unlock()
+		return 0
+	}
+
+	var expired []Session
+	for e := r.lru.Back(); e != nil; {
+		prev := e.Prev()
+		entry := e.Value.(*memoryEntry)
+		if now.Sub(entry.lastActivity) > r.ttl && !r.inUse(entry.session.ID) {
+			r.lru.Remove(e)
+			delete(r.store, entry.session.ID)
+			expired = append(expired, *entry.session)
+		}
+		e = prev
+	}
+	r.mu.Unlock()
+
+	hooks := r.onExpired.All()
+	for _, s := range expired {
+		for _, fn := range hooks {
+			fn(s)
+		}
+	}
+	return len(expired)
+}
+
+// RunSweeper sweeps the sessions every sweep interval until ctx is done.
+func (r *InMemorySessionRepo) RunSweeper(ctx context.Context) {
+	ticker := time.NewTicker(r.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Sweep(r.now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sessionLocks serialises the access to each session, keeping a lock only
+// while someone holds or waits for it.
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newSessionLocks() *sessionLocks {
+	return &sessionLocks{locks: make(map[string]*sessionLock)}
+}
+
+// held reports whether someone holds or waits for the lock of key.
+func (l *sessionLocks) held(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.locks[key]
+	return ok
+}
+
+func (l *sessionLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -1,5 +1,13 @@
 package core
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
 type SessionState interface {
 	IsState()
 }
@@ -9,6 +17,9 @@ type IdleState struct{}
 func (IdleState) IsState() {}
 
 type WaitingInputState struct {
+	// Name identifies the state to session adapters persisting sessions, see
+	// StateRegistry.
+	Name               string
 	PromptEmptyMessage string
 	PromptExit         string
 	ExitInput          string // do not use exit as input for cli connector is a reserved keyword for it
@@ -18,6 +29,9 @@ type WaitingInputState struct {
 func (WaitingInputState) IsState() {}
 
 type WaitingChoiceState struct {
+	// Name identifies the state to session adapters persisting sessions, see
+	// StateRegistry.
+	Name                string
 	Prompt              string
 	PromptInvalidOption string
 	Choices             Choices
@@ -33,3 +47,184 @@ func (c Choices) BindMany(action ActionFunc, options ...string) Choices {
 	}
 	return c
 }
+
+var (
+	ErrUnknownState = errors.New("unknown session state")
+	// ErrUnnamedState is returned for a WaitingInputState or
+	// WaitingChoiceState not obtained from StateRegistry.Register, whose
+	// actions cannot be persisted.
+	ErrUnnamedState = errors.New("session state has no name")
+)
+
+// IdleStateName is the name IdleState is registered under.
+const IdleStateName = "idle"
+
+// stateTypes holds the state types registered by RegisterStateType, known to
+// every StateRegistry.
+var stateTypes = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{byName: make(map[string]reflect.Type), byType: make(map[reflect.Type]string)}
+
+// RegisterStateType makes every state of type T known to the registries
+// under name, its fields persisted as JSON. It is meant for states carrying
+// data rather than actions, and is called from an init function of the
+// package defining them. It panics if name or T is registered already.
+func RegisterStateType[T SessionState](name string) {
+	typ := reflect.TypeFor[T]()
+
+	stateTypes.Lock()
+	defer stateTypes.Unlock()
+
+	if name == "" || name == IdleStateName {
+		panic(fmt.Sprintf("core: state type %s registered as %q", typ, name))
+	}
+	if other, ok := stateTypes.byName[name]; ok {
+		panic(fmt.Sprintf("core: state type %s registered as %q, taken by %s", typ, name, other))
+	}
+	if other, ok := stateTypes.byType[typ]; ok {
+		panic(fmt.Sprintf("core: state type %s registered again as %q, registered as %q", typ, name, other))
+	}
+	stateTypes.byName[name] = typ
+	stateTypes.byType[typ] = name
+}
+
+func stateType(name string) (reflect.Type, bool) {
+	stateTypes.RLock()
+	defer stateTypes.RUnlock()
+	typ, ok := stateTypes.byName[name]
+	return typ, ok
+}
+
+func stateTypeName(typ reflect.Type) (string, bool) {
+	stateTypes.RLock()
+	defer stateTypes.RUnlock()
+	name, ok := stateTypes.byType[typ]
+	return name, ok
+}
+
+// StateRegistry names session states, so session adapters storing sessions
+// outside the process can persist a state by name and get it back, actions
+// included, when loading the session. Besides the states registered on it,
+// it knows IdleState and the types registered by RegisterStateType. It is
+// safe for concurrent use.
+type StateRegistry struct {
+	mu     sync.RWMutex
+	states map[string]SessionState
+}
+
+func NewStateRegistry() *StateRegistry {
+	return &StateRegistry{states: map[string]SessionState{IdleStateName: IdleState{}}}
+}
+
+// Register makes state known under name and returns it named, to be used as
+// the state the actions move sessions to. WaitingInputState and
+// WaitingChoiceState are named through their Name field; other states must be
+// comparable, and are recognised by equality. It panics when state cannot be
+// recognised or name is empty or taken by a state type, so that wiring
+// mistakes show when the registry is built rather than when sessions are
+// saved.
+func (r *StateRegistry) Register(name string, state SessionState) SessionState {
+	if name == "" {
+		panic(fmt.Sprintf("core: state %s registered without a name", StateName(state)))
+	}
+	if _, ok := stateType(name); ok {
+		panic(fmt.Sprintf("core: state %s registered as %q, taken by a state type", StateName(state), name))
+	}
+
+	switch s := state.(type) {
+	case WaitingInputState:
+		s.Name = name
+		state = s
+	case WaitingChoiceState:
+		s.Name = name
+		state = s
+	default:
+		if state == nil || !reflect.TypeOf(state).Comparable() {
+			panic(fmt.Sprintf("core: state %s registered as %q is not comparable, register its type with RegisterStateType", StateName(state), name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[name] = state
+	return state
+}
+
+// Name returns the name state was registered under, by itself or by type.
+func (r *StateRegistry) Name(state SessionState) (string, error) {
+	name, _, err := r.Encode(state)
+	return name, err
+}
+
+// Encode returns the name state is persisted under and, for a state
+// registered by type, its fields as JSON.
+func (r *StateRegistry) Encode(state SessionState) (name string, data json.RawMessage, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	switch s := state.(type) {
+	case WaitingInputState:
+		if s.Name == "" {
+			return "", nil, fmt.Errorf("%w: %w: %s", ErrUnknownState, ErrUnnamedState, StateName(state))
+		}
+		if _, ok := r.states[s.Name]; ok {
+			return s.Name, nil, nil
+		}
+	case WaitingChoiceState:
+		if s.Name == "" {
+			return "", nil, fmt.Errorf("%w: %w: %s", ErrUnknownState, ErrUnnamedState, StateName(state))
+		}
+		if _, ok := r.states[s.Name]; ok {
+			return s.Name, nil, nil
+		}
+	default:
+		if state == nil {
+			break
+		}
+		if name, ok := stateTypeName(reflect.TypeOf(state)); ok {
+			data, err := json.Marshal(state)
+			if err != nil {
+				return "", nil, fmt.Errorf("encode state %s: %w", name, err)
+			}
+			return name, data, nil
+		}
+		if reflect.TypeOf(state).Comparable() {
+			for name, registered := range r.states {
+				if reflect.TypeOf(registered).Comparable() && registered == state {
+					return name, nil, nil
+				}
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("%w: %s", ErrUnknownState, StateName(state))
+}
+
+// State returns the state registered under name. A state registered by type
+// is returned zeroed, see Decode.
+func (r *StateRegistry) State(name string) (SessionState, error) {
+	return r.Decode(name, nil)
+}
+
+// Decode returns the state persisted as name and data by Encode.
+func (r *StateRegistry) Decode(name string, data json.RawMessage) (SessionState, error) {
+	if typ, ok := stateType(name); ok {
+		ptr := reflect.New(typ)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+				return nil, fmt.Errorf("decode state %s: %w", name, err)
+			}
+		}
+		return ptr.Elem().Interface().(SessionState), nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	state, ok := r.states[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownState, name)
+	}
+	return state, nil
+}
@@ -3,8 +3,10 @@ package core_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,11 +23,22 @@ func TestInMemorySessionRepo(t *testing.T) {
 		session, err := repo.GetOrCreate(ctx, "user123")
 		assert.NoError(t, err)
 		assert.NotNil(t, session)
-		assert.Equal(t, "user123", session.UserID)
+		assert.Equal(t, "user123", session.ID)
 		assert.NotNil(t, session.Memory)
 		assert.IsType(t, core.IdleState{}, session.State)
 	})
 
+	t.Run("stamps new sessions with its clock", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		repo := core.NewInMemorySessionRepo(core.WithSessionClock(func() time.Time { return now }))
+
+		session, err := repo.GetOrCreate(context.Background(), "user789")
+		assert.NoError(t, err)
+		assert.Equal(t, now, session.LastActivityAt)
+	})
+
 	t.Run("returns the same session on second call", func(t *testing.T) {
 		t.Parallel()
 
@@ -55,4 +68,226 @@ func TestInMemorySessionRepo(t *testing.T) {
 		err := repo.Save(ctx, session)
 		assert.NoError(t, err)
 	})
+	t.Run("sweep removes idle sessions and calls the hooks", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute))
+		ctx := context.Background()
+
+		var expired []core.Session
+		repo.OnExpired(func(s core.Session) { expired = append(expired, s) })
+
+		idle, _ := repo.GetOrCreate(ctx, "idle")
+		idle.State = core.WaitingInputState{}
+		idle.LastActivityAt = time.Now().Add(-2 * time.Minute)
+		assert.NoError(t, repo.Save(ctx, idle))
+		_, _ = repo.GetOrCreate(ctx, "active")
+
+		assert.Equal(t, 1, repo.Sweep(time.Now()))
+		assert.Equal(t, 1, repo.Len())
+		assert.Len(t, expired, 1)
+		assert.Equal(t, "idle", expired[0].ID)
+		assert.IsType(t, core.WaitingInputState{}, expired[0].State)
+
+		again, _ := repo.GetOrCreate(ctx, "idle")
+		assert.IsType(t, core.IdleState{}, again.State)
+	})
+
+	t.Run("sweep leaves alone the sessions in use", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute))
+		ctx := context.Background()
+
+		expired := 0
+		unregister := repo.OnExpired(func(core.Session) { expired++ })
+		unguard := repo.GuardSessions(func(id string) bool { return id == "busy" })
+
+		_, _ = repo.GetOrCreate(ctx, "busy")
+		assert.Equal(t, 0, repo.Sweep(time.Now().Add(time.Hour)))
+		assert.Equal(t, 1, repo.Len())
+
+		unguard()
+		unregister()
+		unregister()
+		assert.Equal(t, 1, repo.Sweep(time.Now().Add(time.Hour)))
+		assert.Zero(t, expired)
+	})
+
+	t.Run("sweep does nothing without a ttl", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo()
+		s, _ := repo.GetOrCreate(context.Background(), "old")
+		s.LastActivityAt = time.Time{}
+		assert.NoError(t, repo.Save(context.Background(), s))
+
+		assert.Equal(t, 0, repo.Sweep(time.Now()))
+		assert.Equal(t, 1, repo.Len())
+	})
+
+	t.Run("evicts the least recently used session", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithMaxSessions(2))
+		ctx := context.Background()
+
+		first, _ := repo.GetOrCreate(ctx, "first")
+		first.Memory["order"] = "PD:1"
+		_, _ = repo.GetOrCreate(ctx, "second")
+		_, _ = repo.GetOrCreate(ctx, "first")
+		_, _ = repo.GetOrCreate(ctx, "third")
+
+		assert.Equal(t, 2, repo.Len())
+
+		kept, _ := repo.GetOrCreate(ctx, "first")
+		assert.Equal(t, "PD:1", kept.Memory["order"])
+
+		evicted, _ := repo.GetOrCreate(ctx, "second")
+		assert.Empty(t, evicted.Memory)
+	})
+
+	t.Run("save keeps a session removed while in use", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(core.WithSessionTTL(time.Minute))
+		ctx := context.Background()
+
+		s, _ := repo.GetOrCreate(ctx, "busy")
+		repo.Sweep(time.Now().Add(time.Hour))
+
+		s.Memory["key"] = "value"
+		assert.NoError(t, repo.Save(ctx, s))
+
+		got, _ := repo.GetOrCreate(ctx, "busy")
+		assert.Same(t, s, got)
+	})
+
+	t.Run("sweeper runs until the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		repo := core.NewInMemorySessionRepo(
+			core.WithSessionTTL(time.Millisecond),
+			core.WithSweepInterval(time.Millisecond),
+		)
+
+		expired := make(chan core.Session, 1)
+		repo.OnExpired(func(s core.Session) { expired <- s })
+
+		_, _ = repo.GetOrCreate(context.Background(), "sleepy")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			repo.RunSweeper(ctx)
+			close(done)
+		}()
+
+		select {
+		case s := <-expired:
+			assert.Equal(t, "sleepy", s.ID)
+		case <-time.After(time.Second):
+			t.Fatal("session was not swept")
+		}
+
+		cancel()
+		<-done
+	})
+}
+
+type customState struct{ Step int }
+
+func (customState) IsState() {}
+
+type typedState struct {
+	Reason string
+	Step   int
+}
+
+func (typedState) IsState() {}
+
+type sliceState []string
+
+func (sliceState) IsState() {}
+
+func init() {
+	core.RegisterStateType[typedState]("test.typed")
+}
+
+func TestStateRegistry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("names registered states and gets them back", func(t *testing.T) {
+		t.Parallel()
+
+		registry := core.NewStateRegistry()
+		called := false
+		waiting := registry.Register("waiting_order", core.WaitingInputState{
+			Action: func(*core.Context, *message.Message) { called = true },
+		})
+		registry.Register("step_two", customState{Step: 2})
+
+		for name, state := range map[string]core.SessionState{
+			"waiting_order": waiting,
+			"step_two":      customState{Step: 2},
+			"idle":          core.IdleState{},
+		} {
+			got, err := registry.Name(state)
+			assert.NoError(t, err)
+			assert.Equal(t, name, got)
+		}
+
+		state, err := registry.State("waiting_order")
+		assert.NoError(t, err)
+		state.(core.WaitingInputState).Action(nil, nil)
+		assert.True(t, called)
+	})
+
+	t.Run("rejects unknown states", func(t *testing.T) {
+		t.Parallel()
+
+		registry := core.NewStateRegistry()
+		registry.Register("step_two", customState{Step: 2})
+
+		for _, state := range []core.SessionState{
+			core.WaitingInputState{},
+			core.WaitingChoiceState{Name: "not_registered"},
+			customState{Step: 3},
+			nil,
+		} {
+			_, err := registry.Name(state)
+			assert.ErrorIs(t, err, core.ErrUnknownState)
+		}
+		_, err := registry.State("nope")
+		assert.ErrorIs(t, err, core.ErrUnknownState)
+		_, err = registry.Name(core.WaitingChoiceState{})
+		assert.ErrorIs(t, err, core.ErrUnnamedState)
+	})
+
+	t.Run("persists the states registered by type with their fields", func(t *testing.T) {
+		t.Parallel()
+
+		registry := core.NewStateRegistry()
+		name, data, err := registry.Encode(typedState{Reason: "refund", Step: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, "test.typed", name)
+
+		state, err := registry.Decode(name, data)
+		assert.NoError(t, err)
+		assert.Equal(t, typedState{Reason: "refund", Step: 3}, state)
+
+		state, err = registry.State(name)
+		assert.NoError(t, err)
+		assert.Equal(t, typedState{}, state)
+	})
+
+	t.Run("panics on states it cannot recognise", func(t *testing.T) {
+		t.Parallel()
+
+		registry := core.NewStateRegistry()
+		assert.Panics(t, func() { registry.Register("", customState{}) })
+		assert.Panics(t, func() { registry.Register("choice", sliceState{}) })
+		assert.Panics(t, func() { registry.Register("test.typed", customState{}) })
+		assert.Panics(t, func() { core.RegisterStateType[typedState]("test.typed_again") })
+	})
 }
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Meta keys carrying the trace of a message through the pipeline.
+const (
+	TraceIDMeta = "trace_id"
+	SpanIDMeta  = "span_id"
+)
+
+// Span is a timed step of the handling of a message. IDs are hex encoded and
+// sized like W3C trace context ones, 16 bytes for traces and 8 for spans.
+type Span struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// SpanExporter receives every finished span. ExportSpan is called from the
+// pipeline goroutines, so it must be safe for concurrent use and return fast.
+type SpanExporter interface {
+	ExportSpan(span Span) error
+}
+
+// WithTracing records spans around session loads and saves, engine calls and
+// dispatches, and hands them to exp.
+func WithTracing(exp SpanExporter) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.spanExporter = exp
+	}
+}
+
+// StartTrace gives msg a new trace ID unless it already carries one, and
+// returns it. Connectors call it in Acquire for every message they receive.
+// The ID is set on a copy of msg.Meta, leaving other copies of msg untouched.
+func StartTrace(msg *message.Message) string {
+	if id := TraceID(*msg); id != "" {
+		return id
+	}
+	id := randomID(16)
+	msg.SetMeta(TraceIDMeta, id)
+	return id
+}
+
+// TraceID returns the trace ID carried by msg, if any.
+func TraceID(msg message.Message) string {
+	return msg.Meta.Get(TraceIDMeta)
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type spanContextKey struct{}
+
+// spanContext identifies the span a step belongs to.
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+func spanFromMessage(msg message.Message) spanContext {
+	return spanContext{traceID: TraceID(msg), spanID: msg.Meta.Get(SpanIDMeta)}
+}
+
+func spanFromContext(ctx context.Context) spanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(spanContext)
+	return sc
+}
+
+// activeSpan is a span being recorded. A nil activeSpan records nothing, so
+// callers need not check whether tracing is enabled.
+type activeSpan struct {
+	exporter SpanExporter
+	chatCtx  *ChatContext
+	span     Span
+}
+
+// startSpan starts a span child of parent. Nothing is recorded when tracing
+// is disabled or parent has no trace.
+func (c *ChatContext) startSpan(parent spanContext, name string, msg *message.Message) *activeSpan {
+	if c.spanExporter == nil || parent.traceID == "" {
+		return nil
+	}
+
+	span := Span{
+		TraceID:  parent.traceID,
+		SpanID:   randomID(8),
+		ParentID: parent.spanID,
+		Name:     name,
+		Start:    time.Now(),
+	}
+	if msg != nil {
+		span.Attributes = map[string]string{
+			"message.id": msg.ID,
+			"user.id":    msg.User.ID,
+			"connector":  string(msg.Connector),
+		}
+	}
+	return &activeSpan{exporter: c.spanExporter, chatCtx: c, span: span}
+}
+
+func (s *activeSpan) context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+	return spanContext{traceID: s.span.TraceID, spanID: s.span.SpanID}
+}
+
+// with returns ctx carrying the span, so the steps run under ctx become its
+// children.
+func (s *activeSpan) with(ctx context.Context) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, s.context())
+}
+
+func (s *activeSpan) setAttribute(name, value string) {
+	if s == nil {
+		return
+	}
+	if s.span.Attributes == nil {
+		s.span.Attributes = make(map[string]string)
+	}
+	s.span.Attributes[name] = value
+}
+
+func (s *activeSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+	if err := s.exporter.ExportSpan(s.span); err != nil {
+		s.chatCtx.SendEvent(NewEventError(err))
+	}
+}
+
+// withSpanMeta returns msg carrying sc in its own copy of Meta, so the copies
+// of msg already handed out keep theirs.
+func withSpanMeta(msg message.Message, sc spanContext) message.Message {
+	msg.SetMeta(TraceIDMeta, sc.traceID)
+	msg.SetMeta(SpanIDMeta, sc.spanID)
+	return msg
+}
+
+// traceMessage starts the span covering the handling of msg, giving msg a
+// trace first if its connector did not. The returned message carries the span
+// so the following steps, and the replies, are recorded as its children.
+func (c *ChatContext) traceMessage(msg message.Message) (message.Message, *activeSpan) {
+	if c.spanExporter == nil {
+		return msg, nil
+	}
+	if TraceID(msg) == "" {
+		msg = withSpanMeta(msg, spanContext{traceID: randomID(16)})
+	}
+
+	span := c.startSpan(spanFromMessage(msg), "message.process", &msg)
+	return withSpanMeta(msg, span.context()), span
+}
@@ -0,0 +1,116 @@
+package core_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []core.Span
+}
+
+func (r *spanRecorder) ExportSpan(span core.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func (r *spanRecorder) byName() map[string]core.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make(map[string]core.Span)
+	for _, s := range r.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("start trace keeps an existing trace and copies meta", func(t *testing.T) {
+		t.Parallel()
+
+		var msg message.Message
+		id := core.StartTrace(&msg)
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, core.TraceID(msg))
+		assert.Equal(t, id, core.StartTrace(&msg))
+
+		shared := message.NewMessage()
+		copied := shared
+		core.StartTrace(&copied)
+		assert.Empty(t, core.TraceID(shared))
+	})
+
+	t.Run("records the steps of a message under one trace", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Dispatch(gomock.Any()).Return(assert.AnError)
+
+		multi, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+		multi.SetConfig(core.ConnectorConfig{ResponseMaxPool: 1})
+
+		recorder := &spanRecorder{}
+		chatCtx := core.NewChatContext(make(chan core.Event, 4), core.WithTracing(recorder))
+		defer chatCtx.Shutdown()
+
+		proc := core.NewProcessor(core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SendOutput(msg)
+		}))
+
+		msg := message.Message{ID: "m1", User: message.User{ID: "ace"}, Connector: message.Test}
+		traceID := core.StartTrace(&msg)
+
+		input := make(chan message.Message, 1)
+		output := make(chan message.Message, 1)
+		input <- msg
+		close(input)
+
+		proc.Process(chatCtx, input, output)
+		close(output)
+		multi.Response(chatCtx, output)
+
+		spans := recorder.byName()
+		assert.Len(t, spans, 5)
+
+		root := spans["message.process"]
+		assert.Equal(t, traceID, root.TraceID)
+		assert.Empty(t, root.ParentID)
+		assert.Equal(t, "m1", root.Attributes["message.id"])
+		assert.Equal(t, "ace", root.Attributes["user.id"])
+
+		for _, name := range []string{"session.load", "engine.handle", "session.save", "connector.dispatch"} {
+			span := spans[name]
+			assert.Equal(t, traceID, span.TraceID, name)
+			assert.Equal(t, root.SpanID, span.ParentID, name)
+			assert.False(t, span.End.Before(span.Start), name)
+		}
+		assert.Equal(t, assert.AnError.Error(), spans["connector.dispatch"].Error)
+	})
+
+	t.Run("records nothing without an exporter", func(t *testing.T) {
+		t.Parallel()
+
+		msg := message.Message{User: message.User{ID: "sabo"}}
+		ctx := newTestContext(t, msg, make(chan message.Message, 1))
+		ctx.SendOutput(&msg)
+
+		assert.Empty(t, core.TraceID(msg))
+	})
+}
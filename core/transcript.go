@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+)
+
+type Direction string
+
+const (
+	Inbound  Direction = "inbound"
+	Outbound Direction = "outbound"
+)
+
+// TranscriptEntry is a message said by a user or answered by the bot.
+type TranscriptEntry struct {
+	MessageID string                   `json:"message_id"`
+	TraceID   string                   `json:"trace_id,omitempty"`
+	Direction Direction                `json:"direction"`
+	UserID    string                   `json:"user_id"`
+	ChannelID string                   `json:"channel_id"`
+	Connector message.MessageConnector `json:"connector"`
+	Text      string                   `json:"text"`
+	// Options are the IDs of the choices offered by an outbound message.
+	Options []string `json:"options,omitempty"`
+	// StateBefore and StateAfter are the session state types around the
+	// handling of an inbound message.
+	StateBefore string    `json:"state_before,omitempty"`
+	StateAfter  string    `json:"state_after,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// TranscriptQuery selects entries. Zero fields match everything; From is
+// inclusive and To exclusive. A positive Limit keeps only the latest entries.
+type TranscriptQuery struct {
+	UserID    string
+	ChannelID string
+	Connector message.MessageConnector
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+func (q TranscriptQuery) Match(e TranscriptEntry) bool {
+	switch {
+	case q.UserID != "" && e.UserID != q.UserID:
+		return false
+	case q.ChannelID != "" && e.ChannelID != q.ChannelID:
+		return false
+	case q.Connector != "" && e.Connector != q.Connector:
+		return false
+	case !q.From.IsZero() && e.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !e.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// Apply orders the matching entries by time and applies the limit.
+func (q TranscriptQuery) Apply(entries []TranscriptEntry) []TranscriptEntry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries
+}
+
+// TranscriptStore keeps the history of the conversations. The processor
+// appends every inbound message and the connectors' responses every outbound
+// one, so entries are not necessarily appended in time order.
+type TranscriptStore interface {
+	Append(ctx context.Context, entry TranscriptEntry) error
+	Query(ctx context.Context, q TranscriptQuery) ([]TranscriptEntry, error)
+}
+
+// WithTranscript records every inbound and outbound message in store.
+func WithTranscript(store TranscriptStore) ChatContextOption {
+	return func(ctx *ChatContext) {
+		ctx.transcript = store
+	}
+}
+
+// StateName names the type of a session state, as recorded in transcripts.
+func StateName(state SessionState) string {
+	return fmt.Sprintf("%T", state)
+}
+
+func transcriptEntry(msg message.Message, direction Direction, text string, at time.Time) TranscriptEntry {
+	return TranscriptEntry{
+		MessageID: msg.ID,
+		TraceID:   TraceID(msg),
+		Direction: direction,
+		UserID:    msg.User.ID,
+		ChannelID: msg.ChannelID,
+		Connector: msg.Connector,
+		Text:      text,
+		Time:      at,
+	}
+}
+
+func (c *ChatContext) record(entry TranscriptEntry) {
+	if c.transcript == nil {
+		return
+	}
+	if err := c.transcript.Append(c.ctx, entry); err != nil {
+		c.logger.Error("append transcript", slog.String(LogMessageID, entry.MessageID), slog.Any("error", err))
+		c.SendEvent(NewEventError(err))
+	}
+}
@@ -0,0 +1,97 @@
+package core_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type transcriptRecorder struct {
+	mu      sync.Mutex
+	entries []core.TranscriptEntry
+}
+
+func (r *transcriptRecorder) Append(_ context.Context, entry core.TranscriptEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *transcriptRecorder) Query(_ context.Context, q core.TranscriptQuery) ([]core.TranscriptEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return q.Apply(append([]core.TranscriptEntry(nil), r.entries...)), nil
+}
+
+func TestTranscript(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records inbound and outbound messages", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Dispatch(gomock.Any()).Return(nil)
+
+		multi, err := core.NewMuitiChannelConnector(conn)
+		assert.NoError(t, err)
+		multi.SetConfig(core.ConnectorConfig{ResponseMaxPool: 1})
+
+		store := &transcriptRecorder{}
+		chatCtx := core.NewChatContext(make(chan core.Event, 4), core.WithTranscript(store))
+		defer chatCtx.Shutdown()
+
+		proc := core.NewProcessor(core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SetSessionState(core.WaitingInputState{})
+			msg.Output = "Qual o número do pedido?"
+			ctx.SendOutput(msg)
+		}))
+
+		input := make(chan message.Message, 1)
+		output := make(chan message.Message, 1)
+		input <- message.Message{ID: "m1", User: message.User{ID: "ace"}, ChannelID: "c1", Connector: message.Test, Input: "fazer pedido"}
+		close(input)
+
+		proc.Process(chatCtx, input, output)
+		close(output)
+		multi.Response(chatCtx, output)
+
+		entries, err := store.Query(context.Background(), core.TranscriptQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		in, out := entries[0], entries[1]
+		assert.Equal(t, core.Inbound, in.Direction)
+		assert.Equal(t, "m1", in.MessageID)
+		assert.Equal(t, "ace", in.UserID)
+		assert.Equal(t, "c1", in.ChannelID)
+		assert.Equal(t, "fazer pedido", in.Text)
+		assert.Equal(t, "core.IdleState", in.StateBefore)
+		assert.Equal(t, "core.WaitingInputState", in.StateAfter)
+
+		assert.Equal(t, core.Outbound, out.Direction)
+		assert.Equal(t, "Qual o número do pedido?", out.Text)
+		assert.Empty(t, out.Error)
+		assert.False(t, out.Time.Before(in.Time))
+	})
+
+	t.Run("query matches filters", func(t *testing.T) {
+		t.Parallel()
+
+		q := core.TranscriptQuery{UserID: "ace", Connector: message.Cli}
+		assert.True(t, q.Match(core.TranscriptEntry{UserID: "ace", Connector: message.Cli}))
+		assert.False(t, q.Match(core.TranscriptEntry{UserID: "ace", Connector: message.Telegram}))
+		assert.False(t, q.Match(core.TranscriptEntry{UserID: "sabo", Connector: message.Cli}))
+	})
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var ErrNotProvided = errors.New("value not provided")
+
+// Values holds values keyed by their type, such as the clients actions share.
+// It is safe for concurrent use.
+//
+// Values come in three scopes: the global one of the ChatContext, one per
+// session and one per message being handled. Resolve looks them up from the
+// narrowest scope to the widest.
+type Values struct {
+	mu     sync.RWMutex
+	values map[valueKey]any
+}
+
+// valueKey identifies a value by the type it was provided as, or by name for
+// the untyped values of ChatContext.Set.
+type valueKey struct {
+	typ  reflect.Type
+	name string
+}
+
+func NewValues() *Values {
+	return &Values{}
+}
+
+func (v *Values) set(key valueKey, value any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.values == nil {
+		v.values = make(map[valueKey]any)
+	}
+	v.values[key] = value
+}
+
+func (v *Values) lookup(key valueKey) (any, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	value, ok := v.values[key]
+	return value, ok
+}
+
+func (v *Values) delete(key valueKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.values, key)
+}
+
+// Resolver looks values up. It is implemented by *Values, looking in itself
+// only, by *ChatContext, looking in the global scope, and by *Context,
+// looking in the scope of the message, then of its session, then global.
+type Resolver interface {
+	lookup(key valueKey) (any, bool)
+}
+
+func keyOf[T any]() valueKey {
+	return valueKey{typ: reflect.TypeFor[T]()}
+}
+
+// Provide makes value resolvable as a T from v, replacing the T provided
+// before. T is usually an interface, so actions do not depend on the concrete
+// client.
+func Provide[T any](v *Values, value T) {
+	v.set(keyOf[T](), value)
+}
+
+// Unprovide removes the T provided to v.
+func Unprovide[T any](v *Values) {
+	v.delete(keyOf[T]())
+}
+
+// Resolve returns the T provided to the narrowest scope r reaches.
+func Resolve[T any](r Resolver) (T, bool) {
+	value, ok := r.lookup(keyOf[T]())
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// MustResolve returns the T provided to the narrowest scope r reaches,
+// panicking if none was, as a missing service is a wiring mistake.
+func MustResolve[T any](r Resolver) T {
+	value, ok := Resolve[T](r)
+	if !ok {
+		panic(fmt.Errorf("%w: %s", ErrNotProvided, reflect.TypeFor[T]()))
+	}
+	return value
+}
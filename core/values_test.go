@@ -0,0 +1,112 @@
+package core_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type greeter interface {
+	Greet(name string) string
+}
+
+type greeterFunc func(name string) string
+
+func (f greeterFunc) Greet(name string) string { return f(name) }
+
+func greeting(prefix string) greeter {
+	return greeterFunc(func(name string) string { return prefix + " " + name })
+}
+
+func childContext(t *testing.T, ctx *core.ChatContext, userID string) *core.Context {
+	t.Helper()
+
+	child, err := ctx.NewChildContext(message.Message{User: message.User{ID: userID}}, nil)
+	assert.NoError(t, err)
+	t.Cleanup(child.Cancel)
+	return child
+}
+
+func TestValues(t *testing.T) {
+	t.Parallel()
+
+	t.Run("resolves values by the type they were provided as", func(t *testing.T) {
+		t.Parallel()
+
+		values := core.NewValues()
+		core.Provide[greeter](values, greeting("olá"))
+		core.Provide(values, 42)
+
+		g, ok := core.Resolve[greeter](values)
+		assert.True(t, ok)
+		assert.Equal(t, "olá luffy", g.Greet("luffy"))
+		assert.Equal(t, 42, core.MustResolve[int](values))
+
+		_, ok = core.Resolve[greeterFunc](values)
+		assert.False(t, ok)
+
+		core.Unprovide[int](values)
+		_, ok = core.Resolve[int](values)
+		assert.False(t, ok)
+	})
+
+	t.Run("panics when resolving a missing value", func(t *testing.T) {
+		t.Parallel()
+
+		assert.PanicsWithError(t, fmt.Sprintf("%s: core_test.greeter", core.ErrNotProvided), func() {
+			core.MustResolve[greeter](core.NewValues())
+		})
+	})
+
+	t.Run("looks up the message, session and global scopes in turn", func(t *testing.T) {
+		t.Parallel()
+
+		values := core.NewValues()
+		core.Provide[greeter](values, greeting("olá"))
+		core.Provide(values, "global")
+		ctx := core.NewChatContext(nil, core.WithValues(values))
+		defer ctx.Shutdown()
+
+		first := childContext(t, ctx, "zoro")
+		core.Provide(first.SessionValues(), "session")
+		assert.Equal(t, "session", core.MustResolve[string](first))
+		core.Provide(first.Values(), "request")
+		assert.Equal(t, "request", core.MustResolve[string](first))
+		assert.Equal(t, "olá zoro", core.MustResolve[greeter](first).Greet("zoro"))
+
+		again := childContext(t, ctx, "zoro")
+		assert.Equal(t, "session", core.MustResolve[string](again))
+
+		other := childContext(t, ctx, "nami")
+		assert.Equal(t, "global", core.MustResolve[string](other))
+		assert.Equal(t, "global", core.MustResolve[string](ctx))
+	})
+
+	t.Run("is safe for concurrent use", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := core.NewChatContext(nil)
+		defer ctx.Shutdown()
+
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx.Set("counter", i)
+				ctx.Get("counter")
+				core.Provide(ctx.Values(), i)
+				core.Resolve[int](ctx)
+			}()
+		}
+		wg.Wait()
+
+		_, ok := ctx.Get("counter")
+		assert.True(t, ok)
+	})
+}
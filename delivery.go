@@ -0,0 +1,18 @@
+package ohmychat
+
+import (
+	"github.com/guiflemes/ohmychat/delivery"
+)
+
+// WithDelivery keeps every reply in outbox until its connector dispatched it.
+// Failed replies are dispatched again, following the outbox policy, while Run
+// is serving, and the ones left over by a previous run once it starts.
+func WithDelivery(outbox *delivery.Outbox) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if outbox == nil {
+			b.invalid("delivery outbox must not be nil")
+			return
+		}
+		b.config.outbox = outbox
+	}
+}
@@ -0,0 +1,275 @@
+// Package delivery retries the replies connectors failed to dispatch, keeping
+// them in a durable outbox until they are sent or given up on.
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Deliver hands a reply back to the dispatch path.
+type Deliver func(ctx context.Context, msg message.Message) error
+
+type Option func(o *Outbox)
+
+func WithPolicy(policy Policy) Option {
+	return func(o *Outbox) {
+		o.policy = policy
+	}
+}
+
+// WithDeadLetters keeps the replies given up on in store instead of memory.
+func WithDeadLetters(store Store) Option {
+	return func(o *Outbox) {
+		o.deadLetters = store
+	}
+}
+
+func WithClock(clock core.Clock) Option {
+	return func(o *Outbox) {
+		o.clock = clock
+	}
+}
+
+// WithPollInterval sets how often Run checks the store for entries it was
+// not woken up for, e.g. put there by another process. Defaults to a minute.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *Outbox) {
+		o.pollInterval = d
+	}
+}
+
+// WithErrorHandler is called when an entry cannot be stored, removed or handed
+// back to the dispatch path.
+func WithErrorHandler(fn func(entry Entry, err error)) Option {
+	return func(o *Outbox) {
+		o.onError = fn
+	}
+}
+
+// Outbox implements core.Delivery. Every reply is kept in the store from its
+// first dispatch attempt until it is sent; failed ones are dispatched again
+// by Run according to the policy, then moved to the dead letters.
+type Outbox struct {
+	store        Store
+	deadLetters  Store
+	policy       Policy
+	clock        core.Clock
+	pollInterval time.Duration
+	onError      func(entry Entry, err error)
+	wake         chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+}
+
+func NewOutbox(store Store, opts ...Option) *Outbox {
+	o := &Outbox{
+		store:        store,
+		deadLetters:  NewMemoryStore(),
+		policy:       DefaultPolicy,
+		clock:        core.SystemClock,
+		pollInterval: time.Minute,
+		onError:      func(Entry, error) {},
+		wake:         make(chan struct{}, 1),
+		inflight:     make(map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Accept implements core.Delivery.
+func (o *Outbox) Accept(ctx context.Context, msg message.Message) error {
+	id := core.DeliveryID(msg)
+	o.setInflight(id, true)
+
+	_, err := o.store.Get(ctx, id)
+	if !errors.Is(err, ErrEntryNotFound) {
+		return err
+	}
+
+	now := o.clock.Now()
+	return o.store.Put(ctx, Entry{ID: id, Message: msg, AcceptedAt: now, NextAttempt: now})
+}
+
+// Delivered implements core.Delivery.
+func (o *Outbox) Delivered(ctx context.Context, msg message.Message) error {
+	id := core.DeliveryID(msg)
+	defer o.setInflight(id, false)
+
+	if err := o.store.Remove(ctx, id); err != nil && !errors.Is(err, ErrEntryNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Failed implements core.Delivery, scheduling the next attempt of msg or
+// moving it to the dead letters when err is permanent or the policy gives up.
+func (o *Outbox) Failed(ctx context.Context, msg message.Message, err error) bool {
+	id := core.DeliveryID(msg)
+	defer o.setInflight(id, false)
+
+	now := o.clock.Now()
+	entry, getErr := o.store.Get(ctx, id)
+	if getErr != nil {
+		entry = Entry{ID: id, Message: msg, AcceptedAt: now}
+	}
+	entry.Attempts++
+	entry.FailedAt = now
+	entry.LastError = err.Error()
+
+	if core.IsPermanent(err) || o.policy.Exhausted(entry.Attempts) {
+		if err := o.deadLetters.Put(ctx, entry); err != nil {
+			o.onError(entry, err)
+			return false
+		}
+		if err := o.store.Remove(ctx, id); err != nil && !errors.Is(err, ErrEntryNotFound) {
+			o.onError(entry, err)
+		}
+		return false
+	}
+
+	entry.NextAttempt = now.Add(o.policy.Backoff(entry.Attempts, err))
+	if err := o.store.Put(ctx, entry); err != nil {
+		o.onError(entry, err)
+		return false
+	}
+	o.notify()
+	return true
+}
+
+// Pending returns the replies not dispatched yet, oldest first.
+func (o *Outbox) Pending(ctx context.Context) ([]Entry, error) {
+	return o.store.List(ctx)
+}
+
+// DeadLetters returns the replies given up on, oldest first.
+func (o *Outbox) DeadLetters(ctx context.Context) ([]Entry, error) {
+	return o.deadLetters.List(ctx)
+}
+
+// Redrive moves a dead letter back to the outbox, to be dispatched again with
+// a fresh count of attempts.
+func (o *Outbox) Redrive(ctx context.Context, id string) error {
+	entry, err := o.deadLetters.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	entry.Attempts = 0
+	entry.NextAttempt = o.clock.Now()
+	if err := o.store.Put(ctx, entry); err != nil {
+		return err
+	}
+	if err := o.deadLetters.Remove(ctx, id); err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// Discard drops a dead letter for good.
+func (o *Outbox) Discard(ctx context.Context, id string) error {
+	return o.deadLetters.Remove(ctx, id)
+}
+
+// Run hands the due replies back to deliver until ctx is done. Replies left
+// in the store by a previous run are due straight away.
+func (o *Outbox) Run(ctx context.Context, deliver Deliver) {
+	for {
+		select {
+		case <-o.wake:
+		default:
+		}
+
+		wait := o.pollInterval
+		if next, ok := o.deliverDue(ctx, deliver); ok && !next.IsZero() {
+			wait = min(wait, max(next.Sub(o.clock.Now()), 0))
+		}
+
+		select {
+		case <-o.clock.After(wait):
+		case <-o.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverDue hands back the due replies not being dispatched already. A reply
+// that cannot be handed back counts as a failed attempt, so a permanent error
+// moves it to the dead letters. It returns when the next one is due and
+// reports whether all of them went through, so that failing ones are only
+// retried on the next poll.
+func (o *Outbox) deliverDue(ctx context.Context, deliver Deliver) (next time.Time, ok bool) {
+	entries, err := o.store.List(ctx)
+	if err != nil {
+		o.onError(Entry{}, err)
+		return time.Time{}, false
+	}
+
+	ok = true
+	now := o.clock.Now()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return time.Time{}, false
+		}
+		if o.isInflight(entry.ID) {
+			continue
+		}
+		if entry.NextAttempt.After(now) {
+			if next.IsZero() || entry.NextAttempt.Before(next) {
+				next = entry.NextAttempt
+			}
+			continue
+		}
+
+		o.setInflight(entry.ID, true)
+		if err := deliver(ctx, entry.Message); err != nil {
+			ok = false
+			o.onError(entry, err)
+			if ctx.Err() != nil {
+				o.setInflight(entry.ID, false)
+				continue
+			}
+			// the reply never reached the connector, so the attempt is
+			// counted here instead
+			o.Failed(ctx, entry.Message, err)
+		}
+	}
+	return next, ok
+}
+
+func (o *Outbox) setInflight(id string, inflight bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if inflight {
+		o.inflight[id] = struct{}{}
+	} else {
+		delete(o.inflight, id)
+	}
+}
+
+func (o *Outbox) isInflight(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	_, ok := o.inflight[id]
+	return ok
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,215 @@
+package delivery_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/delivery"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After fires shortly whatever d is, so Run looks at the store again once
+// the clock was advanced.
+func (c *fakeClock) After(time.Duration) <-chan time.Time {
+	return time.After(time.Millisecond)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func reply(id, text string) message.Message {
+	msg := message.Message{Output: text, Connector: message.Test, User: message.User{ID: "nami"}}
+	msg.SetMeta(core.DeliveryIDMeta, id)
+	return msg
+}
+
+var noJitter = delivery.Policy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	boom := errors.New("boom")
+
+	t.Run("forgets delivered replies", func(t *testing.T) {
+		t.Parallel()
+
+		outbox := delivery.NewOutbox(delivery.NewMemoryStore())
+		msg := reply("d-1", "oi")
+
+		assert.NoError(t, outbox.Accept(ctx, msg))
+		pending, err := outbox.Pending(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		assert.NoError(t, outbox.Delivered(ctx, msg))
+		pending, err = outbox.Pending(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("schedules retries with backoff then gives up", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		outbox := delivery.NewOutbox(delivery.NewMemoryStore(), delivery.WithPolicy(noJitter), delivery.WithClock(clock))
+		msg := reply("d-1", "oi")
+
+		for attempt, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+			assert.NoError(t, outbox.Accept(ctx, msg))
+			assert.True(t, outbox.Failed(ctx, msg, boom))
+
+			pending, err := outbox.Pending(ctx)
+			assert.NoError(t, err)
+			if assert.Len(t, pending, 1) {
+				assert.Equal(t, attempt+1, pending[0].Attempts)
+				assert.Equal(t, clock.Now().Add(backoff), pending[0].NextAttempt)
+				assert.Equal(t, "boom", pending[0].LastError)
+			}
+		}
+
+		assert.NoError(t, outbox.Accept(ctx, msg))
+		assert.False(t, outbox.Failed(ctx, msg, boom))
+
+		pending, err := outbox.Pending(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+
+		dead, err := outbox.DeadLetters(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, dead, 1) {
+			assert.Equal(t, 3, dead[0].Attempts)
+			assert.Equal(t, "oi", dead[0].Message.Output)
+		}
+	})
+
+	t.Run("gives up on permanent errors straight away", func(t *testing.T) {
+		t.Parallel()
+
+		outbox := delivery.NewOutbox(delivery.NewMemoryStore())
+		msg := reply("d-1", "oi")
+
+		assert.NoError(t, outbox.Accept(ctx, msg))
+		assert.False(t, outbox.Failed(ctx, msg, core.Permanent(boom)))
+
+		dead, err := outbox.DeadLetters(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, dead, 1)
+	})
+
+	t.Run("re-drives and discards dead letters", func(t *testing.T) {
+		t.Parallel()
+
+		outbox := delivery.NewOutbox(delivery.NewMemoryStore())
+		for _, id := range []string{"d-1", "d-2"} {
+			msg := reply(id, "oi")
+			assert.NoError(t, outbox.Accept(ctx, msg))
+			outbox.Failed(ctx, msg, core.Permanent(boom))
+		}
+
+		assert.NoError(t, outbox.Redrive(ctx, "d-1"))
+		assert.NoError(t, outbox.Discard(ctx, "d-2"))
+		assert.ErrorIs(t, outbox.Redrive(ctx, "d-2"), delivery.ErrEntryNotFound)
+
+		dead, err := outbox.DeadLetters(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, dead)
+
+		pending, err := outbox.Pending(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, pending, 1) {
+			assert.Equal(t, "d-1", pending[0].ID)
+			assert.Zero(t, pending[0].Attempts)
+		}
+	})
+
+	t.Run("hands due replies back once", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		store := delivery.NewMemoryStore()
+		outbox := delivery.NewOutbox(store, delivery.WithPolicy(noJitter), delivery.WithClock(clock))
+
+		// left over by a previous run
+		assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "d-0", Message: reply("d-0", "antigo"), NextAttempt: clock.Now()}))
+
+		msg := reply("d-1", "oi")
+		assert.NoError(t, outbox.Accept(ctx, msg))
+		outbox.Failed(ctx, msg, boom)
+
+		delivered := make(chan message.Message, 4)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			outbox.Run(runCtx, func(_ context.Context, m message.Message) error {
+				delivered <- m
+				return nil
+			})
+		}()
+
+		assert.Equal(t, "antigo", (<-delivered).Output)
+
+		clock.Advance(time.Second)
+		assert.Equal(t, "oi", (<-delivered).Output)
+
+		select {
+		case m := <-delivered:
+			t.Fatalf("%q handed back while being dispatched", m.Output)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		cancel()
+		<-done
+	})
+
+	t.Run("gives up on replies that cannot be handed back", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+		store := delivery.NewMemoryStore()
+		outbox := delivery.NewOutbox(store, delivery.WithPolicy(noJitter), delivery.WithClock(clock))
+		assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "d-1", Message: reply("d-1", "oi"), NextAttempt: clock.Now()}))
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			outbox.Run(runCtx, func(context.Context, message.Message) error {
+				return core.ErrUnknownConnector
+			})
+		}()
+
+		assert.Eventually(t, func() bool {
+			dead, err := outbox.DeadLetters(ctx)
+			return err == nil && len(dead) == 1
+		}, time.Second, time.Millisecond)
+
+		pending, err := outbox.Pending(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+
+		cancel()
+		<-done
+	})
+}
@@ -0,0 +1,8 @@
+package delivery
+
+import "github.com/guiflemes/ohmychat/core"
+
+// Policy tells how failed dispatches are retried.
+type Policy = core.RetryPolicy
+
+var DefaultPolicy = core.DefaultRetryPolicy
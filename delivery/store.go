@@ -0,0 +1,170 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/guiflemes/ohmychat/internal/filelog"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrEntryNotFound = errors.New("delivery entry not found")
+
+// Entry is a reply waiting to be dispatched, or given up on.
+type Entry struct {
+	// ID is the delivery ID of the reply, see core.DeliveryID.
+	ID          string          `json:"id"`
+	Message     message.Message `json:"message"`
+	Attempts    int             `json:"attempts"`
+	AcceptedAt  time.Time       `json:"accepted_at"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	FailedAt    time.Time       `json:"failed_at"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Store keeps delivery entries, either the pending ones or the dead letters.
+type Store interface {
+	Put(ctx context.Context, entry Entry) error
+	Get(ctx context.Context, id string) (Entry, error)
+	Remove(ctx context.Context, id string) error
+	// List returns every entry, oldest first.
+	List(ctx context.Context) ([]Entry, error)
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Entry)}
+}
+
+func (s *MemoryStore) Put(_ context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return Entry{}, ErrEntryNotFound
+	}
+	return entry, nil
+}
+
+func (s *MemoryStore) Remove(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return ErrEntryNotFound
+	}
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+	return entries, nil
+}
+
+// FileStore keeps the entries in memory and appends every change to a log at
+// path, so replies accepted before a restart are still dispatched after it.
+// Every change is written to the log before it is applied in memory. A
+// removal lost to a crash only dispatches a reply again.
+type FileStore struct {
+	mem *MemoryStore
+
+	mu  sync.Mutex
+	log *filelog.Log[Entry]
+}
+
+// NewFileStore opens the store at path, loading the entries it already holds.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewMemoryStore()}
+
+	log, err := filelog.Open(path, s.mem.entries)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+	return s, nil
+}
+
+func (s *FileStore) Put(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Put(entry.ID, entry); err != nil {
+		return err
+	}
+	if err := s.mem.Put(ctx, entry); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Entry, error) {
+	return s.mem.Get(ctx, id)
+}
+
+func (s *FileStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := s.log.Remove(id); err != nil {
+		return err
+	}
+	if err := s.mem.Remove(ctx, id); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Entry, error) {
+	return s.mem.List(ctx)
+}
+
+// Close closes the log. The store must not be used afterwards.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close()
+}
+
+func (s *FileStore) maybeCompact() error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	return s.log.MaybeCompact(s.mem.entries)
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].AcceptedAt.Equal(entries[j].AcceptedAt) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].AcceptedAt.Before(entries[j].AcceptedAt)
+	})
+}
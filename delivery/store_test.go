@@ -0,0 +1,150 @@
+package delivery_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/delivery"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	stores := map[string]func(t *testing.T) delivery.Store{
+		"memory": func(t *testing.T) delivery.Store {
+			return delivery.NewMemoryStore()
+		},
+		"file": func(t *testing.T) delivery.Store {
+			store, err := delivery.NewFileStore(filepath.Join(t.TempDir(), "outbox.log"))
+			assert.NoError(t, err)
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := newStore(t)
+			base := time.Unix(1000, 0)
+
+			assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "b", AcceptedAt: base.Add(time.Minute)}))
+			assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "a", AcceptedAt: base}))
+			assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "a", AcceptedAt: base, Attempts: 2}))
+
+			entry, err := store.Get(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, 2, entry.Attempts)
+
+			entries, err := store.List(ctx)
+			assert.NoError(t, err)
+			if assert.Len(t, entries, 2) {
+				assert.Equal(t, "a", entries[0].ID)
+				assert.Equal(t, "b", entries[1].ID)
+			}
+
+			assert.NoError(t, store.Remove(ctx, "a"))
+			assert.ErrorIs(t, store.Remove(ctx, "a"), delivery.ErrEntryNotFound)
+			_, err = store.Get(ctx, "a")
+			assert.ErrorIs(t, err, delivery.ErrEntryNotFound)
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keeps pending replies across restarts", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "outbox.log")
+		at := time.Unix(2000, 0).UTC()
+
+		store, err := delivery.NewFileStore(path)
+		assert.NoError(t, err)
+
+		msg := reply("d-1", "seu pedido saiu")
+		assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "d-1", Message: msg, AcceptedAt: at, NextAttempt: at}))
+		assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "gone", AcceptedAt: at}))
+		assert.NoError(t, store.Remove(ctx, "gone"))
+
+		reopened, err := delivery.NewFileStore(path)
+		assert.NoError(t, err)
+
+		entries, err := reopened.List(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "d-1", entries[0].ID)
+			assert.True(t, entries[0].NextAttempt.Equal(at))
+			assert.Equal(t, msg, entries[0].Message)
+		}
+	})
+
+	t.Run("skips the records it cannot read", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "outbox.log")
+		log := `{"id":"d-1","entry":{"id":"d-1","attempts":1}}
+{"id":"d-2","ent
+{"id":"d-3","entry":{"id":"d-3"}}
+{"id":"d-4","entry":{"id":"d-4"`
+		assert.NoError(t, os.WriteFile(path, []byte(log), 0o600))
+
+		store, err := delivery.NewFileStore(path)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer store.Close()
+
+		entries, err := store.List(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, "d-1", entries[0].ID)
+			assert.Equal(t, "d-3", entries[1].ID)
+		}
+
+		assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "d-5"}))
+		reopened, err := delivery.NewFileStore(path)
+		assert.NoError(t, err)
+		defer reopened.Close()
+
+		entries, err = reopened.List(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+	})
+
+	t.Run("compacts the log", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "outbox.log")
+		store, err := delivery.NewFileStore(path)
+		assert.NoError(t, err)
+		defer store.Close()
+
+		for i := range 500 {
+			assert.NoError(t, store.Put(ctx, delivery.Entry{ID: "d-1", Attempts: i}))
+		}
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Less(t, bytes.Count(data, []byte("\n")), 200)
+
+		reopened, err := delivery.NewFileStore(path)
+		assert.NoError(t, err)
+		defer reopened.Close()
+
+		entry, err := reopened.Get(ctx, "d-1")
+		assert.NoError(t, err)
+		assert.Equal(t, 499, entry.Attempts)
+	})
+}
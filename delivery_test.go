@@ -0,0 +1,132 @@
+package ohmychat_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/delivery"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOhMyChat_Delivery(t *testing.T) {
+	t.Parallel()
+
+	fastRetry := delivery.WithPolicy(delivery.Policy{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond, Multiplier: 2})
+
+	t.Run("retries a failed reply", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dispatched := make(chan message.Message, 1)
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			acquireThenWait(message.Message{User: message.User{ID: "nami"}, Input: "oi", Connector: message.Telegram}),
+		)
+		gomock.InOrder(
+			conn.EXPECT().Dispatch(gomock.Any()).Return(&core.RetryAfterError{After: 10 * time.Millisecond, Err: errors.New("429")}),
+			conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+				dispatched <- m
+				return nil
+			}),
+		)
+
+		outbox := delivery.NewOutbox(delivery.NewMemoryStore(), fastRetry)
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			msg.Output = "olá"
+			ctx.SendOutput(msg)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine), ohmychat.WithDelivery(outbox))
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		select {
+		case m := <-dispatched:
+			assert.Equal(t, "olá", m.Output)
+			assert.NotEmpty(t, core.DeliveryID(m))
+		case <-time.After(time.Second):
+			t.Fatal("reply was not retried")
+		}
+
+		assert.Eventually(t, func() bool {
+			pending, err := outbox.Pending(context.Background())
+			return err == nil && len(pending) == 0
+		}, time.Second, 5*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("dispatches replies left over by a previous run", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := delivery.NewMemoryStore()
+		leftOver := message.Message{Output: "seu pedido saiu", Connector: message.Telegram, ChannelID: "42"}
+		leftOver.SetMeta(core.DeliveryIDMeta, "d-1")
+		assert.NoError(t, store.Put(context.Background(), delivery.Entry{ID: "d-1", Message: leftOver}))
+
+		dispatched := make(chan message.Message, 1)
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(acquireThenWait())
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			dispatched <- m
+			return nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithDelivery(delivery.NewOutbox(store, fastRetry)),
+		)
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		select {
+		case m := <-dispatched:
+			assert.Equal(t, "seu pedido saiu", m.Output)
+		case <-time.After(time.Second):
+			t.Fatal("left over reply was not dispatched")
+		}
+
+		assert.Eventually(t, func() bool {
+			pending, err := store.List(context.Background())
+			return err == nil && len(pending) == 0
+		}, time.Second, 5*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("rejects a nil outbox", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bot := ohmychat.NewOhMyChat(
+			mocks.NewMockConnector(ctrl),
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithDelivery(nil),
+		)
+		assert.ErrorIs(t, bot.Run(context.Background()), ohmychat.ErrInvalidOption)
+	})
+}
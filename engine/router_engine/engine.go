@@ -0,0 +1,181 @@
+package router_engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/utils"
+)
+
+// maxTransfers bounds how many engines may hand the same message over, so
+// engines transferring to each other cannot loop forever.
+const maxTransfers = 5
+
+var ErrNoRoute = errors.New("no engine to route the message to")
+
+type Predicate func(msg message.Message) bool
+
+func ByConnector(connectors ...message.MessageConnector) Predicate {
+	return func(msg message.Message) bool {
+		return slices.Contains(connectors, msg.Connector)
+	}
+}
+
+func ByBotName(names ...string) Predicate {
+	return func(msg message.Message) bool {
+		return slices.Contains(names, msg.BotName)
+	}
+}
+
+func ByChannel(channelIDs ...string) Predicate {
+	return func(msg message.Message) bool {
+		return slices.Contains(channelIDs, msg.ChannelID)
+	}
+}
+
+// Route registers an engine under a name. A message starting a conversation
+// goes to the first route whose predicates all match; routes without
+// predicates are only reached by name, through the default route or
+// core.Context.TransferTo.
+type Route struct {
+	Name       string
+	Engine     core.Engine
+	Predicates []Predicate
+}
+
+func (r Route) match(msg message.Message) bool {
+	if len(r.Predicates) == 0 {
+		return false
+	}
+	for _, p := range r.Predicates {
+		if !p(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+type RouterEngineOption func(engine *RouterEngine)
+
+// WithDefaultRoute names the engine serving messages no route matches.
+// Without it the first registered route is used.
+func WithDefaultRoute(name string) RouterEngineOption {
+	return func(engine *RouterEngine) {
+		engine.defaultRoute = name
+	}
+}
+
+func WithSessionExpiresAt(s time.Duration) RouterEngineOption {
+	return func(engine *RouterEngine) {
+		engine.sessionExpiresAt = utils.PtrOf(s)
+	}
+}
+
+// RouterEngine sends each message to one of several engines and remembers the
+// chosen one in the session, so the rest of the conversation stays with it.
+type RouterEngine struct {
+	routes           []Route
+	defaultRoute     string
+	sessionExpiresAt *time.Duration
+}
+
+func NewRouterEngine(opts ...RouterEngineOption) *RouterEngine {
+	engine := &RouterEngine{}
+
+	for _, opt := range opts {
+		opt(engine)
+	}
+
+	if engine.sessionExpiresAt == nil {
+		engine.sessionExpiresAt = utils.PtrOf(core.SessionExpiresAt)
+	}
+
+	return engine
+}
+
+func (e *RouterEngine) RegisterRoute(route ...Route) {
+	e.routes = append(e.routes, route...)
+}
+
+func (e *RouterEngine) HandleMessage(ctx *core.Context, msg *message.Message) {
+	sess := ctx.Session()
+
+	if sess.IsExpired(*e.sessionExpiresAt) {
+		sess.Engine = ""
+	}
+
+	route, ok := e.route(sess.Engine, *msg)
+	if !ok {
+		ctx.Logger().Error("no engine to route to")
+		ctx.SendEvent(core.NewEventErrorWithMessage(*msg, ErrNoRoute))
+		return
+	}
+	if sess.Engine != route.Name {
+		ctx.Logger().Debug("routed", slog.String("route", route.Name))
+	}
+	sess.Engine = route.Name
+
+	for range maxTransfers {
+		route.Engine.HandleMessage(ctx, msg)
+
+		name, transferred := ctx.ConsumeTransfer()
+		if !transferred || name == route.Name {
+			return
+		}
+
+		ctx.Logger().Info("conversation transferred", slog.String("from", route.Name), slog.String("to", name))
+		sess.Engine = name
+		sess.State = core.IdleState{}
+
+		if name == "" || ctx.MessageHasBeenReplyed() {
+			return
+		}
+
+		next, ok := e.byName(name)
+		if !ok {
+			sess.Engine = ""
+			ctx.Logger().Error("transfer to unknown engine", slog.String("to", name))
+			ctx.SendEvent(core.NewEventErrorWithMessage(*msg, fmt.Errorf("%w: %q", ErrNoRoute, name)))
+			return
+		}
+		route = next
+	}
+}
+
+func (e *RouterEngine) route(current string, msg message.Message) (Route, bool) {
+	if route, ok := e.byName(current); ok {
+		return route, true
+	}
+
+	for _, route := range e.routes {
+		if route.match(msg) {
+			return route, true
+		}
+	}
+
+	if e.defaultRoute != "" {
+		return e.byName(e.defaultRoute)
+	}
+
+	if len(e.routes) == 0 {
+		return Route{}, false
+	}
+	return e.routes[0], true
+}
+
+func (e *RouterEngine) byName(name string) (Route, bool) {
+	if name == "" {
+		return Route{}, false
+	}
+	for _, route := range e.routes {
+		if route.Name == name {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
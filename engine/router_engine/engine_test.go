@@ -0,0 +1,156 @@
+package router_engine_test
+
+import (
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/router_engine"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestContext(t *testing.T, chatCtx *core.ChatContext, msg message.Message) *core.Context {
+	t.Helper()
+
+	ctx, err := chatCtx.NewChildContext(msg, make(chan message.Message, 4))
+	assert.NoError(t, err)
+	t.Cleanup(ctx.Cancel)
+	return ctx
+}
+
+func reply(name string, calls *[]string) core.Engine {
+	return core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+		*calls = append(*calls, name)
+		msg.Output = name
+		ctx.SendOutput(msg)
+	})
+}
+
+func TestRouterEngine(t *testing.T) {
+	t.Parallel()
+
+	t.Run("routes by predicates and keeps the engine in the session", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		t.Cleanup(chatCtx.Shutdown)
+
+		var calls []string
+		engine := router_engine.NewRouterEngine(router_engine.WithDefaultRoute("faq"))
+		engine.RegisterRoute(
+			router_engine.Route{Name: "faq", Engine: reply("faq", &calls)},
+			router_engine.Route{
+				Name:       "orders",
+				Engine:     reply("orders", &calls),
+				Predicates: []router_engine.Predicate{router_engine.ByConnector(message.Telegram)},
+			},
+		)
+
+		msg := message.Message{User: message.User{ID: "nami"}, Connector: message.Telegram}
+		ctx := newTestContext(t, chatCtx, msg)
+		engine.HandleMessage(ctx, &msg)
+
+		assert.Equal(t, "orders", ctx.Session().Engine)
+
+		other := message.Message{User: message.User{ID: "zoro"}, Connector: message.Cli}
+		otherCtx := newTestContext(t, chatCtx, other)
+		engine.HandleMessage(otherCtx, &other)
+
+		assert.Equal(t, "faq", otherCtx.Session().Engine)
+		assert.Equal(t, []string{"orders", "faq"}, calls)
+	})
+
+	t.Run("transfer runs the target engine when nothing was replied", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		t.Cleanup(chatCtx.Shutdown)
+
+		var calls []string
+		engine := router_engine.NewRouterEngine()
+		engine.RegisterRoute(
+			router_engine.Route{Name: "faq", Engine: core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				calls = append(calls, "faq")
+				ctx.SetSessionState(core.WaitingInputState{})
+				ctx.TransferTo("orders")
+			})},
+			router_engine.Route{Name: "orders", Engine: reply("orders", &calls)},
+		)
+
+		msg := message.Message{User: message.User{ID: "usopp"}}
+		ctx := newTestContext(t, chatCtx, msg)
+		engine.HandleMessage(ctx, &msg)
+
+		assert.Equal(t, []string{"faq", "orders"}, calls)
+		assert.Equal(t, "orders", ctx.Session().Engine)
+		assert.Equal(t, "orders", msg.Output)
+	})
+
+	t.Run("transfer after a reply waits for the next message", func(t *testing.T) {
+		t.Parallel()
+
+		chatCtx := core.NewChatContext(make(chan core.Event, 1))
+		t.Cleanup(chatCtx.Shutdown)
+
+		var calls []string
+		engine := router_engine.NewRouterEngine()
+		engine.RegisterRoute(
+			router_engine.Route{Name: "faq", Engine: core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				calls = append(calls, "faq")
+				ctx.SendOutput(msg)
+				ctx.TransferTo("orders")
+			})},
+			router_engine.Route{Name: "orders", Engine: reply("orders", &calls)},
+		)
+
+		msg := message.Message{User: message.User{ID: "sanji"}}
+		ctx := newTestContext(t, chatCtx, msg)
+		engine.HandleMessage(ctx, &msg)
+
+		assert.Equal(t, []string{"faq"}, calls)
+		assert.Equal(t, "orders", ctx.Session().Engine)
+		assert.Equal(t, core.IdleState{}, ctx.Session().State)
+
+		next := message.Message{User: message.User{ID: "sanji"}}
+		engine.HandleMessage(newTestContext(t, chatCtx, next), &next)
+
+		assert.Equal(t, []string{"faq", "orders"}, calls)
+	})
+
+	t.Run("transfer to an unknown engine reports an error", func(t *testing.T) {
+		t.Parallel()
+
+		events := make(chan core.Event, 1)
+		chatCtx := core.NewChatContext(events)
+		t.Cleanup(chatCtx.Shutdown)
+
+		engine := router_engine.NewRouterEngine()
+		engine.RegisterRoute(router_engine.Route{Name: "faq", Engine: core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.TransferTo("nowhere")
+		})})
+
+		msg := message.Message{User: message.User{ID: "robin"}}
+		ctx := newTestContext(t, chatCtx, msg)
+		engine.HandleMessage(ctx, &msg)
+
+		event := <-events
+		assert.Equal(t, core.EventError, event.Type)
+		assert.ErrorIs(t, event.Error, router_engine.ErrNoRoute)
+		assert.Empty(t, ctx.Session().Engine)
+	})
+
+	t.Run("no routes reports an error", func(t *testing.T) {
+		t.Parallel()
+
+		events := make(chan core.Event, 1)
+		chatCtx := core.NewChatContext(events)
+		t.Cleanup(chatCtx.Shutdown)
+
+		msg := message.Message{User: message.User{ID: "chopper"}}
+		router_engine.NewRouterEngine().HandleMessage(newTestContext(t, chatCtx, msg), &msg)
+
+		event := <-events
+		assert.ErrorIs(t, event.Error, router_engine.ErrNoRoute)
+	})
+}
@@ -2,18 +2,27 @@
 package rule_engine
 
 import (
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/guiflemes/ohmychat/core"
 	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
 	"github.com/guiflemes/ohmychat/utils"
 )
 
 type Rule struct {
+	// ID identifies the rule when managing it at runtime and labels its
+	// metrics. Rules registered without one are given their first prompt,
+	// see GeneratedIDPrefix.
+	ID        string
 	Prompts   []string
 	Action    core.ActionFunc
 	NextState core.SessionState
+	// Disabled rules are kept but never matched.
+	Disabled bool
 }
 
 type MatcherFunc func(rules []Rule, input string) (Rule, bool)
@@ -31,10 +40,24 @@ func WithSessionExpiresAt(s time.Duration) RuleEngineOption {
 	}
 }
 
+// WithMetrics counts how many times each rule matched, labelled by its ID.
+func WithMetrics(reg metrics.Registry) RuleEngineOption {
+	return func(engine *RuleEngine) {
+		engine.hits = reg.Counter("ohmychat_rule_hits_total", "Messages matching a rule, labelled by its ID.", "rule")
+	}
+}
+
+// RuleEngine answers idle sessions with the first rule matching the input.
+// Its rules can be changed while it handles messages: every message is
+// matched against the rule set as it was when the message came in.
 type RuleEngine struct {
 	matcher          MatcherFunc
-	rules            []Rule
 	sessionExpiresAt *time.Duration
+	hits             metrics.Counter
+
+	mu     sync.RWMutex
+	rules  []Rule
+	active []Rule
 }
 
 func NewRuleEngine(opts ...RuleEngineOption) *RuleEngine {
@@ -52,11 +75,11 @@ func NewRuleEngine(opts ...RuleEngineOption) *RuleEngine {
 		engine.sessionExpiresAt = utils.PtrOf(core.SessionExpiresAt)
 	}
 
-	return engine
-}
+	if engine.hits == nil {
+		engine.hits = metrics.Nop{}.Counter("", "")
+	}
 
-func (e *RuleEngine) RegisterRule(rule ...Rule) {
-	e.rules = append(e.rules, rule...)
+	return engine
 }
 
 func (e *RuleEngine) HandleMessage(ctx *core.Context, msg *message.Message) {
@@ -79,13 +102,16 @@ func (e *RuleEngine) HandleMessage(ctx *core.Context, msg *message.Message) {
 }
 
 func (e *RuleEngine) handleIdleState(ctx *core.Context, msg *message.Message) {
-	rule, ok := e.matcher(e.rules, msg.Input)
+	rule, ok := e.matcher(e.activeRules(), msg.Input)
 	if !ok {
+		ctx.Logger().Debug("no rule matched")
 		msg.Output = "desculpe não entendi"
 		ctx.SendOutput(msg)
 		return
 	}
 
+	e.hits.Add(1, rule.ID)
+	ctx.Logger().Debug("rule matched", slog.String("rule", rule.ID))
 	ctx.SetSessionState(rule.NextState)
 	rule.Action(ctx, msg)
 
@@ -120,6 +146,7 @@ func (e *RuleEngine) handleWaitingChoiceState(ctx *core.Context, msg *message.Me
 }
 
 func (e *RuleEngine) handleUnknownState(ctx *core.Context, msg *message.Message) {
+	ctx.Logger().Error("unknown session state")
 	msg.Output = "Erro interno: estado desconhecido."
 	ctx.SendOutput(msg)
 }
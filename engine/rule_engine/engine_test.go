@@ -1,6 +1,7 @@
 package rule_engine_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/guiflemes/ohmychat/core/mocks"
 	"github.com/guiflemes/ohmychat/engine/rule_engine"
 	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -76,6 +78,41 @@ func TestRuleEngine(t *testing.T) {
 		assert.True(t, called)
 	})
 
+	t.Run("counts rule hits", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		ss := &core.Session{State: core.IdleState{}, LastActivityAt: time.Now()}
+		mockAdpater := mocks.NewMockSessionAdapter(ctrl)
+		mockAdpater.EXPECT().GetOrCreate(gomock.Any(), gomock.Any()).Return(ss, nil).Times(2)
+
+		chatCtx := core.NewChatContext(
+			make(chan<- core.Event),
+			core.WithSessionAdapter(mockAdpater),
+		)
+
+		reg := metrics.NewPrometheusRegistry()
+		engine := rule_engine.NewRuleEngine(rule_engine.WithMetrics(reg))
+		engine.RegisterRule(rule_engine.Rule{
+			Prompts:   []string{"hello", "hi"},
+			NextState: core.IdleState{},
+			Action:    func(*core.Context, *message.Message) {},
+		})
+
+		for _, input := range []string{"hello", "hi there"} {
+			msg := &message.Message{Input: input}
+			childCtx, _ := chatCtx.NewChildContext(*msg, make(chan message.Message, 1))
+			engine.HandleMessage(childCtx, msg)
+		}
+
+		var sb strings.Builder
+		_, err := reg.WriteTo(&sb)
+		assert.NoError(t, err)
+		assert.Contains(t, sb.String(), `ohmychat_rule_hits_total{rule="auto:hello"} 2`)
+	})
+
 	t.Run("handle waiting input with empty input", func(t *testing.T) {
 		t.Parallel()
 
@@ -0,0 +1,149 @@
+package rule_engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrInvalidRuleSet = errors.New("invalid rule set")
+
+// RuleSpec is a rule as written in a rule set file. Actions and states are
+// code, so the file refers to them by the name they were registered under in
+// the Loader. A rule either runs an action or sends a fixed reply.
+type RuleSpec struct {
+	ID      string   `json:"id"`
+	Prompts []string `json:"prompts"`
+	Action  string   `json:"action,omitempty"`
+	Reply   string   `json:"reply,omitempty"`
+	// NextState names the state the rule leaves the session in, idle when
+	// empty.
+	NextState string `json:"next_state,omitempty"`
+	Disabled  bool   `json:"disabled,omitempty"`
+}
+
+// IdleStateName refers to core.IdleState in rule set files.
+const IdleStateName = core.IdleStateName
+
+type LoaderOption func(l *Loader)
+
+// WithStateRegistry names the states of the loader in states, the registry
+// given to the session adapter persisting the sessions, so rule set files
+// and stored sessions know the states by the same names. A registry of its
+// own is used by default.
+func WithStateRegistry(states *core.StateRegistry) LoaderOption {
+	return func(l *Loader) {
+		l.states = states
+	}
+}
+
+// Loader turns rule set files into rules.
+type Loader struct {
+	actions map[string]core.ActionFunc
+	states  *core.StateRegistry
+}
+
+func NewLoader(opts ...LoaderOption) *Loader {
+	l := &Loader{
+		actions: make(map[string]core.ActionFunc),
+		states:  core.NewStateRegistry(),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// RegisterAction makes action available to rule set files under name.
+func (l *Loader) RegisterAction(name string, action core.ActionFunc) {
+	l.actions[name] = action
+}
+
+// RegisterState makes state available to rule set files under name and
+// returns it named, see core.StateRegistry.Register. The states registered
+// by type are available as well.
+func (l *Loader) RegisterState(name string, state core.SessionState) core.SessionState {
+	return l.states.Register(name, state)
+}
+
+// States returns the registry naming the states of the loader.
+func (l *Loader) States() *core.StateRegistry {
+	return l.states
+}
+
+// Load reads a JSON array of RuleSpec from r.
+func (l *Loader) Load(r io.Reader) ([]Rule, error) {
+	var specs []RuleSpec
+	if err := json.NewDecoder(r).Decode(&specs); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRuleSet, err)
+	}
+
+	rules := make([]Rule, 0, len(specs))
+	for i, spec := range specs {
+		rule, err := l.rule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rule %d %q: %w", ErrInvalidRuleSet, i+1, spec.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := validateRules(rules); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRuleSet, err)
+	}
+	return rules, nil
+}
+
+// LoadFile reads the rule set file at path.
+func (l *Loader) LoadFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.Load(f)
+}
+
+func (l *Loader) rule(spec RuleSpec) (Rule, error) {
+	rule := Rule{ID: spec.ID, Prompts: spec.Prompts, Disabled: spec.Disabled}
+
+	if len(spec.Prompts) == 0 {
+		return Rule{}, errors.New("no prompts")
+	}
+
+	switch {
+	case spec.Action != "" && spec.Reply != "":
+		return Rule{}, errors.New("both action and reply set")
+	case spec.Action != "":
+		action, ok := l.actions[spec.Action]
+		if !ok {
+			return Rule{}, fmt.Errorf("unknown action %q", spec.Action)
+		}
+		rule.Action = action
+	case spec.Reply != "":
+		reply := spec.Reply
+		rule.Action = func(ctx *core.Context, msg *message.Message) {
+			msg.Output = reply
+			ctx.SendOutput(msg)
+		}
+	default:
+		return Rule{}, errors.New("neither action nor reply set")
+	}
+
+	stateName := spec.NextState
+	if stateName == "" {
+		stateName = IdleStateName
+	}
+	state, err := l.states.State(stateName)
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.NextState = state
+	return rule, nil
+}
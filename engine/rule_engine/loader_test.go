@@ -0,0 +1,100 @@
+package rule_engine_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func orderLoader() *rule_engine.Loader {
+	loader := rule_engine.NewLoader()
+	loader.RegisterAction("ask_order", func(ctx *core.Context, msg *message.Message) {
+		msg.Output = "Qual o número do pedido?"
+		ctx.SendOutput(msg)
+	})
+	loader.RegisterState("waiting_order", core.WaitingInputState{
+		Action: func(ctx *core.Context, msg *message.Message) {
+			msg.Output = "pedido " + msg.Input + " encontrado"
+			ctx.SetSessionState(core.IdleState{})
+			ctx.SendOutput(msg)
+		},
+	})
+	return loader
+}
+
+func TestLoader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("builds rules from named actions and states", func(t *testing.T) {
+		t.Parallel()
+
+		rules, err := orderLoader().Load(strings.NewReader(`[
+			{"id": "order", "prompts": ["pedido"], "action": "ask_order", "next_state": "waiting_order"},
+			{"id": "greet", "prompts": ["oi", "olá"], "reply": "Olá!"},
+			{"id": "promo", "prompts": ["promo"], "reply": "acabou", "disabled": true}
+		]`))
+		assert.NoError(t, err)
+		if !assert.Len(t, rules, 3) {
+			return
+		}
+		assert.IsType(t, core.WaitingInputState{}, rules[0].NextState)
+		assert.IsType(t, core.IdleState{}, rules[1].NextState)
+		assert.True(t, rules[2].Disabled)
+
+		engine := rule_engine.NewRuleEngine()
+		assert.NoError(t, engine.SetRules(rules))
+		bot := ohmychattest.New(t, engine)
+		bot.Script("usopp",
+			ohmychattest.Step{Say: "olá", Want: []string{"Olá!"}},
+			ohmychattest.Step{Say: "pedido", Want: []string{"Qual o número do pedido?"}, WantState: core.WaitingInputState{}},
+			ohmychattest.Step{Say: "42", Want: []string{"pedido 42 encontrado"}, WantState: core.IdleState{}},
+			ohmychattest.Step{Say: "promo", Want: []string{"desculpe não entendi"}},
+		)
+	})
+
+	t.Run("names its states in the given registry", func(t *testing.T) {
+		t.Parallel()
+
+		states := core.NewStateRegistry()
+		loader := rule_engine.NewLoader(rule_engine.WithStateRegistry(states))
+		waiting := loader.RegisterState("waiting_name", core.WaitingInputState{})
+		assert.Same(t, states, loader.States())
+
+		rules, err := loader.Load(strings.NewReader(`[
+			{"id": "name", "prompts": ["nome"], "reply": "Qual o seu nome?", "next_state": "waiting_name"}
+		]`))
+		assert.NoError(t, err)
+		if !assert.Len(t, rules, 1) {
+			return
+		}
+		assert.Equal(t, waiting, rules[0].NextState)
+
+		name, err := states.Name(rules[0].NextState)
+		assert.NoError(t, err)
+		assert.Equal(t, "waiting_name", name)
+	})
+
+	t.Run("rejects invalid rule sets", func(t *testing.T) {
+		t.Parallel()
+
+		for name, src := range map[string]string{
+			"malformed":      `[{"id": "a"`,
+			"no prompts":     `[{"id": "a", "reply": "x"}]`,
+			"no id":          `[{"prompts": ["a"], "reply": "x"}]`,
+			"duplicated id":  `[{"id": "a", "prompts": ["a"], "reply": "x"}, {"id": "a", "prompts": ["b"], "reply": "y"}]`,
+			"unknown action": `[{"id": "a", "prompts": ["a"], "action": "nope"}]`,
+			"unknown state":  `[{"id": "a", "prompts": ["a"], "reply": "x", "next_state": "nope"}]`,
+			"both":           `[{"id": "a", "prompts": ["a"], "reply": "x", "action": "ask_order"}]`,
+			"neither":        `[{"id": "a", "prompts": ["a"]}]`,
+		} {
+			_, err := orderLoader().Load(strings.NewReader(src))
+			assert.ErrorIs(t, err, rule_engine.ErrInvalidRuleSet, name)
+		}
+	})
+}
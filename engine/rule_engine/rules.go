@@ -0,0 +1,190 @@
+package rule_engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRuleNotFound  = errors.New("rule not found")
+	ErrDuplicateRule = errors.New("rule ID already registered")
+	ErrRuleID        = errors.New("rule ID must not be empty")
+	ErrReservedID    = errors.New("rule ID prefix is reserved for generated IDs")
+)
+
+// GeneratedIDPrefix starts the IDs RegisterRule gives to the rules registered
+// without one. RegisterRule and AddRule refuse other IDs starting with it, so
+// a generated ID never collides with a chosen one.
+const GeneratedIDPrefix = "auto:"
+
+// RegisterRule appends rules to the rule set. Rules without an ID are given
+// their first prompt after GeneratedIDPrefix, suffixed with a counter if
+// another rule has it already. It panics when an ID is already registered or
+// reserved, as that is a wiring error; use AddRule for rules known only at
+// runtime.
+func (e *RuleEngine) RegisterRule(rule ...Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := slices.Clone(e.rules)
+	for _, r := range rule {
+		switch {
+		case r.ID == "":
+			r.ID = freeID(rules, GeneratedIDPrefix+firstPrompt(r))
+		case strings.HasPrefix(r.ID, GeneratedIDPrefix):
+			panic(fmt.Errorf("%w: %q", ErrReservedID, r.ID))
+		case indexOf(rules, r.ID) >= 0:
+			panic(fmt.Errorf("%w: %q", ErrDuplicateRule, r.ID))
+		}
+		rules = append(rules, r)
+	}
+	e.setRules(rules)
+}
+
+// AddRule appends rule to the rule set.
+func (e *RuleEngine) AddRule(rule Rule) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rule.ID == "" {
+		return ErrRuleID
+	}
+	if strings.HasPrefix(rule.ID, GeneratedIDPrefix) {
+		return fmt.Errorf("%w: %q", ErrReservedID, rule.ID)
+	}
+	if indexOf(e.rules, rule.ID) >= 0 {
+		return fmt.Errorf("%w: %q", ErrDuplicateRule, rule.ID)
+	}
+	e.setRules(append(slices.Clone(e.rules), rule))
+	return nil
+}
+
+// ReplaceRule replaces the rule with the same ID, keeping its position.
+func (e *RuleEngine) ReplaceRule(rule Rule) error {
+	return e.update(rule.ID, func(r *Rule) { *r = rule })
+}
+
+func (e *RuleEngine) EnableRule(id string) error {
+	return e.update(id, func(r *Rule) { r.Disabled = false })
+}
+
+// DisableRule stops the rule from matching without removing it.
+func (e *RuleEngine) DisableRule(id string) error {
+	return e.update(id, func(r *Rule) { r.Disabled = true })
+}
+
+func (e *RuleEngine) RemoveRule(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := indexOf(e.rules, id)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	e.setRules(slices.Delete(slices.Clone(e.rules), i, i+1))
+	return nil
+}
+
+// SetRules swaps the whole rule set at once. Sessions waiting for an input
+// or a choice keep the state the previous rules left them in. The rules may
+// keep the IDs generated by RegisterRule, e.g. when set back from Rules.
+func (e *RuleEngine) SetRules(rules []Rule) error {
+	if err := validateRules(rules); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.setRules(slices.Clone(rules))
+	return nil
+}
+
+// Rules returns the rule set, in matching order.
+func (e *RuleEngine) Rules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.Clone(e.rules)
+}
+
+// Rule returns the rule with the given ID.
+func (e *RuleEngine) Rule(id string) (Rule, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	i := indexOf(e.rules, id)
+	if i < 0 {
+		return Rule{}, false
+	}
+	return e.rules[i], true
+}
+
+func (e *RuleEngine) update(id string, fn func(r *Rule)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := indexOf(e.rules, id)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	rules := slices.Clone(e.rules)
+	fn(&rules[i])
+	rules[i].ID = id
+	e.setRules(rules)
+	return nil
+}
+
+// setRules installs rules, which must not be modified afterwards, as they
+// may be read by messages being handled.
+func (e *RuleEngine) setRules(rules []Rule) {
+	active := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		if !r.Disabled {
+			active = append(active, r)
+		}
+	}
+	e.rules = rules
+	e.active = active
+}
+
+func (e *RuleEngine) activeRules() []Rule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.active
+}
+
+func validateRules(rules []Rule) error {
+	seen := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if r.ID == "" {
+			return ErrRuleID
+		}
+		if seen[r.ID] {
+			return fmt.Errorf("%w: %q", ErrDuplicateRule, r.ID)
+		}
+		seen[r.ID] = true
+	}
+	return nil
+}
+
+func indexOf(rules []Rule, id string) int {
+	return slices.IndexFunc(rules, func(r Rule) bool { return r.ID == id })
+}
+
+func firstPrompt(rule Rule) string {
+	if len(rule.Prompts) == 0 {
+		return "rule"
+	}
+	return rule.Prompts[0]
+}
+
+func freeID(rules []Rule, id string) string {
+	candidate := id
+	for n := 2; indexOf(rules, candidate) >= 0; n++ {
+		candidate = id + "#" + strconv.Itoa(n)
+	}
+	return candidate
+}
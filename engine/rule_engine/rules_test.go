@@ -0,0 +1,175 @@
+package rule_engine_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func replyRule(id, prompt, reply string) rule_engine.Rule {
+	return rule_engine.Rule{
+		ID:      id,
+		Prompts: []string{prompt},
+		Action: func(ctx *core.Context, msg *message.Message) {
+			msg.Output = reply
+			ctx.SendOutput(msg)
+		},
+		NextState: core.IdleState{},
+	}
+}
+
+func TestRuleEngine_Rules(t *testing.T) {
+	t.Parallel()
+
+	t.Run("gives rules without ID their first prompt", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(replyRule("", "oi", "olá"), replyRule("", "oi", "de novo"), replyRule("", "tchau", "até"))
+
+		var ids []string
+		for _, r := range engine.Rules() {
+			ids = append(ids, r.ID)
+		}
+		assert.Equal(t, []string{"auto:oi", "auto:oi#2", "auto:tchau"}, ids)
+	})
+
+	t.Run("generated IDs never collide with chosen ones", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(replyRule("", "oi", "olá"))
+		assert.NotPanics(t, func() {
+			engine.RegisterRule(replyRule("oi", "oi", "olá"))
+		})
+		assert.PanicsWithError(t, `rule ID prefix is reserved for generated IDs: "auto:tchau"`, func() {
+			engine.RegisterRule(replyRule("auto:tchau", "tchau", "até"))
+		})
+		assert.ErrorIs(t, engine.AddRule(replyRule("auto:oi", "oi", "olá")), rule_engine.ErrReservedID)
+
+		// the generated IDs can be set back
+		assert.NoError(t, engine.SetRules(engine.Rules()))
+		_, ok := engine.Rule("auto:oi")
+		assert.True(t, ok)
+	})
+
+	t.Run("adds, replaces and removes rules by ID", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		bot := ohmychattest.New(t, engine)
+
+		assert.NoError(t, engine.AddRule(replyRule("greet", "oi", "olá")))
+		assert.ErrorIs(t, engine.AddRule(replyRule("greet", "oi", "olá")), rule_engine.ErrDuplicateRule)
+		assert.ErrorIs(t, engine.AddRule(replyRule("", "oi", "olá")), rule_engine.ErrRuleID)
+		assert.Equal(t, []string{"olá"}, bot.Say("luffy", "oi").Texts())
+
+		assert.NoError(t, engine.ReplaceRule(replyRule("greet", "oi", "e aí")))
+		assert.Equal(t, []string{"e aí"}, bot.Say("luffy", "oi").Texts())
+
+		rule, ok := engine.Rule("greet")
+		assert.True(t, ok)
+		assert.Equal(t, []string{"oi"}, rule.Prompts)
+
+		assert.NoError(t, engine.RemoveRule("greet"))
+		assert.ErrorIs(t, engine.RemoveRule("greet"), rule_engine.ErrRuleNotFound)
+		assert.ErrorIs(t, engine.ReplaceRule(replyRule("greet", "oi", "olá")), rule_engine.ErrRuleNotFound)
+		assert.Equal(t, []string{"desculpe não entendi"}, bot.Say("luffy", "oi").Texts())
+	})
+
+	t.Run("panics on a duplicate ID", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(replyRule("greet", "oi", "olá"))
+		assert.PanicsWithError(t, `rule ID already registered: "greet"`, func() {
+			engine.RegisterRule(replyRule("greet", "olá", "oi"))
+		})
+		assert.PanicsWithError(t, `rule ID already registered: "bye"`, func() {
+			engine.RegisterRule(replyRule("bye", "tchau", "até"), replyRule("bye", "falou", "até"))
+		})
+
+		_, ok := engine.Rule("bye")
+		assert.False(t, ok)
+	})
+
+	t.Run("skips disabled rules", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(replyRule("promo", "oi", "promoção!"), replyRule("greet", "oi", "olá"))
+		bot := ohmychattest.New(t, engine)
+
+		assert.NoError(t, engine.DisableRule("promo"))
+		assert.Equal(t, []string{"olá"}, bot.Say("zoro", "oi").Texts())
+		r, _ := engine.Rule("promo")
+		assert.True(t, r.Disabled)
+
+		assert.NoError(t, engine.EnableRule("promo"))
+		assert.Equal(t, []string{"promoção!"}, bot.Say("zoro", "oi").Texts())
+		assert.ErrorIs(t, engine.DisableRule("none"), rule_engine.ErrRuleNotFound)
+	})
+
+	t.Run("swaps the rule set without dropping sessions", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(rule_engine.Rule{
+			ID:      "order",
+			Prompts: []string{"pedido"},
+			Action: func(ctx *core.Context, msg *message.Message) {
+				msg.Output = "Qual sabor?"
+				ctx.SendOutput(msg)
+			},
+			NextState: core.WaitingChoiceState{Choices: core.Choices{
+				"calabresa": func(ctx *core.Context, msg *message.Message) {
+					msg.Output = "anotado"
+					ctx.SendOutput(msg)
+				},
+			}},
+		})
+		bot := ohmychattest.New(t, engine)
+		bot.Say("nami", "pedido")
+
+		assert.NoError(t, engine.SetRules([]rule_engine.Rule{replyRule("greet", "oi", "olá")}))
+		assert.Equal(t, []string{"anotado"}, bot.Say("nami", "calabresa").Texts())
+		assert.Equal(t, []string{"desculpe não entendi"}, bot.Say("nami", "pedido").Texts())
+
+		err := engine.SetRules([]rule_engine.Rule{replyRule("a", "x", ""), replyRule("a", "y", "")})
+		assert.ErrorIs(t, err, rule_engine.ErrDuplicateRule)
+		assert.Len(t, engine.Rules(), 1)
+	})
+
+	t.Run("changes rules while handling messages", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(replyRule("greet", "oi", "olá"))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			bot := ohmychattest.New(t, engine)
+			for range 100 {
+				bot.Say("sanji", "oi")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_ = engine.DisableRule("greet")
+				_ = engine.ReplaceRule(replyRule("greet", "oi", "e aí"))
+				_ = engine.AddRule(replyRule("bye", "tchau", "até"))
+				_ = engine.RemoveRule("bye")
+			}
+		}()
+		wg.Wait()
+	})
+}
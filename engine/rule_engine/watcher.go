@@ -0,0 +1,109 @@
+package rule_engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+type WatcherOption func(w *Watcher)
+
+// WithWatchInterval sets how often the file is looked at, every 5 seconds by
+// default.
+func WithWatchInterval(d time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.interval = d
+	}
+}
+
+// WithReloadErrorHandler is called when the file cannot be read or holds an
+// invalid rule set. The engine keeps its rules until the file is fixed.
+func WithReloadErrorHandler(fn func(err error)) WatcherOption {
+	return func(w *Watcher) {
+		w.onError = fn
+	}
+}
+
+// WithReloadHook is called with the rules swapped in after every reload.
+func WithReloadHook(fn func(rules []Rule)) WatcherOption {
+	return func(w *Watcher) {
+		w.onReload = fn
+	}
+}
+
+// Watcher keeps the rules of an engine in sync with a rule set file, swapping
+// the whole set in at once whenever the file content changes.
+type Watcher struct {
+	engine   *RuleEngine
+	loader   *Loader
+	path     string
+	interval time.Duration
+	onError  func(err error)
+	onReload func(rules []Rule)
+
+	// sum is the checksum of the content last looked at, loaded or not, so
+	// an invalid file is reported once.
+	sum []byte
+}
+
+func NewWatcher(engine *RuleEngine, loader *Loader, path string, opts ...WatcherOption) *Watcher {
+	w := &Watcher{
+		engine:   engine,
+		loader:   loader,
+		path:     path,
+		interval: 5 * time.Second,
+		onError:  func(error) {},
+		onReload: func([]Rule) {},
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Reload loads the file into the engine if its content changed since the last
+// call. It is not safe to call concurrently with Run.
+func (w *Watcher) Reload() error {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if bytes.Equal(sum[:], w.sum) {
+		return nil
+	}
+	w.sum = sum[:]
+
+	rules, err := w.loader.Load(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := w.engine.SetRules(rules); err != nil {
+		return err
+	}
+	w.onReload(rules)
+	return nil
+}
+
+// Run reloads the file every interval until ctx is done. The first load
+// happens straight away.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Reload(); err != nil {
+			w.onError(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package rule_engine_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+
+	t.Run("swaps in the rule set whenever the file changes", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "rules.json")
+		write := func(src string) {
+			tmp := path + ".tmp"
+			assert.NoError(t, os.WriteFile(tmp, []byte(src), 0o600))
+			assert.NoError(t, os.Rename(tmp, path))
+		}
+		write(`[{"id": "greet", "prompts": ["oi"], "reply": "olá"}]`)
+
+		var (
+			mu      sync.Mutex
+			errs    []error
+			reloads int
+		)
+		engine := rule_engine.NewRuleEngine()
+		watcher := rule_engine.NewWatcher(engine, orderLoader(), path,
+			rule_engine.WithWatchInterval(5*time.Millisecond),
+			rule_engine.WithReloadErrorHandler(func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			}),
+			rule_engine.WithReloadHook(func([]rule_engine.Rule) {
+				mu.Lock()
+				defer mu.Unlock()
+				reloads++
+			}),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watcher.Run(ctx)
+		}()
+
+		bot := ohmychattest.New(t, engine)
+		assert.Eventually(t, func() bool {
+			return len(engine.Rules()) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"olá"}, bot.Say("franky", "oi").Texts())
+
+		write(`[{"id": "greet", "prompts": ["oi"], "reply": "SUPER!"}]`)
+		assert.Eventually(t, func() bool {
+			texts := bot.Say("franky", "oi").Texts()
+			return len(texts) == 1 && texts[0] == "SUPER!"
+		}, time.Second, 5*time.Millisecond)
+
+		write(`[{"id": "greet"`)
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(errs) == 1
+		}, time.Second, 5*time.Millisecond)
+		assert.Equal(t, []string{"SUPER!"}, bot.Say("franky", "oi").Texts())
+
+		cancel()
+		<-done
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.ErrorIs(t, errs[0], rule_engine.ErrInvalidRuleSet)
+		assert.Equal(t, 2, reloads)
+	})
+
+	t.Run("reports a missing file", func(t *testing.T) {
+		t.Parallel()
+
+		watcher := rule_engine.NewWatcher(rule_engine.NewRuleEngine(), rule_engine.NewLoader(), filepath.Join(t.TempDir(), "none.json"))
+		assert.ErrorIs(t, watcher.Reload(), os.ErrNotExist)
+	})
+}
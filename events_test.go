@@ -0,0 +1,143 @@
+package ohmychat_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOhMyChat_Events(t *testing.T) {
+	t.Parallel()
+
+	t.Run("publishes the lifecycle events", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu        sync.Mutex
+			lifecycle []core.EventType
+			changes   []core.StateChanged
+			created   []core.Session
+			callback  []core.EventType
+		)
+		bus := core.NewEventBus()
+		core.On(bus, func(_ core.Event, p core.SessionCreated) {
+			mu.Lock()
+			defer mu.Unlock()
+			created = append(created, p.Session)
+		})
+		core.On(bus, func(_ core.Event, p core.StateChanged) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, p)
+		})
+
+		conn := ohmychattest.NewConnector(message.Test)
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				ctx.SetSessionState(core.WaitingInputState{})
+				msg.Output = "Qual o seu nome?"
+				ctx.SendOutput(msg)
+			})),
+			ohmychat.WithEventBus(bus),
+			ohmychat.WithEventSubscriber(func(e core.Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				lifecycle = append(lifecycle, e.Type)
+			}, core.SubscribeWithTypes(
+				core.EventConnectorStarted, core.EventMessageReceived, core.EventSessionCreated, core.EventHandlerStarted,
+				core.EventStateChanged, core.EventHandlerFinished, core.EventReplyDispatched, core.EventConnectorStopped,
+			)),
+			ohmychat.WithEventCallback(func(e core.Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				callback = append(callback, e.Type)
+			}),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		waitCtx, stop := context.WithTimeout(context.Background(), time.Second)
+		defer stop()
+
+		assert.NoError(t, conn.Say(waitCtx, "chopper", "oi"))
+		_, err := conn.Next(waitCtx)
+		assert.NoError(t, err)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.ElementsMatch(t, []core.EventType{
+			core.EventConnectorStarted, core.EventMessageReceived, core.EventSessionCreated, core.EventHandlerStarted,
+			core.EventStateChanged, core.EventHandlerFinished, core.EventReplyDispatched, core.EventConnectorStopped,
+		}, lifecycle)
+		assert.Equal(t, []core.EventType{core.EventSuccess}, callback)
+		if assert.Len(t, changes, 1) {
+			assert.Equal(t, "chopper", changes[0].UserID)
+			assert.IsType(t, core.IdleState{}, changes[0].Old)
+			assert.IsType(t, core.WaitingInputState{}, changes[0].New)
+		}
+		if assert.Len(t, created, 1) {
+			assert.Equal(t, "chopper", created[0].UserID)
+			assert.Equal(t, message.Test, created[0].Connector)
+			assert.Equal(t, "chopper", created[0].ChannelID)
+		}
+	})
+
+	t.Run("flushes the queued events without stalling the replies", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			mu       sync.Mutex
+			received int
+		)
+		conn := ohmychattest.NewConnector(message.Test)
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+				msg.Output = "olá"
+				ctx.SendOutput(msg)
+			})),
+			ohmychat.WithEventSubscriber(func(core.Event) {
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				received++
+			}, core.SubscribeWithTypes(core.EventMessageReceived)),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		waitCtx, stop := context.WithTimeout(context.Background(), time.Second)
+		defer stop()
+
+		start := time.Now()
+		for range 5 {
+			assert.NoError(t, conn.Say(waitCtx, "brook", "oi"))
+			_, err := conn.Next(waitCtx)
+			assert.NoError(t, err)
+		}
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 5, received)
+	})
+}
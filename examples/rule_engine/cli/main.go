@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/guiflemes/ohmychat"
 	"github.com/guiflemes/ohmychat/engine/rule_engine"
@@ -15,6 +20,10 @@ import (
 )
 
 func main() {
+	// the waiting states are named, so that a session adapter persisting the
+	// sessions can store them
+	states := core.NewStateRegistry()
+
 	engine := rule_engine.NewRuleEngine()
 	engine.RegisterRule(
 		rule_engine.Rule{
@@ -23,7 +32,7 @@ func main() {
 				msg.Output = "Qual o número do pedido?"
 				ctx.SendOutput(msg)
 			},
-			NextState: core.WaitingInputState{
+			NextState: states.Register("waiting_order", core.WaitingInputState{
 				PromptEmptyMessage: "Por favor, informe o número do pedido.",
 				PromptExit:         "solicitação de pedido cancelado",
 				ExitInput:          "sair",
@@ -38,7 +47,7 @@ func main() {
 						ctx.SendOutput(msg)
 					},
 				),
-			},
+			}),
 		},
 
 		rule_engine.Rule{
@@ -62,7 +71,7 @@ func main() {
 				}
 				ctx.SendOutput(msg)
 			},
-			NextState: core.WaitingChoiceState{
+			NextState: states.Register("choosing_dog", core.WaitingChoiceState{
 				Choices: core.Choices{
 					"beagle": func(ctx *core.Context, msg *message.Message) {
 						msg.Output = "legal, o cão mais fofo e gordo que existe"
@@ -76,10 +85,15 @@ func main() {
 					msg.Output = fmt.Sprintf("nossa seu cão %s é tao sem graça", msg.Input)
 					ctx.SendOutput(msg)
 				}, "pastor", "pitbull"),
-			},
+			}),
 		},
 	)
 
-	chatBot := ohmychat.NewOhMyChat(cli.NewCliConnector())
-	chatBot.Run(engine)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	chatBot := ohmychat.NewOhMyChat(cli.NewCliConnector(), ohmychat.WithEngine(engine))
+	if err := chatBot.Run(ctx); err != nil {
+		log.Fatalf("error running cli bot %s", err.Error())
+	}
 }
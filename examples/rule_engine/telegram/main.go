@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/guiflemes/ohmychat"
 	"github.com/guiflemes/ohmychat/engine/rule_engine"
@@ -18,6 +23,10 @@ import (
 )
 
 func main() {
+	// the waiting states are named, so that a session adapter persisting the
+	// sessions can store them
+	states := core.NewStateRegistry()
+
 	engine := rule_engine.NewRuleEngine()
 	engine.RegisterRule(
 		rule_engine.Rule{
@@ -26,7 +35,7 @@ func main() {
 				msg.Output = "Qual o número do pedido?"
 				ctx.SendOutput(msg)
 			},
-			NextState: core.WaitingInputState{
+			NextState: states.Register("waiting_order", core.WaitingInputState{
 				PromptEmptyMessage: "Por favor, informe o número do pedido.",
 				PromptExit:         "solicitação de pedido cancelado",
 				ExitInput:          "exit",
@@ -41,7 +50,7 @@ func main() {
 						ctx.SendOutput(msg)
 					},
 				),
-			},
+			}),
 		},
 
 		rule_engine.Rule{
@@ -61,7 +70,7 @@ func main() {
 				msg.Options = []message.Option{{ID: "beagle", Name: "beagle"}, {ID: "pinscher", Name: "pinscher"}}
 				ctx.SendOutput(msg)
 			},
-			NextState: core.WaitingChoiceState{
+			NextState: states.Register("choosing_dog", core.WaitingChoiceState{
 				Choices: core.Choices{
 					"beagle": func(ctx *core.Context, msg *message.Message) {
 						msg.Output = "legal, o cão mais fofo e gordo que existe"
@@ -72,7 +81,7 @@ func main() {
 						ctx.SendOutput(msg)
 					},
 				},
-			},
+			}),
 		},
 	)
 
@@ -80,21 +89,31 @@ func main() {
 	if err != nil {
 		log.Panicf("error starting telegram bot %s", err.Error())
 	}
-	chatBot := ohmychat.NewOhMyChat(telegram.NewTelegramConnector(tBot), ohmychat.WithEventCallback(logOnEvent))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	chatBot := ohmychat.NewOhMyChat(
+		telegram.NewTelegramConnector(tBot),
+		ohmychat.WithEngine(engine),
+		ohmychat.WithEventSubscriber(logOnEvent, core.SubscribeWithTypes(core.EventError, core.EventDispatchFailed, core.EventReplyDispatched)),
+		ohmychat.WithLogger(slog.New(slog.NewTextHandler(os.Stderr, nil))),
+	)
 	log.Println("running telegram bot...")
-	chatBot.Run(engine)
+	if err := chatBot.Run(ctx); err != nil {
+		log.Fatalf("error running telegram bot %s", err.Error())
+	}
 	log.Println("telegram bot finished")
 }
 
 func logOnEvent(event core.Event) {
 	switch event.Type {
-	case core.EventError:
+	case core.EventError, core.EventDispatchFailed:
 		if event.Msg != nil {
 			log.Printf("error on message '%s': %s", event.Msg.ID, event.Error.Error())
 			return
 		}
 		log.Printf("error: %s", event.Error.Error())
-	case core.EventSuccess:
-		log.Printf("success on message '%s'", event.Msg.ID)
+	case core.EventReplyDispatched:
+		log.Printf("reply on message '%s'", event.Msg.ID)
 	}
 }
@@ -0,0 +1,36 @@
+package ohmychat
+
+import (
+	"context"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/handoff"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// WithHandOff lets actions hand conversations off to human agents through
+// desk. Messages of handed off sessions skip the engine, after every other
+// middleware ran, and the desk's agent connectors are served alongside Run.
+func WithHandOff(desk *handoff.Desk) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if desk == nil {
+			b.invalid("handoff desk must not be nil")
+			return
+		}
+		b.config.desk = desk
+	}
+}
+
+// deskBot is the running pipeline as seen by a handoff desk.
+type deskBot struct {
+	b       *ohMyChat
+	chatCtx *core.ChatContext
+}
+
+func (d deskBot) Deliver(ctx context.Context, msg message.Message) error {
+	return d.b.push(ctx, msg, nil)
+}
+
+func (d deskBot) UpdateSession(ctx context.Context, key string, fn func(*core.Session)) error {
+	return d.chatCtx.UpdateSession(ctx, key, fn)
+}
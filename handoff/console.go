@@ -0,0 +1,157 @@
+package handoff
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const consoleHelp = `commands:
+  queue               list the conversations waiting for an agent
+  mine                list your conversations
+  claim [id]          take a conversation, the oldest one by default
+  reply <id> <text>   send text to the user of a conversation
+  say <text>          send text to the user of your current conversation
+  release [id]        give a conversation back to the bot
+  help                show this help`
+
+// Console is an agent connector reading one agent's commands from a line
+// based stream, such as the terminal or a network connection.
+type Console struct {
+	agent string
+	in    io.Reader
+
+	mu      sync.Mutex
+	out     io.Writer
+	current string
+}
+
+func NewConsole(agent string, in io.Reader, out io.Writer) *Console {
+	return &Console{agent: agent, in: in, out: out}
+}
+
+func (c *Console) Serve(ctx context.Context, desk *Desk) error {
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+
+	go func() {
+		scanner := bufio.NewScanner(c.in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	c.printf("agent console for %s, type help for the commands\n", c.agent)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-scanErr:
+			return err
+		case line := <-lines:
+			if err := c.exec(ctx, desk, line); err != nil {
+				c.printf("error: %v\n", err)
+			}
+		}
+	}
+}
+
+func (c *Console) exec(ctx context.Context, desk *Desk, line string) error {
+	cmd, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	args = strings.TrimSpace(args)
+
+	switch cmd {
+	case "":
+		return nil
+	case "queue":
+		c.list(desk.Queue())
+	case "mine":
+		c.list(desk.Assigned(c.agent))
+	case "claim":
+		ticket, err := desk.Claim(c.agent, args)
+		if err != nil {
+			return err
+		}
+		c.setCurrent(ticket.ID)
+		for _, msg := range ticket.History {
+			c.printf("[%s] user: %s\n", ticket.ID, msg.Input)
+		}
+	case "reply":
+		id, text, ok := strings.Cut(args, " ")
+		if !ok {
+			return fmt.Errorf("usage: reply <id> <text>")
+		}
+		return desk.Reply(ctx, c.agent, id, text)
+	case "say":
+		return desk.Reply(ctx, c.agent, c.target(""), args)
+	case "release":
+		id := c.target(args)
+		if err := desk.Release(ctx, c.agent, id); err != nil {
+			return err
+		}
+		c.setCurrent("")
+	case "help":
+		c.printf("%s\n", consoleHelp)
+	default:
+		return fmt.Errorf("unknown command %q, type help for the commands", cmd)
+	}
+	return nil
+}
+
+func (c *Console) Notify(n Notification) {
+	switch n.Kind {
+	case TicketQueued:
+		c.printf("[%s] waiting for an agent: %s\n", n.Ticket.ID, n.Ticket.Reason)
+	case TicketClaimed:
+		if n.Ticket.Agent != c.agent {
+			c.printf("[%s] claimed by %s\n", n.Ticket.ID, n.Ticket.Agent)
+		}
+	case UserMessage:
+		if n.Ticket.Agent == c.agent {
+			c.printf("[%s] user: %s\n", n.Ticket.ID, n.Message.Input)
+		}
+	case TicketReleased:
+		c.printf("[%s] released to the bot\n", n.Ticket.ID)
+	}
+}
+
+func (c *Console) list(tickets []Ticket) {
+	if len(tickets) == 0 {
+		c.printf("no conversations\n")
+		return
+	}
+	for _, t := range tickets {
+		c.printf("[%s] %s/%s since %s: %s\n", t.ID, t.Connector, t.ChannelID, t.QueuedAt.Format("15:04:05"), t.Reason)
+	}
+}
+
+func (c *Console) target(id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id != "" {
+		return id
+	}
+	return c.current
+}
+
+func (c *Console) setCurrent(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = id
+}
+
+func (c *Console) printf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.out, format, args...)
+}
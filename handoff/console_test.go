@@ -0,0 +1,75 @@
+package handoff_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/handoff"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestConsole(t *testing.T) {
+	t.Parallel()
+
+	t.Run("agent claims, replies and releases", func(t *testing.T) {
+		t.Parallel()
+
+		in, commands := io.Pipe()
+		out := &syncBuffer{}
+		console := handoff.NewConsole("nami", in, out)
+
+		bot := newFakeBot()
+		desk := handoff.NewDesk(handoff.WithAgentConnector(console))
+		defer desk.Attach(bot)()
+
+		msg := message.Message{User: message.User{ID: "luffy"}, Connector: message.Cli, Input: "I want meat"}
+		desk.HandOff(newTestContext(t, msg, nil), &msg, "hungry")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- desk.Run(ctx) }()
+
+		for _, cmd := range []string{"queue", "claim", "say coming right up", "release", "bogus"} {
+			_, err := io.WriteString(commands, cmd+"\n")
+			assert.NoError(t, err)
+		}
+		assert.Eventually(t, func() bool {
+			return strings.Contains(out.String(), `unknown command "bogus"`)
+		}, time.Second, 5*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-done)
+
+		assert.Len(t, bot.delivered, 1)
+		assert.Equal(t, "coming right up", bot.delivered[0].Output)
+
+		output := out.String()
+		assert.Contains(t, output, "[cli:luffy] waiting for an agent: hungry")
+		assert.Contains(t, output, "[cli:luffy] user: I want meat")
+		assert.Contains(t, output, "[cli:luffy] released to the bot")
+	})
+}
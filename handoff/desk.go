@@ -0,0 +1,400 @@
+package handoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var (
+	ErrTicketNotFound = errors.New("ticket not found")
+	ErrTicketClaimed  = errors.New("ticket already claimed")
+	ErrNotAssigned    = errors.New("ticket is not assigned to the agent")
+	ErrQueueEmpty     = errors.New("no ticket waiting for an agent")
+	ErrNotAttached    = errors.New("desk is not attached to a running bot")
+)
+
+// HandedOffState marks a session whose messages go to a human agent instead
+// of the engine.
+type HandedOffState struct {
+	Reason string
+}
+
+func (HandedOffState) IsState() {}
+
+// HandedOffStateName is the name HandedOffState is persisted under, see
+// core.RegisterStateType.
+const HandedOffStateName = "handoff.handed_off"
+
+func init() {
+	core.RegisterStateType[HandedOffState](HandedOffStateName)
+}
+
+// Ticket is a conversation handed off to the agents. Its ID is the key of the
+// session it belongs to, so a session has at most one ticket.
+type Ticket struct {
+	ID        string
+	Connector message.MessageConnector
+	ChannelID string
+	UserID    string
+	Reason    string
+	Agent     string
+	QueuedAt  time.Time
+	ClaimedAt time.Time
+	// History holds the user messages received since the hand off.
+	History []message.Message
+}
+
+func (t Ticket) Claimed() bool {
+	return t.Agent != ""
+}
+
+type NotificationKind int
+
+const (
+	// TicketQueued is sent when a conversation starts waiting for an agent.
+	TicketQueued NotificationKind = iota
+	// TicketClaimed is sent when an agent takes a conversation.
+	TicketClaimed
+	// UserMessage carries a message sent by the user of a handed off session.
+	UserMessage
+	// TicketReleased is sent when a conversation goes back to the bot.
+	TicketReleased
+)
+
+type Notification struct {
+	Kind    NotificationKind
+	Ticket  Ticket
+	Message message.Message
+}
+
+// AgentConnector connects human agents to a desk. A connector serving several
+// agents routes UserMessage notifications by Ticket.Agent.
+type AgentConnector interface {
+	// Serve passes the agents' commands to desk until ctx is done.
+	Serve(ctx context.Context, desk *Desk) error
+	// Notify tells the agents about a change in the queue. It must not block.
+	Notify(n Notification)
+}
+
+// Bot is the running bot a desk reaches users through.
+type Bot interface {
+	// Deliver dispatches msg through the connector it names.
+	Deliver(ctx context.Context, msg message.Message) error
+	// UpdateSession changes the session stored under key.
+	UpdateSession(ctx context.Context, key string, fn func(*core.Session)) error
+}
+
+type DeskOption func(d *Desk)
+
+// WithQueuedReply sets the reply telling a user their conversation is
+// waiting for an agent.
+func WithQueuedReply(reply string) DeskOption {
+	return func(d *Desk) {
+		d.queuedReply = reply
+	}
+}
+
+// WithReleasedReply sets the message telling a user they are talking to the
+// bot again.
+func WithReleasedReply(reply string) DeskOption {
+	return func(d *Desk) {
+		d.releasedReply = reply
+	}
+}
+
+func WithAgentConnector(conns ...AgentConnector) DeskOption {
+	return func(d *Desk) {
+		d.connectors = append(d.connectors, conns...)
+	}
+}
+
+// Desk keeps the conversations handed off to human agents, from the moment
+// an action hands them off until an agent releases them back to the bot.
+type Desk struct {
+	mu            sync.Mutex
+	tickets       map[string]*Ticket
+	queue         []string
+	bot           Bot
+	connectors    []AgentConnector
+	queuedReply   string
+	releasedReply string
+}
+
+func NewDesk(opts ...DeskOption) *Desk {
+	d := &Desk{tickets: make(map[string]*Ticket)}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Attach makes the desk deliver agent replies through bot until detach is
+// called.
+func (d *Desk) Attach(bot Bot) (detach func()) {
+	d.mu.Lock()
+	d.bot = bot
+	d.mu.Unlock()
+
+	return func() {
+		d.mu.Lock()
+		d.bot = nil
+		d.mu.Unlock()
+	}
+}
+
+// Run serves the agent connectors until ctx is done or one of them fails.
+func (d *Desk) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, len(d.connectors))
+	for _, conn := range d.connectors {
+		go func() {
+			errCh <- conn.Serve(ctx, d)
+		}()
+	}
+
+	var err error
+	for range d.connectors {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	return err
+}
+
+// HandOff queues the conversation msg belongs to for a human agent. Messages
+// the user sends from now on skip the engine until an agent releases it.
+func (d *Desk) HandOff(ctx *core.Context, msg *message.Message, reason string) {
+	ctx.SetSessionState(HandedOffState{Reason: reason})
+
+	d.mu.Lock()
+	t, queued := d.enqueue(*msg, reason)
+	ticket := t.clone()
+	d.mu.Unlock()
+
+	if queued {
+		d.notify(Notification{Kind: TicketQueued, Ticket: ticket, Message: *msg})
+	}
+
+	if d.queuedReply != "" {
+		msg.Output = d.queuedReply
+		msg.ResponseType = message.TextResponse
+		ctx.SendOutput(msg)
+	}
+}
+
+// HandOffAction returns an action handing the conversation off, to be used in
+// rules and choices.
+func (d *Desk) HandOffAction(reason string) core.ActionFunc {
+	return func(ctx *core.Context, msg *message.Message) {
+		d.HandOff(ctx, msg, reason)
+	}
+}
+
+// Middleware sends the messages of handed off sessions to their ticket
+// instead of the engine.
+func (d *Desk) Middleware() core.Middleware {
+	return func(next core.Engine) core.Engine {
+		return core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			state, ok := ctx.Session().State.(HandedOffState)
+			if !ok {
+				next.HandleMessage(ctx, msg)
+				return
+			}
+			d.forward(*msg, state.Reason)
+		})
+	}
+}
+
+func (d *Desk) forward(msg message.Message, reason string) {
+	d.mu.Lock()
+	t, queued := d.enqueue(msg, reason)
+	ticket := t.clone()
+	d.mu.Unlock()
+
+	if queued {
+		// the ticket was lost, e.g. by a restart, while the session kept its state
+		d.notify(Notification{Kind: TicketQueued, Ticket: ticket, Message: msg})
+		return
+	}
+	d.notify(Notification{Kind: UserMessage, Ticket: ticket, Message: msg})
+}
+
+// enqueue records msg in the ticket of its session, opening one if needed.
+func (d *Desk) enqueue(msg message.Message, reason string) (*Ticket, bool) {
+	id := core.SessionKey(msg)
+
+	if t, ok := d.tickets[id]; ok {
+		t.History = append(t.History, msg)
+		return t, false
+	}
+
+	t := &Ticket{
+		ID:        id,
+		Connector: msg.Connector,
+		ChannelID: msg.ChannelID,
+		UserID:    msg.User.ID,
+		Reason:    reason,
+		QueuedAt:  time.Now(),
+		History:   []message.Message{msg},
+	}
+	d.tickets[id] = t
+	d.queue = append(d.queue, id)
+	return t, true
+}
+
+// Queue returns the tickets waiting for an agent, oldest first.
+func (d *Desk) Queue() []Ticket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tickets := make([]Ticket, 0, len(d.queue))
+	for _, id := range d.queue {
+		tickets = append(tickets, d.tickets[id].clone())
+	}
+	return tickets
+}
+
+// Assigned returns the tickets claimed by agent.
+func (d *Desk) Assigned(agent string) []Ticket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var tickets []Ticket
+	for _, t := range d.tickets {
+		if t.Agent == agent {
+			tickets = append(tickets, t.clone())
+		}
+	}
+	return tickets
+}
+
+// Claim assigns the ticket to agent. An empty id claims the oldest ticket in
+// the queue.
+func (d *Desk) Claim(agent, id string) (Ticket, error) {
+	d.mu.Lock()
+
+	if id == "" {
+		if len(d.queue) == 0 {
+			d.mu.Unlock()
+			return Ticket{}, ErrQueueEmpty
+		}
+		id = d.queue[0]
+	}
+
+	t, ok := d.tickets[id]
+	if !ok {
+		d.mu.Unlock()
+		return Ticket{}, fmt.Errorf("%w: %q", ErrTicketNotFound, id)
+	}
+	if t.Claimed() {
+		d.mu.Unlock()
+		return Ticket{}, fmt.Errorf("%w: %q by %q", ErrTicketClaimed, id, t.Agent)
+	}
+
+	t.Agent = agent
+	t.ClaimedAt = time.Now()
+	d.dequeue(id)
+	ticket := t.clone()
+	d.mu.Unlock()
+
+	d.notify(Notification{Kind: TicketClaimed, Ticket: ticket})
+	return ticket, nil
+}
+
+// Reply sends text from agent to the user of the ticket.
+func (d *Desk) Reply(ctx context.Context, agent, id, text string) error {
+	ticket, bot, err := d.assigned(agent, id)
+	if err != nil {
+		return err
+	}
+	return bot.Deliver(ctx, ticket.message(text))
+}
+
+// Release returns the conversation of the ticket to the bot.
+func (d *Desk) Release(ctx context.Context, agent, id string) error {
+	ticket, bot, err := d.assigned(agent, id)
+	if err != nil {
+		return err
+	}
+
+	err = bot.UpdateSession(ctx, ticket.ID, func(sess *core.Session) {
+		if _, ok := sess.State.(HandedOffState); ok {
+			sess.State = core.IdleState{}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	delete(d.tickets, ticket.ID)
+	d.mu.Unlock()
+
+	d.notify(Notification{Kind: TicketReleased, Ticket: ticket})
+
+	if d.releasedReply != "" {
+		return bot.Deliver(ctx, ticket.message(d.releasedReply))
+	}
+	return nil
+}
+
+func (d *Desk) assigned(agent, id string) (Ticket, Bot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tickets[id]
+	if !ok {
+		return Ticket{}, nil, fmt.Errorf("%w: %q", ErrTicketNotFound, id)
+	}
+	if t.Agent != agent {
+		return Ticket{}, nil, fmt.Errorf("%w: %q", ErrNotAssigned, id)
+	}
+	if d.bot == nil {
+		return Ticket{}, nil, ErrNotAttached
+	}
+	return t.clone(), d.bot, nil
+}
+
+func (d *Desk) dequeue(id string) {
+	for i, queued := range d.queue {
+		if queued == id {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Desk) notify(n Notification) {
+	for _, conn := range d.connectors {
+		conn.Notify(n)
+	}
+}
+
+func (t *Ticket) clone() Ticket {
+	c := *t
+	c.History = append([]message.Message(nil), t.History...)
+	return c
+}
+
+func (t Ticket) message(text string) message.Message {
+	return message.Message{
+		ID:           uuid.NewString(),
+		Connector:    t.Connector,
+		ChannelID:    t.ChannelID,
+		User:         message.User{ID: t.UserID},
+		Output:       text,
+		ResponseType: message.TextResponse,
+	}
+}
@@ -0,0 +1,194 @@
+package handoff_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/handoff"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBot struct {
+	mu        sync.Mutex
+	delivered []message.Message
+	sessions  map[string]*core.Session
+}
+
+func newFakeBot() *fakeBot {
+	return &fakeBot{sessions: make(map[string]*core.Session)}
+}
+
+func (b *fakeBot) Deliver(_ context.Context, msg message.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delivered = append(b.delivered, msg)
+	return nil
+}
+
+func (b *fakeBot) UpdateSession(_ context.Context, key string, fn func(*core.Session)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sess, ok := b.sessions[key]
+	if !ok {
+		sess = &core.Session{ID: key}
+		b.sessions[key] = sess
+	}
+	fn(sess)
+	return nil
+}
+
+type recorder struct {
+	mu            sync.Mutex
+	notifications []handoff.Notification
+}
+
+func (r *recorder) Serve(ctx context.Context, _ *handoff.Desk) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (r *recorder) Notify(n handoff.Notification) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifications = append(r.notifications, n)
+}
+
+func (r *recorder) kinds() []handoff.NotificationKind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kinds []handoff.NotificationKind
+	for _, n := range r.notifications {
+		kinds = append(kinds, n.Kind)
+	}
+	return kinds
+}
+
+func newTestContext(t *testing.T, msg message.Message, output chan<- message.Message) *core.Context {
+	t.Helper()
+
+	chatCtx := core.NewChatContext(make(chan core.Event, 1))
+	t.Cleanup(chatCtx.Shutdown)
+
+	ctx, err := chatCtx.NewChildContext(msg, output)
+	assert.NoError(t, err)
+	t.Cleanup(ctx.Cancel)
+	return ctx
+}
+
+func TestDesk(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hand off queues the conversation and replies", func(t *testing.T) {
+		t.Parallel()
+
+		agents := &recorder{}
+		desk := handoff.NewDesk(handoff.WithQueuedReply("an agent will talk to you"), handoff.WithAgentConnector(agents))
+
+		msg := message.Message{User: message.User{ID: "luffy"}, Connector: message.Cli, ChannelID: "CLI", Input: "refund"}
+		output := make(chan message.Message, 1)
+		ctx := newTestContext(t, msg, output)
+
+		desk.HandOffAction("billing")(ctx, &msg)
+
+		assert.Equal(t, handoff.HandedOffState{Reason: "billing"}, ctx.Session().State)
+		assert.Equal(t, "an agent will talk to you", (<-output).Output)
+
+		queue := desk.Queue()
+		assert.Len(t, queue, 1)
+		assert.Equal(t, "cli:luffy", queue[0].ID)
+		assert.Equal(t, "luffy", queue[0].UserID)
+		assert.Equal(t, "billing", queue[0].Reason)
+		assert.Equal(t, message.Cli, queue[0].Connector)
+		assert.Equal(t, []handoff.NotificationKind{handoff.TicketQueued}, agents.kinds())
+	})
+
+	t.Run("handed off messages skip the engine", func(t *testing.T) {
+		t.Parallel()
+
+		agents := &recorder{}
+		desk := handoff.NewDesk(handoff.WithAgentConnector(agents))
+
+		called := false
+		engine := core.Chain(core.EngineFunc(func(*core.Context, *message.Message) { called = true }), desk.Middleware())
+
+		msg := message.Message{User: message.User{ID: "zoro"}, Input: "hello?"}
+		ctx := newTestContext(t, msg, nil)
+		ctx.SetSessionState(handoff.HandedOffState{Reason: "lost"})
+
+		engine.HandleMessage(ctx, &msg)
+
+		assert.False(t, called)
+		assert.Len(t, desk.Queue(), 1, "a lost ticket is queued again")
+
+		_, err := desk.Claim("nami", "")
+		assert.NoError(t, err)
+
+		engine.HandleMessage(ctx, &msg)
+		assert.False(t, called)
+		assert.Equal(t, []handoff.NotificationKind{
+			handoff.TicketQueued,
+			handoff.TicketClaimed,
+			handoff.UserMessage,
+		}, agents.kinds())
+	})
+
+	t.Run("claim, reply and release", func(t *testing.T) {
+		t.Parallel()
+
+		bot := newFakeBot()
+		desk := handoff.NewDesk(handoff.WithReleasedReply("back to the bot"))
+		defer desk.Attach(bot)()
+
+		msg := message.Message{User: message.User{ID: "usopp"}, Connector: message.Telegram, ChannelID: "42"}
+		desk.HandOff(newTestContext(t, msg, nil), &msg, "help")
+		key := core.SessionKey(msg)
+		bot.sessions[key] = &core.Session{ID: key, UserID: "usopp", State: handoff.HandedOffState{}}
+
+		_, err := desk.Claim("sanji", "")
+		assert.NoError(t, err)
+		assert.Empty(t, desk.Queue())
+		assert.Len(t, desk.Assigned("sanji"), 1)
+
+		_, err = desk.Claim("robin", key)
+		assert.ErrorIs(t, err, handoff.ErrTicketClaimed)
+		assert.ErrorIs(t, desk.Reply(context.Background(), "robin", key, "hi"), handoff.ErrNotAssigned)
+
+		assert.NoError(t, desk.Reply(context.Background(), "sanji", key, "hi, how can I help?"))
+		assert.NoError(t, desk.Release(context.Background(), "sanji", key))
+
+		assert.Equal(t, core.IdleState{}, bot.sessions[key].State)
+		assert.Empty(t, desk.Assigned("sanji"))
+		assert.Len(t, bot.delivered, 2)
+		assert.Equal(t, "hi, how can I help?", bot.delivered[0].Output)
+		assert.Equal(t, message.Telegram, bot.delivered[0].Connector)
+		assert.Equal(t, "42", bot.delivered[0].ChannelID)
+		assert.Equal(t, "usopp", bot.delivered[0].User.ID)
+		assert.Equal(t, "back to the bot", bot.delivered[1].Output)
+	})
+
+	t.Run("reply needs an attached bot", func(t *testing.T) {
+		t.Parallel()
+
+		desk := handoff.NewDesk()
+		msg := message.Message{User: message.User{ID: "brook"}}
+		desk.HandOff(newTestContext(t, msg, nil), &msg, "help")
+
+		_, err := desk.Claim("franky", core.SessionKey(msg))
+		assert.NoError(t, err)
+		assert.ErrorIs(t, desk.Reply(context.Background(), "franky", core.SessionKey(msg), "hi"), handoff.ErrNotAttached)
+	})
+
+	t.Run("claim on an empty queue", func(t *testing.T) {
+		t.Parallel()
+
+		desk := handoff.NewDesk()
+		_, err := desk.Claim("jinbe", "")
+		assert.ErrorIs(t, err, handoff.ErrQueueEmpty)
+		_, err = desk.Claim("jinbe", "nobody")
+		assert.ErrorIs(t, err, handoff.ErrTicketNotFound)
+	})
+}
@@ -0,0 +1,109 @@
+package ohmychat_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/handoff"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type agentStub struct {
+	notifications chan handoff.Notification
+}
+
+func (a *agentStub) Serve(ctx context.Context, _ *handoff.Desk) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (a *agentStub) Notify(n handoff.Notification) {
+	a.notifications <- n
+}
+
+func TestOhMyChat_HandOff(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	inbound := make(chan message.Message)
+	dispatched := make(chan message.Message, 3)
+
+	conn := mocks.NewMockConnector(ctrl)
+	conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+	conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx *core.ChatContext, input chan<- message.Message) error {
+			for {
+				select {
+				case msg := <-inbound:
+					input <- msg
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		},
+	)
+	conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+		dispatched <- m
+		return nil
+	}).AnyTimes()
+
+	agent := &agentStub{notifications: make(chan handoff.Notification, 4)}
+	desk := handoff.NewDesk(handoff.WithAgentConnector(agent))
+
+	engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+		if msg.Input == "atendente" {
+			desk.HandOff(ctx, msg, "pediu atendente")
+			return
+		}
+		msg.Output = "bot: " + msg.Input
+		ctx.SendOutput(msg)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine), ohmychat.WithHandOff(desk))
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- bot.Run(ctx) }()
+
+	say := func(input string) {
+		inbound <- message.Message{User: message.User{ID: "vivi"}, Connector: message.Cli, ChannelID: "CLI", Input: input}
+	}
+
+	say("atendente")
+	assert.Equal(t, handoff.TicketQueued, (<-agent.notifications).Kind)
+
+	ticket, err := desk.Claim("koza", "")
+	assert.NoError(t, err)
+	assert.Equal(t, handoff.TicketClaimed, (<-agent.notifications).Kind)
+
+	say("oi?")
+	n := <-agent.notifications
+	assert.Equal(t, handoff.UserMessage, n.Kind)
+	assert.Equal(t, "oi?", n.Message.Input)
+
+	assert.NoError(t, desk.Reply(ctx, "koza", ticket.ID, "olá, sou o koza"))
+	assert.Equal(t, "olá, sou o koza", (<-dispatched).Output)
+
+	assert.NoError(t, desk.Release(ctx, "koza", ticket.ID))
+	assert.Equal(t, handoff.TicketReleased, (<-agent.notifications).Kind)
+
+	say("voltei")
+	select {
+	case msg := <-dispatched:
+		assert.Equal(t, "bot: voltei", msg.Output)
+	case <-time.After(time.Second):
+		t.Fatal("released session did not reach the engine")
+	}
+
+	cancel()
+	assert.NoError(t, <-runErr)
+}
@@ -0,0 +1,66 @@
+package ohmychat
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+// HealthRun is the component reporting whether Run is serving, as opposed
+// to starting or draining.
+const HealthRun = "ohmychat"
+
+// WithHealth makes the pipeline report the state of its components to h:
+// every connector, the session adapter, the processor queues and the event
+// handler.
+func WithHealth(h *core.Health) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if h == nil {
+			b.invalid("health registry must not be nil")
+			return
+		}
+		b.config.health = h
+	}
+}
+
+// WithHealthListener serves the health of the pipeline at addr over HTTP
+// until Run has drained: /livez fails once Run is draining, /readyz as well as
+// soon as any component is down. Both answer with the full report in JSON.
+// Without WithHealth a new registry is used.
+func WithHealthListener(addr string) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if addr == "" {
+			b.invalid("health listener address must not be empty")
+			return
+		}
+		b.config.healthAddr = addr
+	}
+}
+
+// listenHealth opens the health listener, if one is configured.
+func (b *ohMyChat) listenHealth() (*httpListener, error) {
+	if b.config.healthAddr == "" {
+		return nil, nil
+	}
+
+	h := b.config.health
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		report := h.Report(r.Context())
+		writeHealth(w, report, report.Components[HealthRun].Status == core.HealthUp)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := h.Report(r.Context())
+		writeHealth(w, report, report.Ready())
+	})
+	return listenHTTP("health", b.config.healthAddr, mux)
+}
+
+func writeHealth(w http.ResponseWriter, report core.HealthReport, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,185 @@
+package ohmychat_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type pingingRepo struct {
+	*core.InMemorySessionRepo
+	err error
+}
+
+func (r pingingRepo) Ping(context.Context) error {
+	return r.err
+}
+
+func getHealth(addr, path string) (int, core.HealthReport, error) {
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		return 0, core.HealthReport{}, err
+	}
+	defer resp.Body.Close()
+
+	var report core.HealthReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	return resp.StatusCode, report, err
+}
+
+func TestOhMyChat_Health(t *testing.T) {
+	t.Parallel()
+
+	t.Run("serves liveness and readiness per component", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx *core.ChatContext, _ chan<- message.Message) error {
+				ctx.ReportHealth(core.ConnectorHealth(message.Telegram), core.HealthDegraded, "slow updates")
+				<-ctx.Done()
+				return nil
+			})
+
+		addr := freeAddr(t)
+		repo := pingingRepo{InMemorySessionRepo: core.NewInMemorySessionRepo(), err: errors.New("redis unreachable")}
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithSessionAdapter(repo),
+			ohmychat.WithHealthListener(addr),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		var (
+			code   int
+			report core.HealthReport
+		)
+		assert.Eventually(t, func() bool {
+			var err error
+			code, report, err = getHealth(addr, "/livez")
+			return err == nil && code == http.StatusOK &&
+				report.Components[core.ConnectorHealth(message.Telegram)].Status == core.HealthDegraded
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, core.HealthUp, report.Components[ohmychat.HealthRun].Status)
+		assert.Equal(t, "slow updates", report.Components[core.ConnectorHealth(message.Telegram)].Message)
+		assert.Equal(t, core.HealthUp, report.Components[core.HealthProcessor].Status)
+		assert.Contains(t, report.Components[core.HealthProcessor].Data, "queue_depth")
+		assert.Equal(t, core.HealthUp, report.Components[core.HealthEvents].Status)
+
+		code, report, err := getHealth(addr, "/readyz")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, core.HealthDown, report.Status)
+		assert.Equal(t, "redis unreachable", report.Components[core.HealthSessions].Message)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("serves the health until drained", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx *core.ChatContext, input chan<- message.Message) error {
+				input <- message.Message{ID: "1", Connector: message.Cli, User: message.User{ID: "nami"}}
+				<-ctx.Done()
+				return nil
+			})
+
+		handling := make(chan struct{})
+		release := make(chan struct{})
+		addr := freeAddr(t)
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {
+				close(handling)
+				<-release
+			})),
+			ohmychat.WithHealthListener(addr),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		<-handling
+		cancel()
+
+		// the message being handled holds the drain back
+		assert.Eventually(t, func() bool {
+			code, report, err := getHealth(addr, "/livez")
+			return err == nil && code == http.StatusServiceUnavailable &&
+				report.Components[ohmychat.HealthRun].Message == "draining"
+		}, time.Second, 10*time.Millisecond)
+
+		close(release)
+		assert.NoError(t, <-runErr)
+
+		_, _, err := getHealth(addr, "/livez")
+		assert.Error(t, err)
+	})
+
+	t.Run("reports through a given registry", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return(errors.New("token revoked"))
+
+		health := core.NewHealth()
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithHealth(health),
+		)
+		assert.Error(t, bot.Run(context.Background()))
+
+		report := health.Report(context.Background())
+		assert.False(t, report.Ready())
+		assert.Equal(t, "token revoked", report.Components[core.ConnectorHealth(message.Cli)].Message)
+		assert.Equal(t, "draining", report.Components[ohmychat.HealthRun].Message)
+		assert.Equal(t, core.HealthDown, report.Components[core.HealthProcessor].Status)
+	})
+
+	t.Run("rejects a nil registry", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bot := ohmychat.NewOhMyChat(
+			mocks.NewMockConnector(ctrl),
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithHealth(nil),
+		)
+		assert.ErrorIs(t, bot.Run(context.Background()), ohmychat.ErrInvalidOption)
+	})
+}
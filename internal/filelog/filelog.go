@@ -0,0 +1,199 @@
+// Package filelog persists the values of in-memory stores as an append-only
+// log of JSON lines, so they survive restarts.
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// A Log is rewritten once it holds at least compactMin records and
+// compactRatio records per live value.
+const (
+	compactMin   = 100
+	compactRatio = 2
+)
+
+// record is a line of the log: a value as last put, or its removal.
+type record[T any] struct {
+	ID      string `json:"id"`
+	Entry   *T     `json:"entry,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// Log appends every change to the values of a store, keyed by ID, to the file
+// at path. It is rewritten with only the live values once it grows past
+// compactRatio records per value.
+//
+// Every write is synced before returning, and cut off the log if it fails. A
+// record that cannot be read when the log is opened, such as one torn by a
+// crash, is skipped.
+//
+// A Log is not safe for concurrent use: stores write it before they update
+// their values, under the same lock.
+type Log[T any] struct {
+	path    string
+	file    *os.File
+	size    int64
+	records int
+}
+
+// Open replays the log at path into values and opens it for appending.
+func Open[T any](path string, values map[string]T) (*Log[T], error) {
+	l := &Log[T]{path: path}
+
+	if err := l.load(values); err != nil {
+		return nil, err
+	}
+	if err := l.Compact(values); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load replays the log, skipping the records that cannot be read. A last line
+// without its newline is a write torn by a crash.
+func (l *Log[T]) load(values map[string]T) error {
+	data, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+
+		var rec record[T]
+		if err := json.Unmarshal(line, &rec); err != nil {
+			continue
+		}
+		switch {
+		case rec.Removed:
+			delete(values, rec.ID)
+		case rec.Entry != nil:
+			values[rec.ID] = *rec.Entry
+		}
+	}
+}
+
+// Put records value as the one of id.
+func (l *Log[T]) Put(id string, value T) error {
+	line, err := json.Marshal(record[T]{ID: id, Entry: &value})
+	if err != nil {
+		return err
+	}
+	return l.append(line)
+}
+
+// Remove records the removal of the value of id.
+func (l *Log[T]) Remove(id string) error {
+	line, err := json.Marshal(record[T]{ID: id, Removed: true})
+	if err != nil {
+		return err
+	}
+	return l.append(line)
+}
+
+// Close closes the log. It must not be used afterwards.
+func (l *Log[T]) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// append writes line to the log, truncating it back to its last record when
+// the write fails so the next one does not follow a partial line.
+func (l *Log[T]) append(line []byte) error {
+	n, err := l.file.Write(append(line, '\n'))
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			if truncErr := l.file.Truncate(l.size); truncErr != nil {
+				return errors.Join(err, truncErr)
+			}
+		}
+		return err
+	}
+	l.size += int64(n)
+	l.records++
+	return nil
+}
+
+// MaybeCompact rewrites the log with values, the live ones, if it has grown
+// past compactRatio records per value.
+func (l *Log[T]) MaybeCompact(values map[string]T) error {
+	if l.records < compactMin || l.records < compactRatio*len(values) {
+		return nil
+	}
+	return l.Compact(values)
+}
+
+// Compact writes values, the live ones, to a temporary file and renames it
+// over the log, so a crash leaves either the old log or the new one.
+func (l *Log[T]) Compact(values map[string]T) error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, id := range slices.Sorted(maps.Keys(values)) {
+		value := values[id]
+		line, err := json.Marshal(record[T]{ID: id, Entry: &value})
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+	l.size = info.Size()
+	l.records = len(values)
+	return nil
+}
@@ -1,6 +1,7 @@
 package message
 
 import (
+	"maps"
 	"time"
 
 	"github.com/google/uuid"
@@ -40,10 +41,16 @@ type Meta struct {
 }
 
 func (m *Meta) Add(name, value string) {
-	m.Data["name"] = value
+	if m.Data == nil {
+		m.Data = make(map[string]string)
+	}
+	m.Data[name] = value
 }
 
 func (m *Meta) Get(name string) string {
+	if m == nil {
+		return ""
+	}
 	value, ok := m.Data[name]
 	if !ok {
 		return ""
@@ -89,3 +96,14 @@ func NewMessage() Message {
 		Meta:      &Meta{Data: make(map[string]string)},
 	}
 }
+
+// SetMeta sets a metadata entry on a copy of Meta, which is shared by every
+// copy of the message, leaving the other copies untouched.
+func (m *Message) SetMeta(name, value string) {
+	meta := &Meta{Data: make(map[string]string)}
+	if m.Meta != nil {
+		maps.Copy(meta.Data, m.Meta.Data)
+	}
+	meta.Data[name] = value
+	m.Meta = meta
+}
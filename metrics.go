@@ -0,0 +1,85 @@
+package ohmychat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/guiflemes/ohmychat/metrics"
+)
+
+// WithMetrics makes the pipeline report to reg: messages received and sent per
+// connector, dispatch errors, handler latency, busy workers of each stage and
+// active sessions.
+func WithMetrics(reg metrics.Registry) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if reg == nil {
+			b.invalid("metrics registry must not be nil")
+			return
+		}
+		b.config.metrics = reg
+	}
+}
+
+// WithMetricsListener serves the metrics at addr over HTTP until Run has
+// drained. Without WithMetrics a metrics.PrometheusRegistry is used, otherwise
+// the registry must implement http.Handler.
+func WithMetricsListener(addr string) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if addr == "" {
+			b.invalid("metrics listener address must not be empty")
+			return
+		}
+		b.config.metricsAddr = addr
+	}
+}
+
+// listenMetrics opens the metrics listener, if one is configured.
+func (b *ohMyChat) listenMetrics() (*httpListener, error) {
+	if b.config.metricsAddr == "" {
+		return nil, nil
+	}
+
+	handler, ok := b.config.metrics.(http.Handler)
+	if !ok {
+		return nil, fmt.Errorf("%w: metrics registry %T is not an http.Handler", ErrInvalidOption, b.config.metrics)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	return listenHTTP("metrics", b.config.metricsAddr, mux)
+}
+
+// httpListener is an HTTP server served alongside Run.
+type httpListener struct {
+	ln  net.Listener
+	srv *http.Server
+}
+
+func listenHTTP(name, addr string, handler http.Handler) (*httpListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s listener: %w", name, err)
+	}
+	return &httpListener{ln: ln, srv: &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}}, nil
+}
+
+// Serve serves until ctx is done.
+func (l *httpListener) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.srv.Close()
+	}()
+	if err := l.srv.Serve(l.ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close releases a listener that will not be served.
+func (l *httpListener) Close() {
+	_ = l.ln.Close()
+}
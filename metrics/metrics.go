@@ -0,0 +1,49 @@
+// Package metrics defines the instruments the pipeline reports to and a
+// dependency-free registry exposing them in the Prometheus text format.
+package metrics
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry creates instruments. Asking twice for the same name returns the
+// same instrument, so a backend can be shared by several components.
+// Label values are passed on every observation, in the order the label names
+// were given.
+type Registry interface {
+	Counter(name, help string, labels ...string) Counter
+	Gauge(name, help string, labels ...string) Gauge
+	Histogram(name, help string, buckets []float64, labels ...string) Histogram
+	// GaugeFunc registers a gauge whose value is read from fn when collected.
+	GaugeFunc(name, help string, fn func() float64)
+	// CounterFunc registers a counter whose value is read from fn when
+	// collected. fn must never return less than it did before.
+	CounterFunc(name, help string, fn func() float64)
+}
+
+type Counter interface {
+	Add(delta float64, labelValues ...string)
+}
+
+type Gauge interface {
+	Set(value float64, labelValues ...string)
+	Add(delta float64, labelValues ...string)
+}
+
+type Histogram interface {
+	Observe(value float64, labelValues ...string)
+}
+
+// Nop is a registry discarding every observation.
+type Nop struct{}
+
+func (Nop) Counter(string, string, ...string) Counter                { return nop{} }
+func (Nop) Gauge(string, string, ...string) Gauge                    { return nop{} }
+func (Nop) Histogram(string, string, []float64, ...string) Histogram { return nop{} }
+func (Nop) GaugeFunc(string, string, func() float64)                 {}
+func (Nop) CounterFunc(string, string, func() float64)               {}
+
+type nop struct{}
+
+func (nop) Add(float64, ...string)     {}
+func (nop) Set(float64, ...string)     {}
+func (nop) Observe(float64, ...string) {}
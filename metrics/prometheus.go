@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// PrometheusRegistry keeps instruments in memory and writes them in the
+// Prometheus text exposition format. It serves them over HTTP as well.
+type PrometheusRegistry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewPrometheusRegistry() *PrometheusRegistry {
+	return &PrometheusRegistry{families: make(map[string]*family)}
+}
+
+func (r *PrometheusRegistry) Counter(name, help string, labels ...string) Counter {
+	return r.family(name, help, counterKind, nil, labels)
+}
+
+func (r *PrometheusRegistry) Gauge(name, help string, labels ...string) Gauge {
+	return r.family(name, help, gaugeKind, nil, labels)
+}
+
+func (r *PrometheusRegistry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+	return r.family(name, help, histogramKind, buckets, labels)
+}
+
+func (r *PrometheusRegistry) GaugeFunc(name, help string, fn func() float64) {
+	r.family(name, help, gaugeKind, nil, nil).setFunc(fn)
+}
+
+func (r *PrometheusRegistry) CounterFunc(name, help string, fn func() float64) {
+	r.family(name, help, counterKind, nil, nil).setFunc(fn)
+}
+
+func (r *PrometheusRegistry) family(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s registered again as a %s with labels %v", name, k, labels))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  slices.Clone(labels),
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+// WriteTo writes every instrument in the Prometheus text format.
+func (r *PrometheusRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (r *PrometheusRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_, _ = r.WriteTo(w)
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+	fn     func() float64
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+func (f *family) setFunc(fn func() float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fn = fn
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) Add(delta float64, labelValues ...string) {
+	if f.kind == counterKind && delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", f.name))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value += delta
+}
+
+func (f *family) Set(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value = value
+}
+
+func (f *family) Observe(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.get(labelValues)
+	for i, bound := range f.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (f *family) write(w *countingWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+	w.printf("# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		w.printf("%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogramKind {
+			w.printf("%s%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			w.printf("%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, formatFloat(bound)), s.counts[i])
+		}
+		w.printf("%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatFloat(s.value))
+		w.printf("%s_count%s %d\n", f.name, f.labelPairs(s.labelValues, ""), s.count)
+	}
+}
+
+// labelPairs formats the labels of a series, adding the le label of a
+// histogram bucket when le is set.
+func (f *family) labelPairs(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) printf(format string, args ...any) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
@@ -0,0 +1,111 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, reg *metrics.PrometheusRegistry) string {
+	t.Helper()
+
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	assert.NoError(t, err)
+	return sb.String()
+}
+
+func TestPrometheusRegistry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("writes counters and gauges sorted by name and labels", func(t *testing.T) {
+		t.Parallel()
+
+		reg := metrics.NewPrometheusRegistry()
+		sent := reg.Counter("sent_total", "Messages sent.", "connector")
+		sent.Add(1, "telegram")
+		sent.Add(2, "cli")
+		reg.Counter("sent_total", "Messages sent.", "connector").Add(1, "cli")
+
+		busy := reg.Gauge("busy", "Busy workers.")
+		busy.Set(3)
+		busy.Add(-1)
+
+		assert.Equal(t, `# HELP busy Busy workers.
+# TYPE busy gauge
+busy 2
+# HELP sent_total Messages sent.
+# TYPE sent_total counter
+sent_total{connector="cli"} 3
+sent_total{connector="telegram"} 1
+`, collect(t, reg))
+	})
+
+	t.Run("writes cumulative histogram buckets", func(t *testing.T) {
+		t.Parallel()
+
+		reg := metrics.NewPrometheusRegistry()
+		latency := reg.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "stage")
+		latency.Observe(0.05, "a")
+		latency.Observe(0.5, "a")
+		latency.Observe(5, "a")
+
+		assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{stage="a",le="0.1"} 1
+latency_seconds_bucket{stage="a",le="1"} 2
+latency_seconds_bucket{stage="a",le="+Inf"} 3
+latency_seconds_sum{stage="a"} 5.55
+latency_seconds_count{stage="a"} 3
+`, collect(t, reg))
+	})
+
+	t.Run("reads gauge and counter funcs and escapes label values", func(t *testing.T) {
+		t.Parallel()
+
+		reg := metrics.NewPrometheusRegistry()
+		reg.GaugeFunc("sessions", "Active\nsessions.", func() float64 { return 7 })
+		reg.CounterFunc("dropped_total", "Dropped.", func() float64 { return 3 })
+		reg.Counter("hits_total", "Hits.", "rule").Add(1, `say "hi"\`)
+
+		assert.Equal(t, `# HELP dropped_total Dropped.
+# TYPE dropped_total counter
+dropped_total 3
+# HELP hits_total Hits.
+# TYPE hits_total counter
+hits_total{rule="say \"hi\"\\"} 1
+# HELP sessions Active\nsessions.
+# TYPE sessions gauge
+sessions 7
+`, collect(t, reg))
+	})
+
+	t.Run("rejects misuse", func(t *testing.T) {
+		t.Parallel()
+
+		reg := metrics.NewPrometheusRegistry()
+		counter := reg.Counter("total", "Total.", "a")
+
+		assert.Panics(t, func() { reg.Gauge("total", "Total.", "a") })
+		assert.Panics(t, func() { counter.Add(1) })
+		assert.Panics(t, func() { counter.Add(-1, "x") })
+	})
+
+	t.Run("serves the text format", func(t *testing.T) {
+		t.Parallel()
+
+		reg := metrics.NewPrometheusRegistry()
+		reg.Counter("total", "Total.").Add(1)
+
+		rec := httptest.NewRecorder()
+		reg.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "total 1\n")
+	})
+}
@@ -0,0 +1,101 @@
+package ohmychat_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestOhMyChat_Metrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("serves the metrics over http", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dispatched := make(chan message.Message, 1)
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			acquireThenWait(message.Message{User: message.User{ID: "shanks"}, Input: "oi", Connector: message.Cli}),
+		)
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			dispatched <- m
+			return nil
+		})
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SendOutput(msg)
+		})
+
+		addr := freeAddr(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine), ohmychat.WithMetricsListener(addr))
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		<-dispatched
+
+		var body string
+		assert.Eventually(t, func() bool {
+			resp, err := http.Get("http://" + addr + "/metrics")
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			body = string(b)
+			return resp.StatusCode == http.StatusOK
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Contains(t, body, `ohmychat_messages_received_total{connector="cli"} 1`)
+		assert.Contains(t, body, "ohmychat_sessions_active 1")
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("listener needs a registry serving http", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithMetrics(metrics.Nop{}),
+			ohmychat.WithMetricsListener(freeAddr(t)),
+		)
+
+		assert.ErrorIs(t, bot.Run(context.Background()), ohmychat.ErrInvalidOption)
+	})
+}
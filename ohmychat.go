@@ -1,86 +1,671 @@
 package ohmychat
 
 import (
-	"os"
-	"os/signal"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/delivery"
+	"github.com/guiflemes/ohmychat/handoff"
 	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/metrics"
+	"github.com/guiflemes/ohmychat/scheduler"
 )
 
+const DefaultDrainTimeout = 10 * time.Second
+
+var (
+	ErrDrainTimeout  = errors.New("drain deadline exceeded")
+	ErrInvalidOption = errors.New("invalid option")
+	ErrNotRunning    = errors.New("ohmychat is not running")
+)
+
+type config struct {
+	panicReply     string
+	processorPool  uint8
+	processorQueue uint8
+	responsePool   uint8
+	eventPool      uint8
+	inputBuffer    int
+	outputBuffer   int
+	eventBuffer    int
+	handlerTimeout time.Duration
+	drainTimeout   time.Duration
+	sessionAdapter core.SessionAdapter
+	sessionExpired func(ctx context.Context, sess core.Session)
+	scheduler      *scheduler.Scheduler
+	desk           *handoff.Desk
+	metrics        metrics.Registry
+	metricsAddr    string
+	spanExporter   core.SpanExporter
+	logger         *slog.Logger
+	transcript     core.TranscriptStore
+	outbox         *delivery.Outbox
+	health         *core.Health
+	healthAddr     string
+	events         *core.EventBus
+	subscribers    []subscriber
+	values         *core.Values
+}
+
+type subscriber struct {
+	fn   core.OnEvent
+	opts []core.SubscribeOption
+}
+
 type ohMyChat struct {
-	connector    core.Connector
-	eventHandler *core.EventHandler
+	engine      core.Engine
+	middlewares []core.Middleware
+	gates       []core.Gate
+	connectors  []core.Connector
+	onEvent     core.OnEvent
+	config      config
+	errs        []error
+
+	mu      sync.RWMutex
+	running *pipeline
+}
+
+// pipeline is the state of a running instance that Send needs to reach.
+type pipeline struct {
+	chatCtx   *core.ChatContext
+	connector interface {
+		Supports(kind message.MessageConnector) bool
+	}
+	output chan<- message.Message
+	// closing is closed once the output is about to be closed, turning new
+	// pushes away and releasing the pushes waiting for room.
+	closing chan struct{}
+	// pushes counts the pushes in flight, which the output is only closed
+	// after.
+	pushes sync.WaitGroup
 }
 
 type OhMyChatOption func(*ohMyChat)
 
+func (b *ohMyChat) invalid(format string, args ...any) {
+	b.errs = append(b.errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidOption}, args...)...))
+}
+
+// callbackEvents are the events WithEventCallback receives: failures and
+// dispatches, leaving out the lifecycle events.
+var callbackEvents = []core.EventType{
+	core.EventError,
+	core.EventTimeout,
+	core.EventPanic,
+	core.EventRateLimited,
+	core.EventReplyDispatched,
+	core.EventDispatchFailed,
+}
+
+// WithEventCallback calls cb with failures and dispatches, replacing the
+// callback of a previous WithEventCallback. Dispatches are reported as
+// EventSuccess and failed ones as EventError, as they always were, with their
+// details in the Payload. Its queue blocks the publisher while full rather
+// than losing events, up to core.DefaultBlockTimeout. Use
+// WithEventSubscriber to receive the lifecycle events or to add more
+// subscribers.
 func WithEventCallback(cb func(core.Event)) OhMyChatOption {
 	return func(b *ohMyChat) {
-		b.eventHandler.SetCallback(cb)
+		b.onEvent = cb
+	}
+}
+
+// legacyCallback gives cb the dispatch outcomes under the types callbacks
+// received them with before the lifecycle events.
+func legacyCallback(cb core.OnEvent) core.OnEvent {
+	return func(e core.Event) {
+		switch e.Type {
+		case core.EventReplyDispatched:
+			e.Type = core.EventSuccess
+		case core.EventDispatchFailed:
+			e.Type = core.EventError
+		}
+		cb(e)
+	}
+}
+
+// WithEventSubscriber calls fn with the events, from a queue of its own. Use
+// core.SubscribeWithTypes to pick the events and core.SubscribeWithQueue to
+// size the queue. It can be used any number of times.
+func WithEventSubscriber(fn core.OnEvent, opts ...core.SubscribeOption) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if fn == nil {
+			b.invalid("event subscriber must not be nil")
+			return
+		}
+		b.config.subscribers = append(b.config.subscribers, subscriber{fn: fn, opts: opts})
+	}
+}
+
+// WithEventBus publishes the events on bus, so subscribers can come and go
+// while the bot runs. The subscribers of the options are only subscribed while
+// Run runs.
+func WithEventBus(bus *core.EventBus) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if bus == nil {
+			b.invalid("event bus must not be nil")
+			return
+		}
+		b.config.events = bus
+	}
+}
+
+// WithEngine sets the engine handling the messages. It is required.
+func WithEngine(engine core.Engine) OhMyChatOption {
+	return func(b *ohMyChat) {
+		b.engine = engine
+	}
+}
+
+// WithConnector registers an additional connector. Every connector runs its
+// own Acquire loop and replies are dispatched back through the connector
+// matching message.Message.Connector, so each one must report a distinct Kind.
+func WithConnector(conn core.Connector) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if conn == nil {
+			b.invalid("connector must not be nil")
+			return
+		}
+		b.connectors = append(b.connectors, conn)
+	}
+}
+
+// WithDrainTimeout bounds how long Run waits for in-flight messages, replies
+// and events once it has been asked to stop.
+func WithDrainTimeout(timeout time.Duration) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if timeout <= 0 {
+			b.invalid("drain timeout must be positive, got %s", timeout)
+			return
+		}
+		b.config.drainTimeout = timeout
+	}
+}
+
+// WithProcessorPool sets the number of workers handling messages and the size
+// of each worker queue.
+func WithProcessorPool(workers, queueSize uint8) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if workers == 0 {
+			b.invalid("processor pool must have at least one worker")
+			return
+		}
+		b.config.processorPool = workers
+		b.config.processorQueue = queueSize
+	}
+}
+
+// WithResponsePool sets how many replies may be dispatched concurrently.
+func WithResponsePool(size uint8) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if size == 0 {
+			b.invalid("response pool must not be empty")
+			return
+		}
+		b.config.responsePool = size
+	}
+}
+
+// WithEventPool sets how many calls of the WithEventCallback callback may run
+// concurrently, 5 by default. Subscribers of WithEventSubscriber choose their
+// own with core.SubscribeWithWorkers.
+func WithEventPool(size uint8) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if size == 0 {
+			b.invalid("event pool must not be empty")
+			return
+		}
+		b.config.eventPool = size
+	}
+}
+
+// WithBufferSizes sets the capacity of the inbound and outbound channels
+// connecting the pipeline stages and the queue size of the event subscribers
+// not choosing their own. Full event queues drop their oldest event. The
+// event size is ignored with WithEventBus.
+func WithBufferSizes(input, output, event int) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if input < 0 || output < 0 || event < 0 {
+			b.invalid("buffer sizes must not be negative, got %d/%d/%d", input, output, event)
+			return
+		}
+		b.config.inputBuffer = input
+		b.config.outputBuffer = output
+		b.config.eventBuffer = event
+	}
+}
+
+// WithHandlerTimeout bounds how long the engine may take to handle a single
+// message. Handlers exceeding it emit a core.EventTimeout event.
+func WithHandlerTimeout(timeout time.Duration) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if timeout <= 0 {
+			b.invalid("handler timeout must be positive, got %s", timeout)
+			return
+		}
+		b.config.handlerTimeout = timeout
+	}
+}
+
+// WithMiddleware wraps the engine with middlewares, the first one being the
+// outermost.
+func WithMiddleware(middlewares ...core.Middleware) OhMyChatOption {
+	return func(b *ohMyChat) {
+		b.middlewares = append(b.middlewares, middlewares...)
+	}
+}
+
+// WithGate screens the inbound messages with gates, in order, before they are
+// queued for a processor worker, e.g. with ratelimit.Gate.
+func WithGate(gates ...core.Gate) OhMyChatOption {
+	return func(b *ohMyChat) {
+		b.gates = append(b.gates, gates...)
+	}
+}
+
+// WithPanicReply sets the apology sent to a user whose message made the engine
+// panic. The panic itself is always reported as a core.EventPanic event.
+func WithPanicReply(reply string) OhMyChatOption {
+	return func(b *ohMyChat) {
+		b.config.panicReply = reply
+	}
+}
+
+// WithScheduler lets actions queue messages through core.Context.Schedule.
+// Due messages are dispatched like any other reply while Run is serving.
+func WithScheduler(s *scheduler.Scheduler) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if s == nil {
+			b.invalid("scheduler must not be nil")
+			return
+		}
+		b.config.scheduler = s
+	}
+}
+
+// WithLogger sets the logger passed down to the connectors, the processor and
+// the engines. Its handler decides the level and where the lines go, e.g.
+// slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}).
+// Nothing is logged by default.
+func WithLogger(logger *slog.Logger) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if logger == nil {
+			b.invalid("logger must not be nil")
+			return
+		}
+		b.config.logger = logger
+	}
+}
+
+// WithTranscript records what every user said and what the bot answered in
+// store, to be queried for support and debugging.
+func WithTranscript(store core.TranscriptStore) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if store == nil {
+			b.invalid("transcript store must not be nil")
+			return
+		}
+		b.config.transcript = store
+	}
+}
+
+// WithTracing records a span for each step of the handling of a message,
+// from session load to dispatch, and hands them to exp. Spans of a message
+// share the trace ID its connector put in message.Meta.
+func WithTracing(exp core.SpanExporter) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if exp == nil {
+			b.invalid("span exporter must not be nil")
+			return
+		}
+		b.config.spanExporter = exp
+	}
+}
+
+func WithSessionAdapter(adapter core.SessionAdapter) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if adapter == nil {
+			b.invalid("session adapter must not be nil")
+			return
+		}
+		b.config.sessionAdapter = adapter
+	}
+}
+
+// WithValues makes the values provided to values, such as database or API
+// clients, resolvable by the actions through core.Resolve.
+func WithValues(values *core.Values) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if values == nil {
+			b.invalid("values must not be nil")
+			return
+		}
+		b.config.values = values
+	}
+}
+
+// WithSessionExpired registers fn to be called with every session the session
+// adapter removes for inactivity, so the bot can tell the user their session
+// timed out using Send and SessionTarget. The adapter must implement
+// core.ExpiringSessionAdapter, whose sweeper then runs alongside Run.
+func WithSessionExpired(fn func(ctx context.Context, sess core.Session)) OhMyChatOption {
+	return func(b *ohMyChat) {
+		if fn == nil {
+			b.invalid("session expired hook must not be nil")
+			return
+		}
+		b.config.sessionExpired = fn
 	}
 }
 
 func NewOhMyChat(connector core.Connector, opts ...OhMyChatOption) *ohMyChat {
 	b := &ohMyChat{
-		connector:    connector,
-		eventHandler: core.NewEventHandler(),
+		config: config{
+			processorPool:  5,
+			processorQueue: 10,
+			responsePool:   5,
+			eventPool:      5,
+			inputBuffer:    10,
+			outputBuffer:   10,
+			eventBuffer:    core.DefaultEventQueueSize,
+			handlerTimeout: core.DefaultHandlerTimeout,
+			drainTimeout:   DefaultDrainTimeout,
+		},
 	}
 
+	WithConnector(connector)(b)
+
 	for _, opt := range opts {
 		opt(b)
 	}
+	if b.engine == nil {
+		b.invalid("engine must not be nil")
+	}
 	return b
 }
 
-func (b *ohMyChat) Run(engine core.Engine) {
+// Run serves the connectors until ctx is cancelled or a connector fails.
+//
+// On stop it first ends the connectors' Acquire loops, then lets the processor
+// handle the messages already received, dispatches the pending replies and
+// delivers the remaining events. Whatever is still running once the drain
+// timeout expires is cancelled. Run returns the first fatal error, such as a
+// connector Acquire failure, or ErrDrainTimeout if the drain did not finish.
+func (b *ohMyChat) Run(ctx context.Context) error {
+	if err := errors.Join(b.errs...); err != nil {
+		return err
+	}
 
-	inputMsg := make(chan message.Message, 10)
-	outputMsg := make(chan message.Message, 10)
-	eventCh := make(chan core.Event, 10)
+	connector, err := core.NewMuitiChannelConnector(b.connectors...)
+	if err != nil {
+		return err
+	}
+	connector.SetConfig(core.ConnectorConfig{ResponseMaxPool: b.config.responsePool})
 
-	chatCtx := core.NewChatContext(eventCh)
-	processor := core.NewProcessor(engine)
-	connector := core.NewMuitiChannelConnector(b.connector)
+	inputMsg := make(chan message.Message, b.config.inputBuffer)
+	outputMsg := make(chan message.Message, b.config.outputBuffer)
 
-	sign := make(chan os.Signal, 1)
-	signal.Notify(sign, syscall.SIGTERM, os.Interrupt, syscall.SIGINT)
+	sessionAdapter := b.config.sessionAdapter
+	if sessionAdapter == nil {
+		sessionAdapter = core.NewInMemorySessionRepo()
+	}
 
-	go func() {
-		<-sign
-		chatCtx.Shutdown()
-	}()
+	expiring, isExpiring := sessionAdapter.(core.ExpiringSessionAdapter)
+	if b.config.sessionExpired != nil && !isExpiring {
+		return fmt.Errorf("%w: session adapter %T does not expire sessions", ErrInvalidOption, sessionAdapter)
+	}
 
-	var wg sync.WaitGroup
+	if b.config.metrics == nil && b.config.metricsAddr != "" {
+		b.config.metrics = metrics.NewPrometheusRegistry()
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		processor.Process(chatCtx, inputMsg, outputMsg)
-	}()
+	if b.config.health == nil && b.config.healthAddr != "" {
+		b.config.health = core.NewHealth()
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		connector.Request(chatCtx, inputMsg)
+	metricsListener, err := b.listenMetrics()
+	if err != nil {
+		return err
+	}
+
+	healthListener, err := b.listenHealth()
+	if err != nil {
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
+		return err
+	}
+
+	events := b.config.events
+	if events == nil {
+		events = core.NewEventBus(core.EventBusWithQueue(b.config.eventBuffer, core.DropOldest))
+	}
+	subscribers := b.config.subscribers
+	if b.onEvent != nil {
+		subscribers = append(slices.Clip(subscribers), subscriber{fn: legacyCallback(b.onEvent), opts: []core.SubscribeOption{
+			core.SubscribeWithTypes(callbackEvents...),
+			core.SubscribeWithQueue(b.config.eventBuffer, core.Block),
+			core.SubscribeWithWorkers(int(b.config.eventPool)),
+		}})
+	}
+	for _, sub := range subscribers {
+		defer events.Subscribe(sub.fn, sub.opts...)()
+	}
+
+	chatOpts := []core.ChatContextOption{
+		core.WithHandlerTimeout(b.config.handlerTimeout),
+		core.WithSessionAdapter(sessionAdapter),
+		core.WithEventBus(events),
+	}
+	if b.config.metrics != nil {
+		chatOpts = append(chatOpts, core.WithMetrics(b.config.metrics))
+	}
+	if b.config.spanExporter != nil {
+		chatOpts = append(chatOpts, core.WithTracing(b.config.spanExporter))
+	}
+	if b.config.logger != nil {
+		chatOpts = append(chatOpts, core.WithLogger(b.config.logger))
+	}
+	if b.config.transcript != nil {
+		chatOpts = append(chatOpts, core.WithTranscript(b.config.transcript))
+	}
+	if b.config.scheduler != nil {
+		chatOpts = append(chatOpts, core.WithScheduler(b.config.scheduler))
+	}
+	if b.config.outbox != nil {
+		chatOpts = append(chatOpts, core.WithDelivery(b.config.outbox))
+	}
+	if b.config.health != nil {
+		chatOpts = append(chatOpts, core.WithHealth(b.config.health))
+	}
+	if b.config.values != nil {
+		chatOpts = append(chatOpts, core.WithValues(b.config.values))
+	}
+
+	middlewares := b.middlewares
+	if b.config.desk != nil {
+		middlewares = append(slices.Clip(middlewares), b.config.desk.Middleware())
+	}
+
+	chatCtx := core.NewChatContext(nil, chatOpts...)
+	acquireCtx := chatCtx.WithCancel()
+	processor := core.NewProcessor(
+		b.engine,
+		core.ProcessWithMaxPool(b.config.processorPool),
+		core.ProcessWithQueueSize(b.config.processorQueue),
+		core.ProcessWithMiddleware(middlewares...),
+		core.ProcessWithGate(b.gates...),
+		core.ProcessWithPanicReply(b.config.panicReply),
+	)
+
+	b.mu.Lock()
+	b.running = &pipeline{chatCtx: chatCtx, connector: connector, output: outputMsg, closing: make(chan struct{})}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.running = nil
+		b.mu.Unlock()
 	}()
 
-	wg.Add(1)
+	var fatalErr error
+
+	requestDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		connector.Response(chatCtx, outputMsg)
+		defer close(requestDone)
+		fatalErr = connector.Request(acquireCtx, inputMsg)
 	}()
 
-	wg.Add(1)
+	pipelineDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		b.eventHandler.Handler(chatCtx, eventCh)
+		defer close(pipelineDone)
+
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer b.closeOutput(outputMsg)
+			processor.Process(chatCtx, inputMsg, outputMsg)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			connector.Response(chatCtx, outputMsg)
+		}()
+
+		wg.Wait()
 	}()
 
-	wg.Wait()
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+
+	var background sync.WaitGroup
+
+	if b.config.scheduler != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			b.config.scheduler.Run(backgroundCtx, func(ctx context.Context, msg message.Message) error {
+				err := b.push(ctx, msg, nil)
+				if err != nil {
+					chatCtx.SendEvent(core.NewEventErrorWithMessage(msg, err))
+				}
+				return err
+			})
+		}()
+	}
+
+	if b.config.outbox != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			b.config.outbox.Run(backgroundCtx, func(ctx context.Context, msg message.Message) error {
+				return b.push(ctx, msg, nil)
+			})
+		}()
+	}
+
+	if isExpiring {
+		if hook := b.config.sessionExpired; hook != nil {
+			defer expiring.OnExpired(func(sess core.Session) {
+				hook(backgroundCtx, sess)
+			})()
+		}
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			expiring.RunSweeper(backgroundCtx)
+		}()
+	}
+
+	// the listeners keep serving until the pipeline is drained, so the health
+	// checks see Run draining rather than a refused connection
+	listenCtx, stopListeners := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopListeners()
+
+	var listeners sync.WaitGroup
+	for _, l := range []*httpListener{metricsListener, healthListener} {
+		if l == nil {
+			continue
+		}
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			if err := l.Serve(listenCtx); err != nil {
+				chatCtx.SendEvent(core.NewEventError(err))
+			}
+		}()
+	}
+
+	if desk := b.config.desk; desk != nil {
+		detach := desk.Attach(deskBot{b: b, chatCtx: chatCtx})
+		defer detach()
+
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := desk.Run(backgroundCtx); err != nil {
+				chatCtx.SendEvent(core.NewEventError(err))
+			}
+		}()
+	}
+
+	logger := chatCtx.Logger()
+	logger.Info("ohmychat started", slog.Int("connectors", len(b.connectors)))
+	chatCtx.ReportHealth(HealthRun, core.HealthUp, "")
+
+	select {
+	case <-ctx.Done():
+	case <-requestDone:
+	}
+
+	logger.Info("ohmychat draining", slog.Duration("timeout", b.config.drainTimeout))
+	chatCtx.ReportHealth(HealthRun, core.HealthDown, "draining")
+
+	deadline := time.AfterFunc(b.config.drainTimeout, chatCtx.Shutdown)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), b.config.drainTimeout)
+	defer cancelDrain()
+
+	acquireCtx.Shutdown()
+	stopBackground()
+	background.Wait()
+	<-requestDone
+	close(inputMsg)
+	<-pipelineDone
+
+	chatCtx.Shutdown()
+	flushErr := events.Flush(drainCtx)
+	chatCtx.ReportHealth(core.HealthEvents, core.HealthDown, "stopped")
+	stopListeners()
+	listeners.Wait()
+
+	if (!deadline.Stop() || flushErr != nil) && fatalErr == nil {
+		logger.Warn("ohmychat stopped before draining", slog.Duration("timeout", b.config.drainTimeout))
+		return ErrDrainTimeout
+	}
+
+	logger.Info("ohmychat stopped")
+	return fatalErr
+}
+
+// closeOutput closes output once the pushes in flight are done with it.
+func (b *ohMyChat) closeOutput(output chan message.Message) {
+	b.mu.Lock()
+	p := b.running
+	close(p.closing)
+	b.mu.Unlock()
 
+	p.pushes.Wait()
+	close(output)
 }
 
 type Message = message.Message
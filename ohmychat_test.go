@@ -0,0 +1,282 @@
+package ohmychat_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func acquireThenWait(msgs ...message.Message) func(*core.ChatContext, chan<- message.Message) error {
+	return func(ctx *core.ChatContext, input chan<- message.Message) error {
+		for _, msg := range msgs {
+			input <- msg
+		}
+		<-ctx.Done()
+		return nil
+	}
+}
+
+func TestOhMyChat_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drains in-flight messages before returning", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		msgs := []message.Message{
+			{User: message.User{ID: "luffy"}, Input: "1", Connector: message.Test},
+			{User: message.User{ID: "zoro"}, Input: "2", Connector: message.Test},
+			{User: message.User{ID: "nami"}, Input: "3", Connector: message.Test},
+		}
+
+		var (
+			mu         sync.Mutex
+			dispatched []string
+		)
+		acquired := make(chan struct{}, len(msgs))
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(acquireThenWait(msgs...))
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			dispatched = append(dispatched, m.Output)
+			return nil
+		}).Times(len(msgs))
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			acquired <- struct{}{}
+			time.Sleep(50 * time.Millisecond)
+			msg.Output = "re: " + msg.Input
+			ctx.SendOutput(msg)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine))
+
+		go func() {
+			for range msgs {
+				<-acquired
+			}
+			cancel()
+		}()
+
+		assert.NoError(t, bot.Run(ctx))
+		assert.ElementsMatch(t, []string{"re: 1", "re: 2", "re: 3"}, dispatched)
+	})
+
+	t.Run("returns the connector acquire error", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return(assert.AnError)
+
+		var (
+			mu     sync.Mutex
+			events []core.Event
+		)
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithEventCallback(func(e core.Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, e)
+			}),
+		)
+
+		err := bot.Run(context.Background())
+		assert.ErrorIs(t, err, assert.AnError)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, events, 1)
+		assert.ErrorIs(t, events[0].Error, assert.AnError)
+	})
+
+	t.Run("logs the lifecycle and connector failures", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).Return(assert.AnError)
+
+		var buf bytes.Buffer
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))),
+		)
+
+		assert.ErrorIs(t, bot.Run(context.Background()), assert.AnError)
+
+		logs := buf.String()
+		assert.Contains(t, logs, "level=INFO msg=\"ohmychat started\" connectors=1")
+		assert.Contains(t, logs, "level=ERROR msg=\"connector stopped\" connector=testConn")
+		assert.Contains(t, logs, "level=INFO msg=\"ohmychat stopped\"")
+	})
+
+	t.Run("cancels handlers exceeding the drain timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		started := make(chan struct{})
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			acquireThenWait(message.Message{User: message.User{ID: "usopp"}, Connector: message.Test}),
+		)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			close(started)
+			<-ctx.Context().Done()
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine), ohmychat.WithDrainTimeout(50*time.Millisecond))
+
+		go func() {
+			<-started
+			cancel()
+		}()
+
+		assert.ErrorIs(t, bot.Run(ctx), ohmychat.ErrDrainTimeout)
+	})
+
+	t.Run("fails on duplicated connectors", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		first := mocks.NewMockConnector(ctrl)
+		second := mocks.NewMockConnector(ctrl)
+		first.EXPECT().Kind().Return(message.Cli).AnyTimes()
+		second.EXPECT().Kind().Return(message.Cli).AnyTimes()
+
+		bot := ohmychat.NewOhMyChat(
+			first,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithConnector(second),
+		)
+
+		assert.ErrorIs(t, bot.Run(context.Background()), core.ErrDuplicatedConnector)
+	})
+	t.Run("rejects invalid options", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithProcessorPool(0, 10),
+			ohmychat.WithResponsePool(0),
+			ohmychat.WithEventPool(0),
+			ohmychat.WithBufferSizes(-1, 0, 0),
+			ohmychat.WithHandlerTimeout(0),
+			ohmychat.WithDrainTimeout(-time.Second),
+			ohmychat.WithSessionAdapter(nil),
+		)
+
+		err := bot.Run(context.Background())
+		assert.ErrorIs(t, err, ohmychat.ErrInvalidOption)
+		assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 7)
+	})
+
+	t.Run("requires an engine", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bot := ohmychat.NewOhMyChat(mocks.NewMockConnector(ctrl))
+		assert.ErrorIs(t, bot.Run(context.Background()), ohmychat.ErrInvalidOption)
+	})
+
+	t.Run("applies session adapter and handler timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		msg := message.Message{User: message.User{ID: "robin"}, Connector: message.Test}
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Test).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(acquireThenWait(msg))
+
+		adapter := mocks.NewMockSessionAdapter(ctrl)
+		adapter.EXPECT().
+			GetOrCreate(gomock.Any(), core.SessionKey(msg)).
+			Return(&core.Session{UserID: "robin", State: core.IdleState{}}, nil)
+		adapter.EXPECT().Save(gomock.Any(), gomock.Any()).Return(nil)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			<-ctx.Context().Done()
+		})
+
+		timeouts := make(chan core.Event, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(engine),
+			ohmychat.WithSessionAdapter(adapter),
+			ohmychat.WithHandlerTimeout(20*time.Millisecond),
+			ohmychat.WithProcessorPool(1, 1),
+			ohmychat.WithResponsePool(1),
+			ohmychat.WithEventPool(1),
+			ohmychat.WithBufferSizes(0, 0, 0),
+			ohmychat.WithEventCallback(func(e core.Event) {
+				if e.Type == core.EventTimeout {
+					timeouts <- e
+					cancel()
+				}
+			}),
+		)
+
+		assert.NoError(t, bot.Run(ctx))
+
+		select {
+		case evt := <-timeouts:
+			assert.ErrorIs(t, evt.Error, context.DeadlineExceeded)
+			assert.Equal(t, "robin", evt.Msg.User.ID)
+		default:
+			t.Fatal("expected timeout event")
+		}
+	})
+}
@@ -0,0 +1,226 @@
+// Package ohmychattest drives engines in tests, one message at a time and
+// without sleeps.
+package ohmychattest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// maxEvents bounds how many events a single message may emit.
+const maxEvents = 256
+
+type Option func(b *Bot)
+
+// WithMiddleware wraps the engine with middlewares, the first one being the
+// outermost, as ohmychat.WithMiddleware does.
+func WithMiddleware(middlewares ...core.Middleware) Option {
+	return func(b *Bot) {
+		b.middlewares = append(b.middlewares, middlewares...)
+	}
+}
+
+// WithClock drives the sessions with clock instead of a new one stopped at
+// the current time.
+func WithClock(clock *Clock) Option {
+	return func(b *Bot) {
+		b.clock = clock
+	}
+}
+
+// WithSessionAdapter keeps the sessions in adapter instead of a fresh
+// in-memory one. The clock is then only used for expiry and activity.
+func WithSessionAdapter(adapter core.SessionAdapter) Option {
+	return func(b *Bot) {
+		b.sessionAdapter = adapter
+	}
+}
+
+// WithValues makes the values provided to values resolvable by the engine, as
+// ohmychat.WithValues does, typically fakes of the services it uses.
+func WithValues(values *core.Values) Option {
+	return func(b *Bot) {
+		b.values = values
+	}
+}
+
+// WithConnector sets the connector messages come from, message.Test by
+// default.
+func WithConnector(kind message.MessageConnector) Option {
+	return func(b *Bot) {
+		b.connector = kind
+	}
+}
+
+// Bot runs an engine synchronously: Say returns once the engine is done with
+// the message, along with everything it replied.
+type Bot struct {
+	t              testing.TB
+	middlewares    []core.Middleware
+	clock          *Clock
+	sessionAdapter core.SessionAdapter
+	values         *core.Values
+	connector      message.MessageConnector
+	processor      core.MessageHandler
+
+	mu      sync.Mutex
+	chatCtx *core.ChatContext
+	events  chan core.Event
+	seq     int
+}
+
+// New returns a Bot serving engine, shut down when the test ends.
+func New(t testing.TB, engine core.Engine, opts ...Option) *Bot {
+	t.Helper()
+
+	b := &Bot{
+		t:         t,
+		connector: message.Test,
+		events:    make(chan core.Event, maxEvents),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.clock == nil {
+		b.clock = NewClock(time.Now())
+	}
+	if b.sessionAdapter == nil {
+		b.sessionAdapter = core.NewInMemorySessionRepo(core.WithSessionClock(b.clock.Now))
+	}
+	if b.values == nil {
+		b.values = core.NewValues()
+	}
+	b.processor = core.NewProcessor(engine, core.ProcessWithMiddleware(b.middlewares...))
+
+	b.chatCtx = core.NewChatContext(
+		b.events,
+		core.WithSessionAdapter(b.sessionAdapter),
+		core.WithClock(b.clock.Now),
+		core.WithValues(b.values),
+	)
+	t.Cleanup(b.chatCtx.Shutdown)
+
+	return b
+}
+
+// Reply is what the engine did with a message.
+type Reply struct {
+	// Messages are the replies sent, in order.
+	Messages []message.Message
+	// Events are the events emitted while handling the message.
+	Events []core.Event
+	// Session is the session of the user once the message was handled.
+	Session core.Session
+}
+
+// State returns the session state the message left the user in.
+func (r Reply) State() core.SessionState {
+	return r.Session.State
+}
+
+// Texts returns the text of every reply.
+func (r Reply) Texts() []string {
+	texts := make([]string, 0, len(r.Messages))
+	for _, msg := range r.Messages {
+		texts = append(texts, msg.Output)
+	}
+	return texts
+}
+
+// Options returns the ID of every option offered by the replies.
+func (r Reply) Options() []string {
+	var ids []string
+	for _, msg := range r.Messages {
+		for _, opt := range msg.Options {
+			ids = append(ids, opt.ID)
+		}
+	}
+	return ids
+}
+
+// Clock returns the clock driving the sessions.
+func (b *Bot) Clock() *Clock {
+	return b.clock
+}
+
+// ChatContext returns the root context the messages are handled under.
+func (b *Bot) ChatContext() *core.ChatContext {
+	return b.chatCtx
+}
+
+// Say sends input to the engine as userID and returns once it is handled.
+func (b *Bot) Say(userID, input string) Reply {
+	b.t.Helper()
+
+	return b.Send(message.Message{
+		Input:     input,
+		ChannelID: userID,
+		User:      message.User{ID: userID},
+	})
+}
+
+// Send hands msg to the engine and returns once it is handled. The message
+// gets an ID and the bot's connector when it has none. It is handled as the
+// processor of a running bot does, so a panic of the engine is reported as an
+// event instead of failing the test.
+func (b *Bot) Send(msg message.Message) Reply {
+	b.t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	if msg.ID == "" {
+		msg.ID = fmt.Sprintf("msg-%d", b.seq)
+	}
+	if msg.Connector == "" {
+		msg.Connector = b.connector
+	}
+
+	output := make(chan message.Message)
+	collected := make(chan []message.Message)
+	go func() {
+		var msgs []message.Message
+		for out := range output {
+			msgs = append(msgs, out)
+		}
+		collected <- msgs
+	}()
+
+	handled, err := b.processor.Handle(b.chatCtx, msg, output)
+	close(output)
+	msgs := <-collected
+	if err != nil {
+		b.t.Fatalf("load session of %s: %v", msg.User.ID, err)
+		return Reply{}
+	}
+
+	reply := Reply{Messages: msgs, Session: handled.Session}
+	for {
+		select {
+		case event := <-b.events:
+			reply.Events = append(reply.Events, event)
+		default:
+			return reply
+		}
+	}
+}
+
+// Session returns the current session of userID, creating it if needed.
+func (b *Bot) Session(userID string) core.Session {
+	b.t.Helper()
+
+	key := core.SessionKey(message.Message{Connector: b.connector, User: message.User{ID: userID}})
+	sess, err := b.sessionAdapter.GetOrCreate(b.chatCtx.Context(), key)
+	if err != nil {
+		b.t.Fatalf("load session of %s: %v", userID, err)
+		return core.Session{}
+	}
+	return *sess
+}
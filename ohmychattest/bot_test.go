@@ -0,0 +1,165 @@
+package ohmychattest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func orderEngine() *rule_engine.RuleEngine {
+	engine := rule_engine.NewRuleEngine(rule_engine.WithSessionExpiresAt(5 * time.Minute))
+	engine.RegisterRule(rule_engine.Rule{
+		Prompts: []string{"fazer pedido"},
+		Action: func(ctx *core.Context, msg *message.Message) {
+			msg.Output = "Qual sabor?"
+			msg.Options = []message.Option{{ID: "calabresa", Name: "Calabresa"}, {ID: "mussarela", Name: "Mussarela"}}
+			ctx.SendOutput(msg)
+		},
+		NextState: core.WaitingChoiceState{
+			PromptInvalidOption: "sabor inválido",
+			Choices: core.Choices{}.BindMany(func(ctx *core.Context, msg *message.Message) {
+				msg.Output = "pedido de " + msg.Input + " anotado"
+				ctx.SendOutput(msg)
+			}, "calabresa", "mussarela"),
+		},
+	})
+	return engine
+}
+
+func TestBot_Say(t *testing.T) {
+	t.Parallel()
+
+	t.Run("returns replies, options and state", func(t *testing.T) {
+		t.Parallel()
+
+		bot := ohmychattest.New(t, orderEngine())
+
+		reply := bot.Say("luffy", "fazer pedido")
+		assert.Equal(t, []string{"Qual sabor?"}, reply.Texts())
+		assert.Equal(t, []string{"calabresa", "mussarela"}, reply.Options())
+		assert.IsType(t, core.WaitingChoiceState{}, reply.State())
+		assert.Equal(t, message.Test, reply.Messages[0].Connector)
+
+		reply = bot.Say("luffy", "calabresa")
+		assert.Equal(t, []string{"pedido de calabresa anotado"}, reply.Texts())
+		assert.IsType(t, core.IdleState{}, reply.State())
+		assert.IsType(t, core.IdleState{}, bot.Session("luffy").State)
+	})
+
+	t.Run("keeps users apart", func(t *testing.T) {
+		t.Parallel()
+
+		bot := ohmychattest.New(t, orderEngine())
+
+		bot.Say("zoro", "fazer pedido")
+		assert.Equal(t, []string{"desculpe não entendi"}, bot.Say("nami", "calabresa").Texts())
+		assert.Equal(t, []string{"pedido de calabresa anotado"}, bot.Say("zoro", "calabresa").Texts())
+	})
+
+	t.Run("expires sessions with the fake clock", func(t *testing.T) {
+		t.Parallel()
+
+		start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		bot := ohmychattest.New(t, orderEngine(), ohmychattest.WithClock(ohmychattest.NewClock(start)))
+
+		bot.Say("sanji", "fazer pedido")
+
+		bot.Clock().Advance(6 * time.Minute)
+		sess := bot.Session("sanji")
+		assert.Equal(t, start, sess.LastActivityAt)
+
+		reply := bot.Say("sanji", "calabresa")
+		assert.Equal(t, []string{"desculpe não entendi"}, reply.Texts())
+		assert.Equal(t, start.Add(6*time.Minute), reply.Session.LastActivityAt)
+	})
+
+	t.Run("saves sessions left without reply", func(t *testing.T) {
+		t.Parallel()
+
+		bot := ohmychattest.New(t, core.EngineFunc(func(ctx *core.Context, _ *message.Message) {
+			ctx.SetSessionState(core.WaitingInputState{})
+		}))
+
+		reply := bot.Say("usopp", "oi")
+		assert.Empty(t, reply.Messages)
+		assert.IsType(t, core.WaitingInputState{}, bot.Session("usopp").State)
+	})
+
+	t.Run("wraps the engine with middlewares", func(t *testing.T) {
+		t.Parallel()
+
+		var order []string
+		trace := func(name string) core.Middleware {
+			return func(next core.Engine) core.Engine {
+				return core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+					order = append(order, name)
+					next.HandleMessage(ctx, msg)
+				})
+			}
+		}
+
+		bot := ohmychattest.New(t, orderEngine(), ohmychattest.WithMiddleware(trace("outer"), trace("inner")))
+		bot.Say("robin", "fazer pedido")
+		assert.Equal(t, []string{"outer", "inner"}, order)
+	})
+
+	t.Run("collects the events of the message", func(t *testing.T) {
+		t.Parallel()
+
+		boom := errors.New("boom")
+		bot := ohmychattest.New(t, core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SendEvent(core.NewEventErrorWithMessage(*msg, boom))
+		}), ohmychattest.WithConnector(message.Telegram))
+
+		reply := bot.Say("franky", "oi")
+		if assert.Len(t, reply.Events, 1) {
+			assert.ErrorIs(t, reply.Events[0].Error, boom)
+			assert.Equal(t, message.Telegram, reply.Events[0].Msg.Connector)
+		}
+		assert.Empty(t, bot.Say("franky", "oi de novo").Messages)
+	})
+
+	t.Run("reports a panic of the engine and resets the session", func(t *testing.T) {
+		t.Parallel()
+
+		bot := ohmychattest.New(t, core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SetSessionState(core.WaitingInputState{})
+			panic("boom")
+		}))
+
+		reply := bot.Say("brook", "oi")
+		assert.IsType(t, core.IdleState{}, reply.State())
+		if assert.Len(t, reply.Events, 1) {
+			assert.Equal(t, core.EventPanic, reply.Events[0].Type)
+		}
+	})
+}
+
+func TestBot_Values(t *testing.T) {
+	t.Parallel()
+
+	type catalog interface {
+		Price(flavor string) int
+	}
+
+	values := core.NewValues()
+	core.Provide[catalog](values, fakeCatalog{"calabresa": 40})
+	bot := ohmychattest.New(t, core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+		msg.Output = fmt.Sprintf("R$ %d", core.MustResolve[catalog](ctx).Price(msg.Input))
+		ctx.SendOutput(msg)
+	}), ohmychattest.WithValues(values))
+
+	assert.Equal(t, []string{"R$ 40"}, bot.Say("usopp", "calabresa").Texts())
+}
+
+type fakeCatalog map[string]int
+
+func (c fakeCatalog) Price(flavor string) int { return c[flavor] }
@@ -0,0 +1,112 @@
+package ohmychattest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Connector is an in-memory core.Connector for tests running the whole
+// pipeline through ohmychat.Run: Say feeds it messages and Next returns what
+// was dispatched.
+type Connector struct {
+	kind  message.MessageConnector
+	inbox chan message.Message
+
+	mu         sync.Mutex
+	dispatched []message.Message
+	notify     chan struct{}
+}
+
+// NewConnector returns a connector of the given kind.
+func NewConnector(kind message.MessageConnector) *Connector {
+	return &Connector{
+		kind:   kind,
+		inbox:  make(chan message.Message),
+		notify: make(chan struct{}),
+	}
+}
+
+func (c *Connector) Kind() message.MessageConnector {
+	return c.kind
+}
+
+func (c *Connector) Acquire(ctx *core.ChatContext, input chan<- message.Message) error {
+	for {
+		select {
+		case msg := <-c.inbox:
+			select {
+			case input <- msg:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *Connector) Dispatch(msg message.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dispatched = append(c.dispatched, msg)
+	close(c.notify)
+	c.notify = make(chan struct{})
+	return nil
+}
+
+// Say sends input as userID, waiting until the pipeline takes it.
+func (c *Connector) Say(ctx context.Context, userID, input string) error {
+	return c.Send(ctx, message.Message{
+		Input:     input,
+		ChannelID: userID,
+		User:      message.User{ID: userID},
+	})
+}
+
+// Send hands msg to the pipeline, waiting until it takes it. The message gets
+// an ID and the connector kind when it has none.
+func (c *Connector) Send(ctx context.Context, msg message.Message) error {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	if msg.Connector == "" {
+		msg.Connector = c.kind
+	}
+	core.StartTrace(&msg)
+
+	select {
+	case c.inbox <- msg:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("send %q: %w", msg.Input, ctx.Err())
+	}
+}
+
+// Next returns the oldest dispatched message not returned yet, waiting for
+// one until ctx is done.
+func (c *Connector) Next(ctx context.Context) (message.Message, error) {
+	for {
+		c.mu.Lock()
+		if len(c.dispatched) > 0 {
+			msg := c.dispatched[0]
+			c.dispatched = c.dispatched[1:]
+			c.mu.Unlock()
+			return msg, nil
+		}
+		notify := c.notify
+		c.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return message.Message{}, ctx.Err()
+		}
+	}
+}
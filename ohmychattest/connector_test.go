@@ -0,0 +1,47 @@
+package ohmychattest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnector(t *testing.T) {
+	t.Parallel()
+
+	conn := ohmychattest.NewConnector(message.Test)
+	bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(orderEngine()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- bot.Run(ctx) }()
+
+	waitCtx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+
+	assert.NoError(t, conn.Say(waitCtx, "jinbe", "fazer pedido"))
+	reply, err := conn.Next(waitCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Qual sabor?", reply.Output)
+	assert.Equal(t, "jinbe", reply.User.ID)
+	assert.Equal(t, message.Test, reply.Connector)
+
+	assert.NoError(t, conn.Say(waitCtx, "jinbe", "mussarela"))
+	reply, err = conn.Next(waitCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, "pedido de mussarela anotado", reply.Output)
+
+	cancel()
+	assert.NoError(t, <-runErr)
+
+	expired, stopExpired := context.WithCancel(context.Background())
+	stopExpired()
+	_, err = conn.Next(expired)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,69 @@
+package ohmychattest
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+// Step is a turn of a scripted conversation. Expectations left empty are not
+// checked.
+type Step struct {
+	// After advances the clock before the message is sent.
+	After time.Duration
+	// Say is the user input.
+	Say string
+	// Want are the texts the engine must reply, in order.
+	Want []string
+	// WantOptions are the IDs of the options the replies must offer.
+	WantOptions []string
+	// WantState is the state the session must be left in. Only its type is
+	// compared, as states hold actions.
+	WantState core.SessionState
+}
+
+// Script plays steps as userID, reporting every unmet expectation, and
+// returns the replies.
+func (b *Bot) Script(userID string, steps ...Step) []Reply {
+	b.t.Helper()
+
+	replies := make([]Reply, 0, len(steps))
+	for i, step := range steps {
+		if step.After > 0 {
+			b.clock.Advance(step.After)
+		}
+		reply := b.Say(userID, step.Say)
+		assertReply(b.t, fmt.Sprintf("step %d %q", i+1, step.Say), step, reply)
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+// AssertReply reports whether reply meets the expectations of step, failing
+// t otherwise. Say only names the turn in the failures.
+func AssertReply(t testing.TB, step Step, reply Reply) bool {
+	t.Helper()
+	return assertReply(t, fmt.Sprintf("%q", step.Say), step, reply)
+}
+
+func assertReply(t testing.TB, turn string, step Step, reply Reply) bool {
+	t.Helper()
+
+	ok := true
+	if step.Want != nil && !slices.Equal(step.Want, reply.Texts()) {
+		t.Errorf("replies to %s\n  want: %q\n  got:  %q", turn, step.Want, reply.Texts())
+		ok = false
+	}
+	if step.WantOptions != nil && !slices.Equal(step.WantOptions, reply.Options()) {
+		t.Errorf("options after %s\n  want: %q\n  got:  %q", turn, step.WantOptions, reply.Options())
+		ok = false
+	}
+	if step.WantState != nil && core.StateName(step.WantState) != core.StateName(reply.State()) {
+		t.Errorf("state after %s\n  want: %s\n  got:  %s", turn, core.StateName(step.WantState), core.StateName(reply.State()))
+		ok = false
+	}
+	return ok
+}
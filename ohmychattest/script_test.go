@@ -0,0 +1,89 @@
+package ohmychattest_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder captures the failures reported to it.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestBot_Script(t *testing.T) {
+	t.Parallel()
+
+	bot := ohmychattest.New(t, orderEngine())
+
+	replies := bot.Script("chopper",
+		ohmychattest.Step{
+			Say:         "fazer pedido",
+			Want:        []string{"Qual sabor?"},
+			WantOptions: []string{"calabresa", "mussarela"},
+			WantState:   core.WaitingChoiceState{},
+		},
+		ohmychattest.Step{
+			Say:       "portuguesa",
+			Want:      []string{"sabor inválido"},
+			WantState: core.WaitingChoiceState{},
+		},
+		ohmychattest.Step{
+			After:     10 * time.Minute,
+			Say:       "mussarela",
+			Want:      []string{"desculpe não entendi"},
+			WantState: core.IdleState{},
+		},
+	)
+	assert.Len(t, replies, 3)
+}
+
+func TestAssertReply(t *testing.T) {
+	t.Parallel()
+
+	bot := ohmychattest.New(t, orderEngine())
+	reply := bot.Say("brook", "fazer pedido")
+
+	t.Run("passes when expectations are met", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &recorder{TB: t}
+		ok := ohmychattest.AssertReply(rec, ohmychattest.Step{
+			Say:       "fazer pedido",
+			Want:      []string{"Qual sabor?"},
+			WantState: core.WaitingChoiceState{},
+		}, reply)
+		assert.True(t, ok)
+		assert.Empty(t, rec.errors)
+	})
+
+	t.Run("reports every unmet expectation", func(t *testing.T) {
+		t.Parallel()
+
+		rec := &recorder{TB: t}
+		ok := ohmychattest.AssertReply(rec, ohmychattest.Step{
+			Say:         "fazer pedido",
+			Want:        []string{"Olá"},
+			WantOptions: []string{},
+			WantState:   core.IdleState{},
+		}, reply)
+		assert.False(t, ok)
+		if assert.Len(t, rec.errors, 3) {
+			assert.Contains(t, rec.errors[0], `replies to "fazer pedido"`)
+			assert.Contains(t, rec.errors[1], `options after "fazer pedido"`)
+			assert.Contains(t, rec.errors[2], "want: core.IdleState")
+		}
+	})
+}
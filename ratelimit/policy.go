@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy decides whether one more message may go through for a key.
+type Policy interface {
+	// Allow reports whether a message for key may proceed at now and, when it
+	// may not, how long to wait before it would be allowed.
+	Allow(key string, now time.Time) (bool, time.Duration)
+}
+
+// pruneEvery bounds how often idle keys are swept from a policy.
+const pruneEvery = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type tokenBucket struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// NewTokenBucket allows limit messages per period on average, with bursts of
+// up to burst messages. A burst lower than one is raised to one.
+func NewTokenBucket(limit int, per time.Duration, burst int) Policy {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:    float64(limit) / per.Seconds(),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (p *tokenBucket) Allow(key string, now time.Time) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(now)
+
+	b, ok := p.buckets[key]
+	if !ok {
+		b = &bucket{tokens: p.burst, last: now}
+		p.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(p.burst, b.tokens+elapsed.Seconds()*p.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if p.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / p.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune drops the buckets that have refilled completely.
+func (p *tokenBucket) prune(now time.Time) {
+	if now.Sub(p.lastPrune) < pruneEvery {
+		return
+	}
+	p.lastPrune = now
+
+	for key, b := range p.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*p.rate >= p.burst {
+			delete(p.buckets, key)
+		}
+	}
+}
+
+type slidingWindow struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastPrune time.Time
+}
+
+// NewSlidingWindow allows at most limit messages within any window.
+func NewSlidingWindow(limit int, window time.Duration) Policy {
+	return &slidingWindow{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+func (p *slidingWindow) Allow(key string, now time.Time) (bool, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune(now)
+
+	hits := p.expire(p.hits[key], now)
+	if len(hits) < p.limit {
+		p.hits[key] = append(hits, now)
+		return true, 0
+	}
+
+	p.hits[key] = hits
+	if len(hits) == 0 {
+		return false, p.window
+	}
+	return false, hits[0].Add(p.window).Sub(now)
+}
+
+func (p *slidingWindow) expire(hits []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-p.window)
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
+
+// prune drops the keys without hits inside the window.
+func (p *slidingWindow) prune(now time.Time) {
+	if now.Sub(p.lastPrune) < pruneEvery {
+		return
+	}
+	p.lastPrune = now
+
+	for key, hits := range p.hits {
+		if len(p.expire(hits, now)) == 0 {
+			delete(p.hits, key)
+		}
+	}
+}
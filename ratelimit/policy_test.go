@@ -0,0 +1,74 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	t.Run("allows bursts and refills over time", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Unix(0, 0)
+		policy := ratelimit.NewTokenBucket(1, time.Second, 3)
+
+		for range 3 {
+			ok, _ := policy.Allow("luffy", now)
+			assert.True(t, ok)
+		}
+
+		ok, wait := policy.Allow("luffy", now)
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, wait)
+
+		ok, _ = policy.Allow("luffy", now.Add(time.Second))
+		assert.True(t, ok)
+	})
+
+	t.Run("keeps keys apart", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Unix(0, 0)
+		policy := ratelimit.NewTokenBucket(1, time.Minute, 1)
+
+		ok, _ := policy.Allow("luffy", now)
+		assert.True(t, ok)
+		ok, _ = policy.Allow("zoro", now)
+		assert.True(t, ok)
+		ok, _ = policy.Allow("luffy", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	t.Run("limits hits within the window", func(t *testing.T) {
+		t.Parallel()
+
+		start := time.Unix(0, 0)
+		policy := ratelimit.NewSlidingWindow(2, 10*time.Second)
+
+		ok, _ := policy.Allow("nami", start)
+		assert.True(t, ok)
+		ok, _ = policy.Allow("nami", start.Add(4*time.Second))
+		assert.True(t, ok)
+
+		ok, wait := policy.Allow("nami", start.Add(5*time.Second))
+		assert.False(t, ok)
+		assert.Equal(t, 5*time.Second, wait)
+
+		ok, _ = policy.Allow("nami", start.Add(10*time.Second))
+		assert.True(t, ok)
+
+		ok, wait = policy.Allow("nami", start.Add(11*time.Second))
+		assert.False(t, ok)
+		assert.Equal(t, 3*time.Second, wait)
+	})
+}
@@ -0,0 +1,158 @@
+// Package ratelimit protects the pipeline from users flooding the bot.
+//
+// The limiter is a core.Gate: the processor applies it before queueing a
+// message for the worker owning its session, so a limited message never
+// holds a worker, a session lock or a session save, and a delayed one waits
+// on a timer.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// DeadlineMeta is the metadata entry holding when a delayed message is given
+// up on, in RFC 3339 format.
+const DeadlineMeta = "ratelimit_deadline"
+
+// KeyFunc selects what a limit is applied to.
+type KeyFunc func(msg message.Message) string
+
+func ByUser(msg message.Message) string {
+	return string(msg.Connector) + ":" + msg.User.ID
+}
+
+func ByChannel(msg message.Message) string {
+	return string(msg.Connector) + ":" + msg.ChannelID
+}
+
+func ByConnector(msg message.Message) string {
+	return string(msg.Connector)
+}
+
+// Action is what happens to a message exceeding the limit.
+type Action uint8
+
+const (
+	// Drop discards the message.
+	Drop Action = iota
+	// Delay retries the message once the policy allows it, dropping it
+	// past the max delay.
+	Delay
+	// Reply answers the message with the configured reply.
+	Reply
+)
+
+type Option func(l *limiter)
+
+func WithKey(key KeyFunc) Option {
+	return func(l *limiter) {
+		l.key = key
+	}
+}
+
+func WithAction(action Action) Option {
+	return func(l *limiter) {
+		l.action = action
+	}
+}
+
+// WithReply answers limited messages with reply, e.g. "slow down".
+func WithReply(reply string) Option {
+	return func(l *limiter) {
+		l.action = Reply
+		l.reply = reply
+	}
+}
+
+// WithMaxDelay bounds how long a delayed message may wait before it is
+// dropped, 5 seconds by default.
+func WithMaxDelay(d time.Duration) Option {
+	return func(l *limiter) {
+		l.maxDelay = d
+	}
+}
+
+func WithClock(clock core.Clock) Option {
+	return func(l *limiter) {
+		l.clock = clock
+	}
+}
+
+type limiter struct {
+	policy   Policy
+	key      KeyFunc
+	action   Action
+	reply    string
+	maxDelay time.Duration
+	clock    core.Clock
+}
+
+// Gate limits the messages reaching the processor workers according to
+// policy. A message is only counted once: every limit hit emits a
+// core.EventRateLimited event, but not the retries of a delayed message. By
+// default limits are applied per user and excess messages are dropped.
+func Gate(policy Policy, opts ...Option) core.Gate {
+	l := &limiter{
+		policy:   policy,
+		key:      ByUser,
+		action:   Drop,
+		maxDelay: 5 * time.Second,
+		clock:    core.SystemClock,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l.admit
+}
+
+func (l *limiter) admit(ctx *core.ChatContext, msg *message.Message) core.Verdict {
+	key := l.key(*msg)
+	now := l.clock.Now()
+
+	ok, wait := l.policy.Allow(key, now)
+	if ok {
+		return core.Verdict{Action: core.Admit}
+	}
+
+	deadline, delayed := l.deadline(*msg)
+	if !delayed {
+		ctx.SendEvent(core.NewEventRateLimited(*msg, fmt.Errorf("%w for %q, retry in %s", ErrRateLimited, key, wait)))
+	}
+
+	switch l.action {
+	case Delay:
+		if !delayed {
+			deadline = now.Add(l.maxDelay)
+			msg.SetMeta(DeadlineMeta, deadline.Format(time.RFC3339Nano))
+		}
+		if now.Add(wait).After(deadline) {
+			return core.Verdict{Action: core.Reject}
+		}
+		return core.Verdict{Action: core.Retry, After: wait}
+	case Reply:
+		return core.Verdict{Action: core.Reject, Reply: l.reply}
+	}
+	return core.Verdict{Action: core.Reject}
+}
+
+// deadline returns when the delayed msg is given up on, and whether it was
+// delayed already.
+func (l *limiter) deadline(msg message.Message) (time.Time, bool) {
+	if msg.Meta == nil {
+		return time.Time{}, false
+	}
+	value, ok := msg.Meta.Data[DeadlineMeta]
+	if !ok {
+		return time.Time{}, false
+	}
+	deadline, err := time.Parse(time.RFC3339Nano, value)
+	return deadline, err == nil
+}
@@ -0,0 +1,156 @@
+package ratelimit_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type harness struct {
+	gate    core.Gate
+	chatCtx *core.ChatContext
+	events  chan core.Event
+}
+
+func newHarness(t *testing.T, gate core.Gate) *harness {
+	t.Helper()
+
+	h := &harness{gate: gate, events: make(chan core.Event, 10)}
+	h.chatCtx = core.NewChatContext(h.events)
+	t.Cleanup(h.chatCtx.Shutdown)
+	return h
+}
+
+func (h *harness) send(userID, input string) core.Verdict {
+	msg := message.Message{User: message.User{ID: userID}, Input: input, Connector: message.Telegram}
+	return h.gate(h.chatCtx, &msg)
+}
+
+var admitted = core.Verdict{Action: core.Admit}
+
+func TestGate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("drops excess messages and emits an event", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		h := newHarness(t, ratelimit.Gate(
+			ratelimit.NewSlidingWindow(1, time.Minute),
+			ratelimit.WithClock(clock),
+		))
+
+		assert.Equal(t, admitted, h.send("luffy", "1"))
+		assert.Equal(t, core.Verdict{Action: core.Reject}, h.send("luffy", "2"))
+		assert.Equal(t, admitted, h.send("zoro", "3"))
+
+		evt := <-h.events
+		assert.Equal(t, core.EventRateLimited, evt.Type)
+		assert.ErrorIs(t, evt.Error, ratelimit.ErrRateLimited)
+		assert.Equal(t, "2", evt.Msg.Input)
+		assert.Empty(t, h.events)
+	})
+
+	t.Run("answers excess messages with a reply", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		h := newHarness(t, ratelimit.Gate(
+			ratelimit.NewTokenBucket(1, time.Minute, 1),
+			ratelimit.WithClock(clock),
+			ratelimit.WithReply("calma!"),
+		))
+
+		assert.Equal(t, admitted, h.send("luffy", "1"))
+		assert.Equal(t, core.Verdict{Action: core.Reject, Reply: "calma!"}, h.send("luffy", "2"))
+		assert.Equal(t, core.EventRateLimited, (<-h.events).Type)
+	})
+
+	t.Run("limits a whole channel", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		h := newHarness(t, ratelimit.Gate(
+			ratelimit.NewSlidingWindow(1, time.Minute),
+			ratelimit.WithClock(clock),
+			ratelimit.WithKey(ratelimit.ByConnector),
+		))
+
+		assert.Equal(t, admitted, h.send("luffy", "1"))
+		assert.Equal(t, core.Reject, h.send("zoro", "2").Action)
+	})
+
+	t.Run("retries delayed messages until allowed", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		h := newHarness(t, ratelimit.Gate(
+			ratelimit.NewSlidingWindow(1, 10*time.Second),
+			ratelimit.WithClock(clock),
+			ratelimit.WithAction(ratelimit.Delay),
+			ratelimit.WithMaxDelay(time.Minute),
+		))
+
+		assert.Equal(t, admitted, h.send("luffy", "1"))
+
+		msg := message.Message{User: message.User{ID: "luffy"}, Input: "2", Connector: message.Telegram}
+		assert.Equal(t, core.Verdict{Action: core.Retry, After: 10 * time.Second}, h.gate(h.chatCtx, &msg))
+		assert.Contains(t, msg.Meta.Data, ratelimit.DeadlineMeta)
+
+		clock.Advance(5 * time.Second)
+		assert.Equal(t, core.Verdict{Action: core.Retry, After: 5 * time.Second}, h.gate(h.chatCtx, &msg))
+
+		clock.Advance(5 * time.Second)
+		assert.Equal(t, admitted, h.gate(h.chatCtx, &msg))
+
+		assert.Equal(t, core.EventRateLimited, (<-h.events).Type)
+		assert.Empty(t, h.events)
+	})
+
+	t.Run("drops delayed messages beyond the max delay", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		h := newHarness(t, ratelimit.Gate(
+			ratelimit.NewSlidingWindow(1, time.Hour),
+			ratelimit.WithClock(clock),
+			ratelimit.WithAction(ratelimit.Delay),
+			ratelimit.WithMaxDelay(time.Second),
+		))
+
+		assert.Equal(t, admitted, h.send("luffy", "1"))
+		assert.Equal(t, core.Reject, h.send("luffy", "2").Action)
+		assert.Equal(t, core.EventRateLimited, (<-h.events).Type)
+	})
+}
@@ -0,0 +1,97 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+// Main runs the replay command for engine and exits. A bot gets a replay
+// command by calling it from a main package building its engine:
+//
+//	func main() {
+//		replay.Main(newEngine())
+//	}
+func Main(engine core.Engine) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := Run(ctx, engine, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// Run replays the conversations given by args through engine and prints the
+// diff to stdout. It returns 0 when the engine answers as recorded, 1 when it
+// does not and 2 on errors.
+func Run(ctx context.Context, engine core.Engine, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	transcriptPath := flags.String("transcript", "", "JSON lines transcript to replay")
+	goldenPath := flags.String("golden", "", "golden file to replay")
+	user := flags.String("user", "", "replay only the conversations of this user, on every connector")
+	update := flags.String("update", "", "write what the engine answered to this golden file")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	convs, err := load(*transcriptPath, *goldenPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		flags.Usage()
+		return 2
+	}
+
+	if *user != "" {
+		var selected []Conversation
+		for _, c := range convs {
+			if c.UserID == *user {
+				selected = append(selected, c)
+			}
+		}
+		convs = selected
+	}
+
+	results, err := Replay(ctx, engine, convs)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if *update != "" {
+		got := make([]Conversation, 0, len(results))
+		for _, r := range results {
+			got = append(got, r.Got)
+		}
+		if err := WriteGolden(*update, got); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	diff := Diff(results)
+	if diff == "" {
+		fmt.Fprintf(stdout, "%d conversations replayed, no differences\n", len(results))
+		return 0
+	}
+	fmt.Fprint(stdout, diff)
+	return 1
+}
+
+func load(transcriptPath, goldenPath string) ([]Conversation, error) {
+	switch {
+	case transcriptPath != "" && goldenPath != "":
+		return nil, errors.New("use either -transcript or -golden")
+	case transcriptPath != "":
+		return LoadTranscript(transcriptPath)
+	case goldenPath != "":
+		return LoadGolden(goldenPath)
+	}
+	return nil, errors.New("missing -transcript or -golden")
+}
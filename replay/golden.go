@@ -0,0 +1,59 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+// LoadTranscript reads the conversations of a JSON lines transcript, such as
+// the one written by transcript.FileStore.
+func LoadTranscript(path string) ([]Conversation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []core.TranscriptEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e core.TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return Conversations(entries), nil
+}
+
+// LoadGolden reads conversations written by WriteGolden.
+func LoadGolden(path string) ([]Conversation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var convs []Conversation
+	if err := json.Unmarshal(data, &convs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return convs, nil
+}
+
+// WriteGolden writes convs as indented JSON, meant to be reviewed and kept
+// under version control.
+func WriteGolden(path string, convs []Conversation) error {
+	data, err := json.MarshalIndent(convs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,313 @@
+// Package replay feeds recorded conversations through an engine and reports
+// where its answers differ from the recorded ones.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Output is a message answered by the bot.
+type Output struct {
+	Text    string   `json:"text"`
+	Options []string `json:"options,omitempty"`
+}
+
+// Turn is a user input and what the bot did about it.
+type Turn struct {
+	MessageID string `json:"message_id"`
+	// Time is when the input was received. Replay handles the input at that
+	// time, so session expiry plays out as recorded.
+	Time        time.Time `json:"time"`
+	Input       string    `json:"input"`
+	Outputs     []Output  `json:"outputs,omitempty"`
+	StateBefore string    `json:"state_before"`
+	StateAfter  string    `json:"state_after"`
+}
+
+// Conversation holds the turns of one user on one connector, in order.
+type Conversation struct {
+	UserID    string                   `json:"user_id"`
+	ChannelID string                   `json:"channel_id"`
+	Connector message.MessageConnector `json:"connector"`
+	Turns     []Turn                   `json:"turns"`
+}
+
+// Conversations groups transcript entries by session, see core.SessionKey, so
+// the same user ID on two connectors makes two conversations. An outbound
+// entry belongs to the inbound one of its session with the same message ID,
+// or else to the latest inbound entry of its session before it. Outbound
+// entries sent before the first input of a session, such as proactive
+// messages, are left out, and so are the failed dispatch attempts of a reply.
+func Conversations(entries []core.TranscriptEntry) []Conversation {
+	entries = core.TranscriptQuery{}.Apply(slices.Clone(entries))
+
+	bySession := make(map[string]*Conversation)
+	var order []string
+
+	for _, e := range entries {
+		if e.Direction != core.Inbound {
+			continue
+		}
+		key := sessionKey(e)
+		conv, ok := bySession[key]
+		if !ok {
+			conv = &Conversation{UserID: e.UserID, ChannelID: e.ChannelID, Connector: e.Connector}
+			bySession[key] = conv
+			order = append(order, key)
+		}
+		conv.Turns = append(conv.Turns, Turn{
+			MessageID:   e.MessageID,
+			Time:        e.Time,
+			Input:       e.Text,
+			StateBefore: e.StateBefore,
+			StateAfter:  e.StateAfter,
+		})
+	}
+
+	for _, e := range entries {
+		if e.Direction != core.Outbound || e.Error != "" {
+			continue
+		}
+		conv, ok := bySession[sessionKey(e)]
+		if !ok {
+			continue
+		}
+
+		turn := slices.IndexFunc(conv.Turns, func(t Turn) bool { return t.MessageID == e.MessageID })
+		if turn < 0 {
+			for i := len(conv.Turns) - 1; i >= 0; i-- {
+				if !conv.Turns[i].Time.After(e.Time) {
+					turn = i
+					break
+				}
+			}
+		}
+		if turn < 0 {
+			continue
+		}
+		conv.Turns[turn].Outputs = append(conv.Turns[turn].Outputs, Output{Text: e.Text, Options: e.Options})
+	}
+
+	convs := make([]Conversation, 0, len(order))
+	for _, key := range order {
+		convs = append(convs, *bySession[key])
+	}
+	sort.SliceStable(convs, func(i, j int) bool {
+		if convs[i].UserID == convs[j].UserID {
+			return convs[i].Connector < convs[j].Connector
+		}
+		return convs[i].UserID < convs[j].UserID
+	})
+	return convs
+}
+
+// sessionKey returns the key of the session e was recorded in.
+func sessionKey(e core.TranscriptEntry) string {
+	return core.SessionKey(message.Message{Connector: e.Connector, User: message.User{ID: e.UserID}})
+}
+
+type Option func(r *replayer)
+
+// WithSessionAdapter replays through adapter instead of a fresh in-memory
+// one. It must hold no session of the replayed users, and should stamp the
+// sessions it creates with the time of the turn being replayed.
+func WithSessionAdapter(adapter core.SessionAdapter) Option {
+	return func(r *replayer) {
+		r.sessionAdapter = adapter
+	}
+}
+
+type replayer struct {
+	sessionAdapter core.SessionAdapter
+}
+
+// Result is a recorded conversation and the one the engine produced when fed
+// the same inputs.
+type Result struct {
+	Want Conversation
+	Got  Conversation
+}
+
+// Replay feeds the inputs of every conversation through engine, one
+// conversation after the other and in order, and returns what the engine
+// answered.
+func Replay(ctx context.Context, engine core.Engine, convs []Conversation, opts ...Option) ([]Result, error) {
+	r := &replayer{}
+	for _, opt := range opts {
+		opt(r)
+	}
+	clock := &turnClock{}
+	if r.sessionAdapter == nil {
+		r.sessionAdapter = core.NewInMemorySessionRepo(core.WithSessionClock(clock.Now))
+	}
+
+	// the events of a turn are drained once it is replayed
+	events := make(chan core.Event, 64)
+	chatCtx := core.NewChatContext(
+		events,
+		core.WithSessionAdapter(r.sessionAdapter),
+		core.WithClock(clock.Now),
+	)
+	defer chatCtx.Shutdown()
+	processor := core.NewProcessor(engine)
+
+	results := make([]Result, 0, len(convs))
+	for _, want := range convs {
+		got := Conversation{UserID: want.UserID, ChannelID: want.ChannelID, Connector: want.Connector}
+		for _, turn := range want.Turns {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			clock.at = turn.Time
+			t, err := replayTurn(chatCtx, processor, events, want, turn)
+			if err != nil {
+				return nil, fmt.Errorf("replay %s on %s turn %s: %w", want.UserID, want.Connector, turn.MessageID, err)
+			}
+			got.Turns = append(got.Turns, t)
+		}
+		results = append(results, Result{Want: want, Got: got})
+	}
+	return results, nil
+}
+
+func replayTurn(chatCtx *core.ChatContext, p core.MessageHandler, events <-chan core.Event, conv Conversation, turn Turn) (Turn, error) {
+	msg := message.Message{
+		ID:        turn.MessageID,
+		Connector: conv.Connector,
+		ChannelID: conv.ChannelID,
+		Input:     turn.Input,
+		User:      message.User{ID: conv.UserID},
+	}
+
+	// every reply of the engine is collected, up to the capacity
+	output := make(chan message.Message, 64)
+	handled, err := p.Handle(chatCtx, msg, output)
+	if err != nil {
+		return Turn{}, err
+	}
+
+	got := Turn{
+		MessageID:   turn.MessageID,
+		Time:        turn.Time,
+		Input:       turn.Input,
+		StateBefore: core.StateName(handled.StateBefore),
+		StateAfter:  core.StateName(handled.Session.State),
+	}
+	got.Outputs = append(got.Outputs, panics(events)...)
+
+	close(output)
+	for out := range output {
+		o := Output{Text: out.Output}
+		for _, opt := range out.Options {
+			o.Options = append(o.Options, opt.ID)
+		}
+		got.Outputs = append(got.Outputs, o)
+	}
+	return got, nil
+}
+
+// turnClock tells the time of the turn being replayed, or the current time for
+// turns recorded without one.
+type turnClock struct {
+	at time.Time
+}
+
+func (c *turnClock) Now() time.Time {
+	if c.at.IsZero() {
+		return time.Now()
+	}
+	return c.at
+}
+
+// panics describes the panics reported among the pending events.
+func panics(events <-chan core.Event) []Output {
+	var outputs []Output
+	for {
+		select {
+		case event := <-events:
+			var panicErr *core.PanicError
+			if errors.As(event.Error, &panicErr) {
+				outputs = append(outputs, Output{Text: fmt.Sprintf("panic: %v", panicErr.Value)})
+			}
+		default:
+			return outputs
+		}
+	}
+}
+
+// Equal reports whether the engine answered as recorded.
+func (r Result) Equal() bool {
+	return r.Diff() == ""
+}
+
+// Diff describes, turn by turn, how the produced conversation differs from the
+// recorded one. Lines starting with - are recorded, lines with + produced.
+func (r Result) Diff() string {
+	var sb strings.Builder
+
+	for i, want := range r.Want.Turns {
+		var got Turn
+		if i < len(r.Got.Turns) {
+			got = r.Got.Turns[i]
+		}
+
+		var lines []string
+		wantState := want.StateBefore + " -> " + want.StateAfter
+		gotState := got.StateBefore + " -> " + got.StateAfter
+		if wantState != gotState {
+			lines = append(lines, "  - state: "+wantState, "  + state: "+gotState)
+		}
+
+		for j := 0; j < max(len(want.Outputs), len(got.Outputs)); j++ {
+			switch {
+			case j >= len(got.Outputs):
+				lines = append(lines, "  - "+want.Outputs[j].String())
+			case j >= len(want.Outputs):
+				lines = append(lines, "  + "+got.Outputs[j].String())
+			case !want.Outputs[j].equal(got.Outputs[j]):
+				lines = append(lines, "  - "+want.Outputs[j].String(), "  + "+got.Outputs[j].String())
+			}
+		}
+
+		if len(lines) > 0 {
+			fmt.Fprintf(&sb, "turn %d %q\n%s\n", i+1, want.Input, strings.Join(lines, "\n"))
+		}
+	}
+
+	if sb.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("user %s (%s/%s)\n%s", r.Want.UserID, r.Want.Connector, r.Want.ChannelID, sb.String())
+}
+
+// Diff joins the differences of every result, returning an empty string when
+// the engine answered every conversation as recorded.
+func Diff(results []Result) string {
+	var diffs []string
+	for _, r := range results {
+		if d := r.Diff(); d != "" {
+			diffs = append(diffs, d)
+		}
+	}
+	return strings.Join(diffs, "\n")
+}
+
+func (o Output) String() string {
+	if len(o.Options) == 0 {
+		return fmt.Sprintf("output: %q", o.Text)
+	}
+	return fmt.Sprintf("output: %q options: [%s]", o.Text, strings.Join(o.Options, ", "))
+}
+
+func (o Output) equal(other Output) bool {
+	return o.Text == other.Text && slices.Equal(o.Options, other.Options)
+}
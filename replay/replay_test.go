@@ -0,0 +1,258 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/replay"
+	"github.com/guiflemes/ohmychat/replay/replaytest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ordersEngine(prompt string) *rule_engine.RuleEngine {
+	engine := rule_engine.NewRuleEngine()
+	engine.RegisterRule(
+		rule_engine.Rule{
+			Prompts: []string{"fazer pedido"},
+			Action: func(ctx *core.Context, msg *message.Message) {
+				msg.Output = prompt
+				ctx.SendOutput(msg)
+			},
+			NextState: core.WaitingInputState{
+				Action: func(ctx *core.Context, msg *message.Message) {
+					ctx.SetSessionState(core.IdleState{})
+					msg.Output = "pedido " + msg.Input + " recebido"
+					ctx.SendOutput(msg)
+				},
+			},
+		},
+		rule_engine.Rule{
+			Prompts:   []string{"menu"},
+			NextState: core.IdleState{},
+			Action: func(ctx *core.Context, msg *message.Message) {
+				msg.Output = "escolha"
+				msg.Options = []message.Option{{ID: "pizza"}, {ID: "sushi"}}
+				ctx.SendOutput(msg)
+			},
+		},
+	)
+	return engine
+}
+
+func recordedEntries() []core.TranscriptEntry {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(id string, d core.Direction, text string, at time.Duration) core.TranscriptEntry {
+		return core.TranscriptEntry{MessageID: id, Direction: d, UserID: "luffy", ChannelID: "CLI", Connector: message.Cli, Text: text, Time: base.Add(at)}
+	}
+
+	in1 := entry("m1", core.Inbound, "fazer pedido", 0)
+	in1.StateBefore, in1.StateAfter = "core.IdleState", "core.WaitingInputState"
+	in2 := entry("m2", core.Inbound, "42", 2*time.Second)
+	in2.StateBefore, in2.StateAfter = "core.WaitingInputState", "core.IdleState"
+	in3 := entry("m3", core.Inbound, "menu", 4*time.Second)
+	in3.StateBefore, in3.StateAfter = "core.IdleState", "core.IdleState"
+	out3 := entry("m3", core.Outbound, "escolha", 5*time.Second)
+	out3.Options = []string{"pizza", "sushi"}
+	failed3 := out3
+	failed3.Error = "telegram: too many requests"
+
+	return []core.TranscriptEntry{
+		// outbound entries may be appended before the inbound ones
+		entry("m1", core.Outbound, "Qual o número do pedido?", time.Second),
+		in1,
+		in2,
+		entry("m2", core.Outbound, "pedido 42 recebido", 3*time.Second),
+		in3,
+		// a failed dispatch attempt of a reply sent again
+		failed3,
+		out3,
+		// a proactive message answers no input
+		entry("p1", core.Outbound, "promoção!", 6*time.Second),
+	}
+}
+
+func TestConversations(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	convs := replay.Conversations(recordedEntries())
+
+	assert.Equal(t, []replay.Conversation{{
+		UserID:    "luffy",
+		ChannelID: "CLI",
+		Connector: message.Cli,
+		Turns: []replay.Turn{
+			{MessageID: "m1", Time: base, Input: "fazer pedido", StateBefore: "core.IdleState", StateAfter: "core.WaitingInputState",
+				Outputs: []replay.Output{{Text: "Qual o número do pedido?"}}},
+			{MessageID: "m2", Time: base.Add(2 * time.Second), Input: "42", StateBefore: "core.WaitingInputState", StateAfter: "core.IdleState",
+				Outputs: []replay.Output{{Text: "pedido 42 recebido"}}},
+			{MessageID: "m3", Time: base.Add(4 * time.Second), Input: "menu", StateBefore: "core.IdleState", StateAfter: "core.IdleState",
+				Outputs: []replay.Output{{Text: "escolha", Options: []string{"pizza", "sushi"}}, {Text: "promoção!"}}},
+		},
+	}}, convs)
+}
+
+func TestConversations_PerConnector(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(id string, conn message.MessageConnector, d core.Direction, text string, at time.Duration) core.TranscriptEntry {
+		return core.TranscriptEntry{MessageID: id, Direction: d, UserID: "42", ChannelID: "42", Connector: conn, Text: text, Time: base.Add(at)}
+	}
+
+	// both connectors number their messages alike
+	convs := replay.Conversations([]core.TranscriptEntry{
+		entry("1", message.Telegram, core.Inbound, "menu", 0),
+		entry("1", message.Cli, core.Inbound, "oi", time.Second),
+		entry("1", message.Telegram, core.Outbound, "escolha", 2*time.Second),
+		entry("1", message.Cli, core.Outbound, "olá", 3*time.Second),
+	})
+
+	assert.Equal(t, []replay.Conversation{{
+		UserID:    "42",
+		ChannelID: "42",
+		Connector: message.Cli,
+		Turns: []replay.Turn{
+			{MessageID: "1", Time: base.Add(time.Second), Input: "oi", Outputs: []replay.Output{{Text: "olá"}}},
+		},
+	}, {
+		UserID:    "42",
+		ChannelID: "42",
+		Connector: message.Telegram,
+		Turns: []replay.Turn{
+			{MessageID: "1", Time: base, Input: "menu", Outputs: []replay.Output{{Text: "escolha"}}},
+		},
+	}}, convs)
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	convs := replay.Conversations(recordedEntries())
+	convs[0].Turns[2].Outputs = convs[0].Turns[2].Outputs[:1]
+
+	t.Run("same engine answers as recorded", func(t *testing.T) {
+		t.Parallel()
+
+		results, err := replay.Replay(context.Background(), ordersEngine("Qual o número do pedido?"), convs)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.True(t, results[0].Equal(), results[0].Diff())
+		assert.Empty(t, replay.Diff(results))
+	})
+
+	t.Run("changed rules show in the diff", func(t *testing.T) {
+		t.Parallel()
+
+		engine := ordersEngine("Informe o pedido")
+		results, err := replay.Replay(context.Background(), engine, convs)
+		assert.NoError(t, err)
+
+		assert.Equal(t, `user luffy (cli/CLI)
+turn 1 "fazer pedido"
+  - output: "Qual o número do pedido?"
+  + output: "Informe o pedido"
+`, replay.Diff(results))
+	})
+
+	t.Run("missing outputs and state changes show in the diff", func(t *testing.T) {
+		t.Parallel()
+
+		engine := rule_engine.NewRuleEngine()
+		results, err := replay.Replay(context.Background(), engine, convs[:1])
+		assert.NoError(t, err)
+
+		diff := results[0].Diff()
+		assert.Contains(t, diff, "  - state: core.IdleState -> core.WaitingInputState\n  + state: core.IdleState -> core.IdleState")
+		assert.Contains(t, diff, `  - output: "escolha" options: [pizza, sushi]`)
+	})
+
+	t.Run("turns are replayed at their recorded time", func(t *testing.T) {
+		t.Parallel()
+
+		expired := replay.Conversations(recordedEntries())[:1]
+		expired[0].Turns = expired[0].Turns[:2]
+		expired[0].Turns[1].Time = expired[0].Turns[0].Time.Add(core.SessionExpiresAt + time.Minute)
+
+		results, err := replay.Replay(context.Background(), ordersEngine("Qual o número do pedido?"), expired)
+		assert.NoError(t, err)
+		assert.Equal(t, []replay.Output{{Text: "desculpe não entendi"}}, results[0].Got.Turns[1].Outputs)
+		assert.Equal(t, expired[0].Turns[1].Time, results[0].Got.Turns[1].Time)
+	})
+
+	t.Run("engine panics are reported as outputs", func(t *testing.T) {
+		t.Parallel()
+
+		engine := core.EngineFunc(func(*core.Context, *message.Message) { panic("boom") })
+		results, err := replay.Replay(context.Background(), engine, convs)
+		assert.NoError(t, err)
+		assert.Contains(t, results[0].Diff(), `+ output: "panic: boom"`)
+	})
+}
+
+func TestCheckGolden(t *testing.T) {
+	t.Parallel()
+
+	replaytest.CheckGolden(t, ordersEngine("Qual o número do pedido?"), filepath.Join("testdata", "orders.golden.json"))
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	transcriptPath := filepath.Join(dir, "transcript.jsonl")
+
+	var lines []byte
+	for _, e := range recordedEntries()[:4] {
+		line, err := jsonLine(e)
+		assert.NoError(t, err)
+		lines = append(lines, line...)
+	}
+	assert.NoError(t, os.WriteFile(transcriptPath, lines, 0o644))
+
+	t.Run("no differences", func(t *testing.T) {
+		t.Parallel()
+
+		var stdout, stderr bytes.Buffer
+		code := replay.Run(context.Background(), ordersEngine("Qual o número do pedido?"), []string{"-transcript", transcriptPath}, &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, "1 conversations replayed, no differences\n", stdout.String())
+	})
+
+	t.Run("differences and golden update", func(t *testing.T) {
+		t.Parallel()
+
+		golden := filepath.Join(dir, "update.golden.json")
+		var stdout, stderr bytes.Buffer
+		code := replay.Run(context.Background(), ordersEngine("Informe o pedido"),
+			[]string{"-transcript", transcriptPath, "-update", golden}, &stdout, &stderr)
+		assert.Equal(t, 1, code, stderr.String())
+		assert.Contains(t, stdout.String(), `+ output: "Informe o pedido"`)
+
+		convs, err := replay.LoadGolden(golden)
+		assert.NoError(t, err)
+		assert.Equal(t, "Informe o pedido", convs[0].Turns[0].Outputs[0].Text)
+	})
+
+	t.Run("usage errors", func(t *testing.T) {
+		t.Parallel()
+
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, replay.Run(context.Background(), ordersEngine(""), nil, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), "missing -transcript or -golden")
+	})
+}
+
+func jsonLine(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	return append(b, '\n'), err
+}
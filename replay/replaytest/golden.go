@@ -0,0 +1,49 @@
+// Package replaytest checks engines against golden files of recorded
+// conversations from tests, keeping the testing package out of the binaries
+// built with package replay.
+package replaytest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/replay"
+)
+
+// UpdateGoldenEnv names the environment variable that, set to 1, makes
+// CheckGolden rewrite the golden files with what the engine produced.
+const UpdateGoldenEnv = "OHMYCHAT_UPDATE_GOLDEN"
+
+// CheckGolden replays the conversations of the golden file at path through
+// engine and fails t with the diff when the engine answers differently. Run
+// the test with OHMYCHAT_UPDATE_GOLDEN=1 to accept the new answers.
+func CheckGolden(t testing.TB, engine core.Engine, path string, opts ...replay.Option) {
+	t.Helper()
+
+	want, err := replay.LoadGolden(path)
+	if err != nil {
+		t.Fatalf("load golden file: %v", err)
+	}
+
+	results, err := replay.Replay(context.Background(), engine, want, opts...)
+	if err != nil {
+		t.Fatalf("replay %s: %v", path, err)
+	}
+
+	if os.Getenv(UpdateGoldenEnv) == "1" {
+		got := make([]replay.Conversation, 0, len(results))
+		for _, r := range results {
+			got = append(got, r.Got)
+		}
+		if err := replay.WriteGolden(path, got); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		return
+	}
+
+	if diff := replay.Diff(results); diff != "" {
+		t.Errorf("engine answers differ from %s:\n%s", path, diff)
+	}
+}
@@ -0,0 +1,175 @@
+// Package scheduler delivers messages queued by actions through
+// core.Context.Schedule once they are due.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrGivenUp = errors.New("job given up")
+
+// Deliver dispatches a due message.
+type Deliver func(ctx context.Context, msg message.Message) error
+
+type Option func(s *Scheduler)
+
+func WithClock(clock core.Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+// WithPollInterval bounds how long the scheduler sleeps between two looks at
+// the store, picking up jobs added to it by someone else.
+func WithPollInterval(d time.Duration) Option {
+	return func(s *Scheduler) {
+		s.pollInterval = d
+	}
+}
+
+// WithRetry retries the jobs failing delivery according to policy,
+// core.DefaultRetryPolicy by default. Jobs failing with a core.IsPermanent
+// error are not retried.
+func WithRetry(policy core.RetryPolicy) Option {
+	return func(s *Scheduler) {
+		s.policy = policy
+	}
+}
+
+// WithErrorHandler is called when a due job cannot be delivered or removed.
+// Undelivered jobs stay in the store until they are retried, or are removed
+// with an ErrGivenUp error once the retry policy gives up on them.
+func WithErrorHandler(fn func(job Job, err error)) Option {
+	return func(s *Scheduler) {
+		s.onError = fn
+	}
+}
+
+type Scheduler struct {
+	store        Store
+	clock        core.Clock
+	pollInterval time.Duration
+	policy       core.RetryPolicy
+	onError      func(job Job, err error)
+	wake         chan struct{}
+}
+
+func New(store Store, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		store:        store,
+		clock:        core.SystemClock,
+		pollInterval: time.Minute,
+		policy:       core.DefaultRetryPolicy,
+		onError:      func(Job, error) {},
+		wake:         make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Schedule implements core.Scheduler.
+func (s *Scheduler) Schedule(ctx context.Context, after time.Duration, msg message.Message) (string, error) {
+	job := Job{ID: uuid.NewString(), At: s.clock.Now().Add(after), Message: msg}
+	if err := s.store.Add(ctx, job); err != nil {
+		return "", err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return job.ID, nil
+}
+
+// Cancel implements core.Scheduler.
+func (s *Scheduler) Cancel(ctx context.Context, id string) error {
+	return s.store.Remove(ctx, id)
+}
+
+// Run delivers the due jobs until ctx is done.
+func (s *Scheduler) Run(ctx context.Context, deliver Deliver) {
+	for {
+		select {
+		case <-s.wake:
+		default:
+		}
+
+		wait := s.pollInterval
+		if s.deliverDue(ctx, deliver) {
+			if next, ok, err := s.store.Next(ctx); err == nil && ok {
+				wait = min(wait, max(next.Sub(s.clock.Now()), 0))
+			}
+		}
+
+		select {
+		case <-s.clock.After(wait):
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverDue delivers the due jobs and reports whether the store is up to
+// date with them, so that jobs it failed to update are only retried on the
+// next poll.
+func (s *Scheduler) deliverDue(ctx context.Context, deliver Deliver) bool {
+	jobs, err := s.store.Due(ctx, s.clock.Now())
+	if err != nil {
+		s.onError(Job{}, err)
+		return false
+	}
+
+	ok := true
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return false
+		}
+		if err := deliver(ctx, job.Message); err != nil {
+			if !s.retry(ctx, job, err) {
+				ok = false
+			}
+			continue
+		}
+		if err := s.store.Remove(ctx, job.ID); err != nil && !errors.Is(err, ErrJobNotFound) {
+			s.onError(job, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// retry reschedules a job that failed delivery with err, or removes it once
+// err is permanent or the job is out of attempts. It reports whether the
+// store was updated.
+func (s *Scheduler) retry(ctx context.Context, job Job, err error) bool {
+	job.Attempts++
+
+	if core.IsPermanent(err) || s.policy.Exhausted(job.Attempts) {
+		s.onError(job, fmt.Errorf("%w after %d attempts: %w", ErrGivenUp, job.Attempts, err))
+		if err := s.store.Remove(ctx, job.ID); err != nil && !errors.Is(err, ErrJobNotFound) {
+			s.onError(job, err)
+			return false
+		}
+		return true
+	}
+
+	s.onError(job, err)
+	job.At = s.clock.Now().Add(s.policy.Backoff(job.Attempts, err))
+	if err := s.store.Add(ctx, job); err != nil {
+		s.onError(job, err)
+		return false
+	}
+	return true
+}
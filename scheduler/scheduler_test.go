@@ -0,0 +1,244 @@
+package scheduler_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/scheduler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	waiting chan struct{}
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0), waiting: make(chan struct{}, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.waiting <- struct{}{}
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.at.After(c.now) {
+			w.ch <- c.now
+			continue
+		}
+		pending = append(pending, w)
+	}
+	c.waiters = pending
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("delivers jobs once they are due", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		store := scheduler.NewMemoryStore()
+		s := scheduler.New(store, scheduler.WithClock(clock))
+
+		delivered := make(chan message.Message, 2)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := s.Schedule(ctx, 10*time.Minute, message.Message{Output: "lembrete"})
+		assert.NoError(t, err)
+		_, err = s.Schedule(ctx, time.Hour, message.Message{Output: "ainda está aí?"})
+		assert.NoError(t, err)
+
+		go s.Run(ctx, func(_ context.Context, msg message.Message) error {
+			delivered <- msg
+			return nil
+		})
+
+		<-clock.waiting
+		assert.Empty(t, delivered)
+
+		clock.Advance(10 * time.Minute)
+		assert.Equal(t, "lembrete", (<-delivered).Output)
+
+		<-clock.waiting
+		assert.Empty(t, delivered)
+
+		clock.Advance(50 * time.Minute)
+		assert.Equal(t, "ainda está aí?", (<-delivered).Output)
+
+		<-clock.waiting
+		_, ok, _ := store.Next(ctx)
+		assert.False(t, ok)
+	})
+
+	t.Run("cancelled jobs are not delivered", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		store := scheduler.NewMemoryStore()
+		s := scheduler.New(store, scheduler.WithClock(clock))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		id, err := s.Schedule(ctx, time.Minute, message.Message{Output: "nope"})
+		assert.NoError(t, err)
+		assert.NoError(t, s.Cancel(ctx, id))
+		assert.ErrorIs(t, s.Cancel(ctx, id), scheduler.ErrJobNotFound)
+
+		delivered := make(chan message.Message, 1)
+		go s.Run(ctx, func(_ context.Context, msg message.Message) error {
+			delivered <- msg
+			return nil
+		})
+
+		<-clock.waiting
+		clock.Advance(time.Hour)
+		<-clock.waiting
+		assert.Empty(t, delivered)
+	})
+
+	t.Run("keeps undelivered jobs and reports the error", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		store := scheduler.NewMemoryStore()
+
+		failures := make(chan scheduler.Job, 1)
+		s := scheduler.New(
+			store,
+			scheduler.WithClock(clock),
+			scheduler.WithPollInterval(time.Minute),
+			scheduler.WithErrorHandler(func(job scheduler.Job, err error) {
+				assert.ErrorIs(t, err, assert.AnError)
+				failures <- job
+			}),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		id, err := s.Schedule(ctx, 0, message.Message{Output: "retry"})
+		assert.NoError(t, err)
+
+		attempts := 0
+		delivered := make(chan message.Message, 1)
+		go s.Run(ctx, func(_ context.Context, msg message.Message) error {
+			attempts++
+			if attempts == 1 {
+				return assert.AnError
+			}
+			delivered <- msg
+			return nil
+		})
+
+		assert.Equal(t, id, (<-failures).ID)
+		<-clock.waiting
+		assert.Empty(t, delivered)
+
+		clock.Advance(time.Minute)
+		assert.Equal(t, "retry", (<-delivered).Output)
+	})
+	t.Run("backs off and gives up on failing jobs", func(t *testing.T) {
+		t.Parallel()
+
+		clock := newFakeClock()
+		store := scheduler.NewMemoryStore()
+
+		failures := make(chan error, 3)
+		s := scheduler.New(
+			store,
+			scheduler.WithClock(clock),
+			scheduler.WithRetry(core.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}),
+			scheduler.WithErrorHandler(func(_ scheduler.Job, err error) { failures <- err }),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := s.Schedule(ctx, 0, message.Message{Output: "gone"})
+		assert.NoError(t, err)
+
+		attempts := make(chan time.Time, 3)
+		go s.Run(ctx, func(context.Context, message.Message) error {
+			attempts <- clock.Now()
+			return assert.AnError
+		})
+
+		start := <-attempts
+		assert.NotErrorIs(t, <-failures, scheduler.ErrGivenUp)
+		<-clock.waiting
+		clock.Advance(time.Second)
+		assert.Equal(t, start.Add(time.Second), <-attempts)
+		assert.NotErrorIs(t, <-failures, scheduler.ErrGivenUp)
+
+		<-clock.waiting
+		clock.Advance(2 * time.Second)
+		assert.Equal(t, start.Add(3*time.Second), <-attempts)
+		assert.ErrorIs(t, <-failures, scheduler.ErrGivenUp)
+
+		<-clock.waiting
+		_, ok, _ := store.Next(ctx)
+		assert.False(t, ok)
+	})
+
+	t.Run("gives up on permanent errors at once", func(t *testing.T) {
+		t.Parallel()
+
+		store := scheduler.NewMemoryStore()
+		failures := make(chan error, 1)
+		s := scheduler.New(store, scheduler.WithClock(newFakeClock()), scheduler.WithErrorHandler(func(_ scheduler.Job, err error) {
+			failures <- err
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := s.Schedule(ctx, 0, message.Message{Connector: "fax"})
+		assert.NoError(t, err)
+
+		go s.Run(ctx, func(_ context.Context, msg message.Message) error {
+			return fmt.Errorf("%w: %q", core.ErrUnknownConnector, msg.Connector)
+		})
+
+		err = <-failures
+		assert.ErrorIs(t, err, scheduler.ErrGivenUp)
+		assert.ErrorIs(t, err, core.ErrUnknownConnector)
+		assert.Eventually(t, func() bool {
+			_, ok, _ := store.Next(ctx)
+			return !ok
+		}, time.Second, 5*time.Millisecond)
+	})
+}
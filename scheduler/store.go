@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/guiflemes/ohmychat/internal/filelog"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type Job struct {
+	ID      string          `json:"id"`
+	At      time.Time       `json:"at"`
+	Message message.Message `json:"message"`
+	// Attempts counts the failed deliveries of the job.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// Store keeps the pending jobs.
+type Store interface {
+	// Add keeps job, replacing the job with the same ID if any.
+	Add(ctx context.Context, job Job) error
+	Remove(ctx context.Context, id string) error
+	// Due returns the jobs due at now, earliest first.
+	Due(ctx context.Context, now time.Time) ([]Job, error)
+	// Next returns when the earliest pending job is due.
+	Next(ctx context.Context) (time.Time, bool, error)
+}
+
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (s *MemoryStore) Add(_ context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *MemoryStore) Remove(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrJobNotFound
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryStore) Due(_ context.Context, now time.Time) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]Job, 0)
+	for _, job := range s.jobs {
+		if !job.At.After(now) {
+			due = append(due, job)
+		}
+	}
+	sortJobs(due)
+	return due, nil
+}
+
+func (s *MemoryStore) Next(_ context.Context) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		next  time.Time
+		found bool
+	)
+	for _, job := range s.jobs {
+		if !found || job.At.Before(next) {
+			next, found = job.At, true
+		}
+	}
+	return next, found, nil
+}
+
+// FileStore keeps the jobs in memory and appends every change to a log at
+// path, so pending jobs survive restarts. Every change is written to the log
+// before it is applied in memory.
+type FileStore struct {
+	mem *MemoryStore
+
+	mu  sync.Mutex
+	log *filelog.Log[Job]
+}
+
+// NewFileStore opens the store at path, loading the jobs it already holds.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{mem: NewMemoryStore()}
+
+	log, err := filelog.Open(path, s.mem.jobs)
+	if err != nil {
+		return nil, err
+	}
+	s.log = log
+	return s, nil
+}
+
+func (s *FileStore) Add(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Put(job.ID, job); err != nil {
+		return err
+	}
+	if err := s.mem.Add(ctx, job); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+func (s *FileStore) Remove(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.mu.Lock()
+	_, ok := s.mem.jobs[id]
+	s.mem.mu.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	if err := s.log.Remove(id); err != nil {
+		return err
+	}
+	if err := s.mem.Remove(ctx, id); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+func (s *FileStore) Due(ctx context.Context, now time.Time) ([]Job, error) {
+	return s.mem.Due(ctx, now)
+}
+
+func (s *FileStore) Next(ctx context.Context) (time.Time, bool, error) {
+	return s.mem.Next(ctx)
+}
+
+// Close closes the log. The store must not be used afterwards.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.log.Close()
+}
+
+func (s *FileStore) maybeCompact() error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+
+	return s.log.MaybeCompact(s.mem.jobs)
+}
+
+func sortJobs(jobs []Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].At.Equal(jobs[j].At) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].At.Before(jobs[j].At)
+	})
+}
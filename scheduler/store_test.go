@@ -0,0 +1,91 @@
+package scheduler_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/scheduler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	stores := map[string]func(t *testing.T) scheduler.Store{
+		"memory": func(t *testing.T) scheduler.Store {
+			return scheduler.NewMemoryStore()
+		},
+		"file": func(t *testing.T) scheduler.Store {
+			store, err := scheduler.NewFileStore(filepath.Join(t.TempDir(), "jobs.json"))
+			assert.NoError(t, err)
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store := newStore(t)
+			base := time.Unix(1000, 0)
+
+			_, ok, err := store.Next(ctx)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.NoError(t, store.Add(ctx, scheduler.Job{ID: "b", At: base.Add(2 * time.Minute)}))
+			assert.NoError(t, store.Add(ctx, scheduler.Job{ID: "a", At: base.Add(time.Minute)}))
+			assert.NoError(t, store.Add(ctx, scheduler.Job{ID: "c", At: base.Add(time.Hour)}))
+
+			next, ok, err := store.Next(ctx)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, next.Equal(base.Add(time.Minute)))
+
+			due, err := store.Due(ctx, base.Add(2*time.Minute))
+			assert.NoError(t, err)
+			assert.Len(t, due, 2)
+			assert.Equal(t, "a", due[0].ID)
+			assert.Equal(t, "b", due[1].ID)
+
+			assert.NoError(t, store.Remove(ctx, "a"))
+			assert.ErrorIs(t, store.Remove(ctx, "a"), scheduler.ErrJobNotFound)
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keeps pending jobs across restarts", func(t *testing.T) {
+		t.Parallel()
+
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "jobs.json")
+		at := time.Unix(2000, 0).UTC()
+
+		store, err := scheduler.NewFileStore(path)
+		assert.NoError(t, err)
+
+		msg := message.Message{ID: "m1", Output: "lembrete", Connector: message.Telegram, ChannelID: "42"}
+		msg.User.ID = "luffy"
+		assert.NoError(t, store.Add(ctx, scheduler.Job{ID: "job", At: at, Message: msg}))
+		assert.NoError(t, store.Add(ctx, scheduler.Job{ID: "gone", At: at}))
+		assert.NoError(t, store.Remove(ctx, "gone"))
+
+		reopened, err := scheduler.NewFileStore(path)
+		assert.NoError(t, err)
+
+		due, err := reopened.Due(ctx, at)
+		assert.NoError(t, err)
+		assert.Len(t, due, 1)
+		assert.Equal(t, "job", due[0].ID)
+		assert.True(t, due[0].At.Equal(at))
+		assert.Equal(t, msg, due[0].Message)
+	})
+}
@@ -0,0 +1,114 @@
+package ohmychat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// Target identifies an existing chat a message can be pushed to.
+type Target struct {
+	Connector message.MessageConnector
+	ChannelID string
+	UserID    string
+}
+
+// SessionTarget returns the chat the session's last message came from.
+func SessionTarget(sess core.Session) Target {
+	return Target{Connector: sess.Connector, ChannelID: sess.ChannelID, UserID: sess.UserID}
+}
+
+type sendConfig struct {
+	state core.SessionState
+}
+
+type SendOption func(*sendConfig)
+
+// WithNextState sets the state of the target user's session before the
+// message is sent, so the user's next reply is handled in context.
+func WithNextState(state core.SessionState) SendOption {
+	return func(c *sendConfig) {
+		c.state = state
+	}
+}
+
+// Send pushes msg to target without waiting for an inbound message. It goes
+// through the same dispatch path, and emits the same events, as any reply.
+// Send only works while Run is serving and returns ErrNotRunning otherwise.
+func (b *ohMyChat) Send(ctx context.Context, target Target, msg message.Message, opts ...SendOption) error {
+	cfg := &sendConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	msg.Connector = target.Connector
+	msg.ChannelID = target.ChannelID
+	msg.User.ID = target.UserID
+	core.StartTrace(&msg)
+
+	return b.push(ctx, msg, cfg.state)
+}
+
+// push hands msg to the running pipeline, optionally setting the state of the
+// session it belongs to first.
+func (b *ohMyChat) push(ctx context.Context, msg message.Message, state core.SessionState) error {
+	p, err := b.startPush()
+	if err != nil {
+		return err
+	}
+	defer p.pushes.Done()
+
+	if !p.connector.Supports(msg.Connector) {
+		return fmt.Errorf("%w: %q", core.ErrUnknownConnector, msg.Connector)
+	}
+
+	if state != nil {
+		err := p.chatCtx.UpdateSession(ctx, core.SessionKey(msg), func(sess *core.Session) {
+			sess.UserID = msg.User.ID
+			sess.Connector = msg.Connector
+			sess.ChannelID = msg.ChannelID
+			sess.State = state
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	select {
+	case p.output <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.closing:
+		return ErrNotRunning
+	case <-p.chatCtx.Done():
+		return ErrNotRunning
+	}
+}
+
+// startPush returns the running pipeline, counting a push in flight on it
+// that must be marked done.
+func (b *ohMyChat) startPush() (*pipeline, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	p := b.running
+	if p == nil {
+		return nil, ErrNotRunning
+	}
+	select {
+	case <-p.closing:
+		return nil, ErrNotRunning
+	default:
+	}
+
+	p.pushes.Add(1)
+	return p, nil
+}
@@ -0,0 +1,268 @@
+package ohmychat_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat"
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/core/mocks"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/scheduler"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOhMyChat_Send(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fails when not running", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		bot := ohmychat.NewOhMyChat(
+			mocks.NewMockConnector(ctrl),
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+		)
+
+		err := bot.Send(context.Background(), ohmychat.Target{Connector: message.Test}, message.Message{})
+		assert.ErrorIs(t, err, ohmychat.ErrNotRunning)
+	})
+
+	t.Run("pushes a message and updates the session state", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		started := make(chan struct{})
+		reply := make(chan message.Message, 1)
+		dispatched := make(chan message.Message, 2)
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx *core.ChatContext, input chan<- message.Message) error {
+				close(started)
+				input <- <-reply
+				<-ctx.Done()
+				return nil
+			})
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			dispatched <- m
+			return nil
+		}).Times(2)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			if _, ok := ctx.Session().State.(core.WaitingInputState); ok {
+				msg.Output = "obrigado pela avaliação"
+				ctx.SetSessionState(core.IdleState{})
+				ctx.SendOutput(msg)
+			}
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(engine))
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+		<-started
+
+		target := ohmychat.Target{Connector: message.Telegram, ChannelID: "42", UserID: "kaido"}
+		err := bot.Send(
+			context.Background(),
+			target,
+			message.Message{Output: "seu pedido foi enviado, avalie de 1 a 5"},
+			ohmychat.WithNextState(core.WaitingInputState{}),
+		)
+		assert.NoError(t, err)
+
+		pushed := <-dispatched
+		assert.Equal(t, "seu pedido foi enviado, avalie de 1 a 5", pushed.Output)
+		assert.Equal(t, message.Telegram, pushed.Connector)
+		assert.Equal(t, "42", pushed.ChannelID)
+		assert.Equal(t, "kaido", pushed.User.ID)
+		assert.NotEmpty(t, pushed.ID)
+
+		reply <- message.Message{User: message.User{ID: "kaido"}, Input: "5", Connector: message.Telegram}
+
+		select {
+		case answer := <-dispatched:
+			assert.Equal(t, "obrigado pela avaliação", answer.Output)
+		case <-time.After(time.Second):
+			t.Fatal("reply was not handled in context")
+		}
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("rejects an unknown connector", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		started := make(chan struct{})
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx *core.ChatContext, _ chan<- message.Message) error {
+				close(started)
+				<-ctx.Done()
+				return nil
+			})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(conn, ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})))
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+		<-started
+
+		err := bot.Send(context.Background(), ohmychat.Target{Connector: message.Cli}, message.Message{})
+		assert.ErrorIs(t, err, core.ErrUnknownConnector)
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+	t.Run("dispatches messages scheduled by actions", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dispatched := make(chan message.Message, 2)
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Cli).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(
+			acquireThenWait(message.Message{User: message.User{ID: "hancock"}, Input: "me lembre", Connector: message.Cli}),
+		)
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			dispatched <- m
+			return nil
+		}).Times(2)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			reminder := *msg
+			reminder.Output = "lembrete!"
+			_, err := ctx.Schedule(10*time.Millisecond, &reminder)
+			assert.NoError(t, err)
+
+			msg.Output = "ok, vou te lembrar"
+			ctx.SendOutput(msg)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(engine),
+			ohmychat.WithScheduler(scheduler.New(scheduler.NewMemoryStore())),
+		)
+
+		runErr := make(chan error, 1)
+		go func() { runErr <- bot.Run(ctx) }()
+
+		assert.Equal(t, "ok, vou te lembrar", (<-dispatched).Output)
+		select {
+		case reminder := <-dispatched:
+			assert.Equal(t, "lembrete!", reminder.Output)
+			assert.Equal(t, "hancock", reminder.User.ID)
+		case <-time.After(time.Second):
+			t.Fatal("scheduled message was not dispatched")
+		}
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+	t.Run("tells users their session timed out", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		dispatched := make(chan message.Message, 2)
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+		conn.EXPECT().Acquire(gomock.Any(), gomock.Any()).DoAndReturn(acquireThenWait(message.Message{
+			User:      message.User{ID: "crocodile"},
+			Input:     "fazer pedido",
+			Connector: message.Telegram,
+			ChannelID: "99",
+		}))
+		conn.EXPECT().Dispatch(gomock.Any()).DoAndReturn(func(m message.Message) error {
+			dispatched <- m
+			return nil
+		}).Times(2)
+
+		engine := core.EngineFunc(func(ctx *core.Context, msg *message.Message) {
+			ctx.SetSessionState(core.WaitingInputState{})
+			msg.Output = "Qual o número do pedido?"
+			ctx.SendOutput(msg)
+		})
+
+		repo := core.NewInMemorySessionRepo(
+			core.WithSessionTTL(20*time.Millisecond),
+			core.WithSweepInterval(5*time.Millisecond),
+		)
+
+		var bot interface {
+			Send(context.Context, ohmychat.Target, message.Message, ...ohmychat.SendOption) error
+		}
+		chat := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(engine),
+			ohmychat.WithSessionAdapter(repo),
+			ohmychat.WithSessionExpired(func(ctx context.Context, sess core.Session) {
+				if _, ok := sess.State.(core.WaitingInputState); ok {
+					err := bot.Send(ctx, ohmychat.SessionTarget(sess), message.Message{Output: "sua sessão expirou"})
+					assert.NoError(t, err)
+				}
+			}),
+		)
+		bot = chat
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runErr := make(chan error, 1)
+		go func() { runErr <- chat.Run(ctx) }()
+
+		assert.Equal(t, "Qual o número do pedido?", (<-dispatched).Output)
+
+		select {
+		case timedOut := <-dispatched:
+			assert.Equal(t, "sua sessão expirou", timedOut.Output)
+			assert.Equal(t, "99", timedOut.ChannelID)
+			assert.Equal(t, "crocodile", timedOut.User.ID)
+		case <-time.After(time.Second):
+			t.Fatal("expiry message was not sent")
+		}
+
+		cancel()
+		assert.NoError(t, <-runErr)
+	})
+
+	t.Run("session expired hook requires an expiring adapter", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		conn := mocks.NewMockConnector(ctrl)
+		conn.EXPECT().Kind().Return(message.Telegram).AnyTimes()
+
+		bot := ohmychat.NewOhMyChat(
+			conn,
+			ohmychat.WithEngine(core.EngineFunc(func(*core.Context, *message.Message) {})),
+			ohmychat.WithSessionAdapter(mocks.NewMockSessionAdapter(ctrl)),
+			ohmychat.WithSessionExpired(func(context.Context, core.Session) {}),
+		)
+
+		assert.ErrorIs(t, bot.Run(context.Background()), ohmychat.ErrInvalidOption)
+	})
+}
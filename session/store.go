@@ -0,0 +1,473 @@
+// Package session provides session adapters keeping the sessions across
+// restarts.
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+)
+
+// LogFile is the name of the log in the store directory.
+const LogFile = "sessions.log"
+
+type FileStoreOption func(s *FileStore)
+
+// WithSessionTTL makes the sweeper remove sessions idle for longer than ttl.
+// Sessions already idle for that long when the store is opened are dropped.
+func WithSessionTTL(ttl time.Duration) FileStoreOption {
+	return func(s *FileStore) {
+		s.ttl = ttl
+	}
+}
+
+func WithSweepInterval(interval time.Duration) FileStoreOption {
+	return func(s *FileStore) {
+		s.sweepInterval = interval
+	}
+}
+
+// WithCompaction rewrites the log once it holds at least minRecords records
+// and ratio times as many records as sessions, 1000 and 2 by default.
+func WithCompaction(minRecords int, ratio float64) FileStoreOption {
+	return func(s *FileStore) {
+		s.compactMin = minRecords
+		s.compactRatio = ratio
+	}
+}
+
+// WithClock sets the clock stamping new sessions and driving the sweeper,
+// time.Now by default.
+func WithClock(now func() time.Time) FileStoreOption {
+	return func(s *FileStore) {
+		s.now = now
+	}
+}
+
+// FileStore is a core.SessionAdapter keeping the sessions in memory and
+// appending every change to a log in its directory, so they survive
+// restarts. The log is rewritten with only the live sessions once it grows
+// past the compaction policy.
+//
+// Every write is synced before Save returns, and cut off the log if it fails.
+// A record that cannot be read when the store is opened, such as one torn by
+// a crash, is skipped. Session states are persisted by the name they were
+// given in the StateRegistry, along with their fields for states registered
+// by type. Sessions in a state the registry cannot name, such as a waiting
+// state not obtained from it, fail to save; sessions whose state is no longer
+// registered are restored idle. Memory goes through JSON, so numbers come back as float64 and
+// structs as maps.
+type FileStore struct {
+	dir           string
+	states        *core.StateRegistry
+	ttl           time.Duration
+	sweepInterval time.Duration
+	compactMin    int
+	compactRatio  float64
+	now           func() time.Time
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	sessions  map[string]*entry
+	records   int
+	onCreated core.Hooks[func(sess core.Session)]
+	onExpired core.Hooks[func(sess core.Session)]
+	guards    core.Hooks[func(id string) bool]
+}
+
+type entry struct {
+	session *core.Session
+	// line is the record of the session as last saved, nil until it is.
+	// Compaction writes it rather than the session, which may be changing.
+	line []byte
+	// lastActivity is the activity of the session as last saved, or when it
+	// was created.
+	lastActivity time.Time
+}
+
+// record is a line of the log: a session as last saved, or its removal.
+type record struct {
+	ID      string         `json:"id"`
+	Session *storedSession `json:"session,omitempty"`
+	Deleted bool           `json:"deleted,omitempty"`
+}
+
+type storedSession struct {
+	ID             string                   `json:"id"`
+	UserID         string                   `json:"user_id,omitempty"`
+	Connector      message.MessageConnector `json:"connector,omitempty"`
+	ChannelID      string                   `json:"channel_id,omitempty"`
+	Engine         string                   `json:"engine,omitempty"`
+	State          string                   `json:"state"`
+	StateData      json.RawMessage          `json:"state_data,omitempty"`
+	Memory         map[string]any           `json:"memory,omitempty"`
+	LastActivityAt time.Time                `json:"last_activity_at"`
+}
+
+// NewFileStore opens the store in dir, creating the directory if needed and
+// loading the sessions it holds. states names the session states.
+func NewFileStore(dir string, states *core.StateRegistry, opts ...FileStoreOption) (*FileStore, error) {
+	s := &FileStore{
+		dir:           dir,
+		states:        states,
+		sweepInterval: time.Minute,
+		compactMin:    1000,
+		compactRatio:  2,
+		now:           time.Now,
+		sessions:      make(map[string]*entry),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if s.ttl > 0 {
+		for id, e := range s.sessions {
+			if s.now().Sub(e.lastActivity) > s.ttl {
+				delete(s.sessions, id)
+			}
+		}
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) path() string {
+	return filepath.Join(s.dir, LogFile)
+}
+
+// load replays the log, skipping the records that cannot be read. A last line
+// without its newline is a write torn by a crash.
+func (s *FileStore) load() error {
+	data, err := os.ReadFile(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			continue
+		}
+		if rec.Deleted {
+			delete(s.sessions, rec.ID)
+			continue
+		}
+		if rec.Session == nil {
+			continue
+		}
+		sess := s.restore(rec.Session)
+		s.sessions[rec.ID] = &entry{
+			session:      sess,
+			line:         bytes.TrimSuffix(line, []byte("\n")),
+			lastActivity: sess.LastActivityAt,
+		}
+	}
+}
+
+func (s *FileStore) restore(stored *storedSession) *core.Session {
+	state, err := s.states.Decode(stored.State, stored.StateData)
+	if err != nil {
+		state = core.IdleState{}
+	}
+
+	memory := stored.Memory
+	if memory == nil {
+		memory = make(map[string]any)
+	}
+	return &core.Session{
+		ID:             stored.ID,
+		UserID:         stored.UserID,
+		Connector:      stored.Connector,
+		ChannelID:      stored.ChannelID,
+		Engine:         stored.Engine,
+		State:          state,
+		Memory:         memory,
+		LastActivityAt: stored.LastActivityAt,
+	}
+}
+
+func (s *FileStore) store(sess *core.Session) (*storedSession, error) {
+	state, data, err := s.states.Encode(sess.State)
+	if err != nil {
+		return nil, err
+	}
+	return &storedSession{
+		ID:             sess.ID,
+		UserID:         sess.UserID,
+		Connector:      sess.Connector,
+		ChannelID:      sess.ChannelID,
+		Engine:         sess.Engine,
+		State:          state,
+		StateData:      data,
+		Memory:         sess.Memory,
+		LastActivityAt: sess.LastActivityAt,
+	}, nil
+}
+
+// GetOrCreate returns the session of id. A new session is only written to
+// the log once saved.
+func (s *FileStore) GetOrCreate(ctx context.Context, id string) (*core.Session, error) {
+	s.mu.Lock()
+
+	if e, ok := s.sessions[id]; ok {
+		s.mu.Unlock()
+		return e.session, nil
+	}
+	sess := core.NewSession(ctx, id, s.now())
+	s.sessions[id] = &entry{session: sess, lastActivity: sess.LastActivityAt}
+	created := *sess
+
+	s.mu.Unlock()
+
+	for _, fn := range s.onCreated.All() {
+		fn(created)
+	}
+	return sess, nil
+}
+
+// Save appends session to the log. It fails, leaving the log untouched, when
+// the state of the session is not registered.
+func (s *FileStore) Save(_ context.Context, session *core.Session) error {
+	stored, err := s.store(session)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(record{ID: session.ID, Session: stored})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(line); err != nil {
+		return err
+	}
+	s.sessions[session.ID] = &entry{session: session, line: line, lastActivity: stored.LastActivityAt}
+	return s.maybeCompact()
+}
+
+// Len returns how many sessions are kept.
+func (s *FileStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func (s *FileStore) OnCreated(fn func(sess core.Session)) (unregister func()) {
+	return s.onCreated.Add(fn)
+}
+
+func (s *FileStore) OnExpired(fn func(sess core.Session)) (unregister func()) {
+	return s.onExpired.Add(fn)
+}
+
+func (s *FileStore) GuardSessions(inUse func(id string) bool) (unregister func()) {
+	return s.guards.Add(inUse)
+}
+
+func (s *FileStore) inUse(id string) bool {
+	for _, guard := range s.guards.All() {
+		if guard(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sweep removes the sessions idle for longer than the TTL at now, but those
+// in use, and returns how many were removed. It does nothing when no TTL is
+// set.
+func (s *FileStore) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+
+	if s.ttl <= 0 {
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	var (
+		expired []core.Session
+		err     error
+	)
+	for id, e := range s.sessions {
+		// a session in use may be changed by its handler, and is not copied
+		if now.Sub(e.lastActivity) <= s.ttl || s.inUse(id) {
+			continue
+		}
+		if e.line != nil {
+			line, _ := json.Marshal(record{ID: id, Deleted: true})
+			if err = s.append(line); err != nil {
+				break
+			}
+		}
+		delete(s.sessions, id)
+		expired = append(expired, *e.session)
+	}
+	if err == nil {
+		err = s.maybeCompact()
+	}
+	s.mu.Unlock()
+
+	hooks := s.onExpired.All()
+	for _, sess := range expired {
+		for _, fn := range hooks {
+			fn(sess)
+		}
+	}
+	return len(expired), err
+}
+
+// RunSweeper sweeps the sessions every sweep interval until ctx is done.
+func (s *FileStore) RunSweeper(ctx context.Context) {
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Sweep(s.now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Compact rewrites the log with only the live sessions.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+// Close closes the log. The store must not be used afterwards.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// append writes line to the log, truncating it back to its last record when
+// the write fails so the next one does not follow a partial line.
+func (s *FileStore) append(line []byte) error {
+	n, err := s.file.Write(append(line, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			if truncErr := s.file.Truncate(s.size); truncErr != nil {
+				return errors.Join(err, truncErr)
+			}
+		}
+		return err
+	}
+	s.size += int64(n)
+	s.records++
+	return nil
+}
+
+func (s *FileStore) maybeCompact() error {
+	if s.records < s.compactMin || float64(s.records) < s.compactRatio*float64(len(s.sessions)) {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact writes the live sessions to a temporary file and renames it over
+// the log, so a crash leaves either the old log or the new one.
+func (s *FileStore) compact() error {
+	tmp, err := os.CreateTemp(s.dir, LogFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	records := 0
+	for _, e := range s.sessions {
+		if e.line == nil {
+			continue
+		}
+		w.Write(e.line)
+		w.WriteByte('\n')
+		records++
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path()); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path(), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = info.Size()
+	s.records = records
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,291 @@
+package session_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/engine/rule_engine"
+	"github.com/guiflemes/ohmychat/handoff"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/ohmychattest"
+	"github.com/guiflemes/ohmychat/session"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitingOrder(states *core.StateRegistry) core.SessionState {
+	return states.Register("waiting_order", core.WaitingInputState{
+		Action: func(ctx *core.Context, msg *message.Message) {
+			msg.Output = "pedido " + msg.Input + " encontrado"
+			ctx.SetSessionState(core.IdleState{})
+			ctx.SendOutput(msg)
+		},
+	})
+}
+
+func open(t *testing.T, dir string, states *core.StateRegistry, opts ...session.FileStoreOption) *session.FileStore {
+	t.Helper()
+
+	store, err := session.NewFileStore(dir, states, opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func logLines(t *testing.T, dir string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, session.LogFile))
+	assert.NoError(t, err)
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("keeps the sessions across restarts", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		waiting := waitingOrder(states)
+		at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+		store := open(t, dir, states)
+		sess, err := store.GetOrCreate(ctx, "luffy")
+		assert.NoError(t, err)
+		sess.State = waiting
+		sess.Engine = "pedidos"
+		sess.Connector = message.Telegram
+		sess.Memory["sabor"] = "calabresa"
+		sess.LastActivityAt = at
+		assert.NoError(t, store.Save(ctx, sess))
+		assert.NoError(t, store.Close())
+
+		reopened := open(t, dir, states)
+		got, err := reopened.GetOrCreate(ctx, "luffy")
+		assert.NoError(t, err)
+		assert.Equal(t, "waiting_order", got.State.(core.WaitingInputState).Name)
+		assert.NotNil(t, got.State.(core.WaitingInputState).Action)
+		assert.Equal(t, "pedidos", got.Engine)
+		assert.Equal(t, message.Telegram, got.Connector)
+		assert.Equal(t, map[string]any{"sabor": "calabresa"}, got.Memory)
+		assert.True(t, at.Equal(got.LastActivityAt))
+		assert.Equal(t, 1, reopened.Len())
+	})
+
+	t.Run("restores idle the sessions whose state is gone", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+
+		store := open(t, dir, states)
+		sess, _ := store.GetOrCreate(ctx, "zoro")
+		sess.State = waitingOrder(states)
+		assert.NoError(t, store.Save(ctx, sess))
+		assert.NoError(t, store.Close())
+
+		got, _ := open(t, dir, core.NewStateRegistry()).GetOrCreate(ctx, "zoro")
+		assert.Equal(t, core.IdleState{}, got.State)
+	})
+
+	t.Run("refuses to save a state that is not registered", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		store := open(t, dir, core.NewStateRegistry())
+		sess, _ := store.GetOrCreate(ctx, "nami")
+		sess.State = core.WaitingChoiceState{Name: "not_registered"}
+
+		assert.ErrorIs(t, store.Save(ctx, sess), core.ErrUnknownState)
+		assert.Zero(t, logLines(t, dir))
+	})
+
+	t.Run("refuses unnamed waiting states", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		store := open(t, dir, core.NewStateRegistry())
+		sess, _ := store.GetOrCreate(ctx, "nami")
+		sess.State = core.WaitingInputState{}
+
+		assert.ErrorIs(t, store.Save(ctx, sess), core.ErrUnnamedState)
+		assert.Zero(t, logLines(t, dir))
+	})
+
+	t.Run("keeps the states registered by type", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		store := open(t, dir, states)
+		sess, _ := store.GetOrCreate(ctx, "vivi")
+		sess.State = handoff.HandedOffState{Reason: "reembolso"}
+
+		assert.NoError(t, store.Save(ctx, sess))
+		assert.NoError(t, store.Close())
+
+		got, _ := open(t, dir, states).GetOrCreate(ctx, "vivi")
+		assert.Equal(t, handoff.HandedOffState{Reason: "reembolso"}, got.State)
+	})
+
+	t.Run("drops a record torn by a crash", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		store := open(t, dir, states)
+		sess, _ := store.GetOrCreate(ctx, "usopp")
+		sess.Memory["mentiras"] = "muitas"
+		assert.NoError(t, store.Save(ctx, sess))
+		assert.NoError(t, store.Close())
+
+		f, err := os.OpenFile(filepath.Join(dir, session.LogFile), os.O_APPEND|os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		f.WriteString(`{"user_id":"usopp","session":{"user_id":"us`)
+		f.Close()
+
+		reopened := open(t, dir, states)
+		got, _ := reopened.GetOrCreate(ctx, "usopp")
+		assert.Equal(t, "muitas", got.Memory["mentiras"])
+
+		got.Memory["mentiras"] = "poucas"
+		assert.NoError(t, reopened.Save(ctx, got))
+		assert.NoError(t, reopened.Close())
+
+		got, _ = open(t, dir, states).GetOrCreate(ctx, "usopp")
+		assert.Equal(t, "poucas", got.Memory["mentiras"])
+	})
+
+	t.Run("skips the records it cannot read", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		log := `{"id":"a","session":{"id":"a","state":"idle","memory":{"n":1}}}
+not json
+{"id":"b"}
+{"id":"a","session":{"id":"a","state":"idle","memory":{"n":2}}}
+`
+		err := os.WriteFile(filepath.Join(dir, session.LogFile), []byte(log), 0o644)
+		assert.NoError(t, err)
+
+		store := open(t, dir, core.NewStateRegistry())
+		assert.Equal(t, 1, store.Len())
+		got, _ := store.GetOrCreate(ctx, "a")
+		assert.Equal(t, float64(2), got.Memory["n"])
+		assert.Equal(t, 1, logLines(t, dir))
+	})
+
+	t.Run("compacts the log", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		store := open(t, dir, states, session.WithCompaction(10, 2))
+
+		for _, id := range []string{"franky", "brook"} {
+			sess, _ := store.GetOrCreate(ctx, id)
+			for i := range 20 {
+				sess.Memory["count"] = i
+				assert.NoError(t, store.Save(ctx, sess))
+			}
+		}
+		assert.LessOrEqual(t, logLines(t, dir), 10)
+
+		assert.NoError(t, store.Compact())
+		assert.Equal(t, 2, logLines(t, dir))
+		assert.NoError(t, store.Close())
+
+		got, _ := open(t, dir, states).GetOrCreate(ctx, "brook")
+		assert.Equal(t, float64(19), got.Memory["count"])
+	})
+
+	t.Run("expires idle sessions", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		clock := ohmychattest.NewClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+		opts := []session.FileStoreOption{session.WithSessionTTL(time.Minute), session.WithClock(clock.Now)}
+
+		store := open(t, dir, states, opts...)
+		var expired []string
+		store.OnExpired(func(sess core.Session) { expired = append(expired, sess.ID) })
+
+		for _, id := range []string{"robin", "jinbe"} {
+			sess, _ := store.GetOrCreate(ctx, id)
+			sess.LastActivityAt = clock.Now()
+			assert.NoError(t, store.Save(ctx, sess))
+			clock.Advance(45 * time.Second)
+		}
+
+		n, err := store.Sweep(clock.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []string{"robin"}, expired)
+		assert.NoError(t, store.Close())
+
+		clock.Advance(time.Minute)
+		reopened := open(t, dir, states, opts...)
+		assert.Zero(t, reopened.Len())
+		assert.Zero(t, logLines(t, dir))
+	})
+
+	t.Run("does not expire the sessions in use", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		clock := ohmychattest.NewClock(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+		store := open(t, dir, core.NewStateRegistry(), session.WithSessionTTL(time.Minute), session.WithClock(clock.Now))
+		var guarded core.GuardedSessionAdapter = store
+		unregister := guarded.GuardSessions(func(id string) bool { return id == "chopper" })
+
+		sess, _ := store.GetOrCreate(ctx, "chopper")
+		assert.NoError(t, store.Save(ctx, sess))
+		clock.Advance(2 * time.Minute)
+
+		n, err := store.Sweep(clock.Now())
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+
+		unregister()
+		n, err = store.Sweep(clock.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("continues a conversation after a restart", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		states := core.NewStateRegistry()
+		engine := rule_engine.NewRuleEngine()
+		engine.RegisterRule(rule_engine.Rule{
+			ID:      "order",
+			Prompts: []string{"pedido"},
+			Action: func(ctx *core.Context, msg *message.Message) {
+				msg.Output = "Qual o número do pedido?"
+				ctx.SendOutput(msg)
+			},
+			NextState: waitingOrder(states),
+		})
+
+		store := open(t, dir, states)
+		before := ohmychattest.New(t, engine, ohmychattest.WithSessionAdapter(store))
+		assert.Equal(t, []string{"Qual o número do pedido?"}, before.Say("sanji", "pedido").Texts())
+		assert.NoError(t, store.Close())
+
+		after := ohmychattest.New(t, engine, ohmychattest.WithSessionAdapter(open(t, dir, states)))
+		assert.Equal(t, []string{"pedido 42 encontrado"}, after.Say("sanji", "42").Texts())
+	})
+}
@@ -0,0 +1,46 @@
+// Package tracing provides exporters for the spans recorded by the pipeline.
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+// JSONLExporter writes every span as a line of JSON.
+type JSONLExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+func NewJSONLExporter(w io.Writer) *JSONLExporter {
+	return &JSONLExporter{enc: json.NewEncoder(w)}
+}
+
+// NewJSONLFileExporter appends the spans to the file at path, creating it if
+// needed.
+func NewJSONLFileExporter(path string) (*JSONLExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLExporter{enc: json.NewEncoder(f), c: f}, nil
+}
+
+func (e *JSONLExporter) ExportSpan(span core.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(span)
+}
+
+// Close closes the file opened by NewJSONLFileExporter.
+func (e *JSONLExporter) Close() error {
+	if e.c == nil {
+		return nil
+	}
+	return e.c.Close()
+}
@@ -0,0 +1,48 @@
+package tracing_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/tracing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLExporter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+
+	exp, err := tracing.NewJSONLFileExporter(path)
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	spans := []core.Span{
+		{TraceID: "t1", SpanID: "s1", Name: "message.process", Start: start, End: start.Add(time.Second)},
+		{TraceID: "t1", SpanID: "s2", ParentID: "s1", Name: "connector.dispatch", Error: "boom",
+			Attributes: map[string]string{"connector": "cli"}},
+	}
+	for _, s := range spans {
+		assert.NoError(t, exp.ExportSpan(s))
+	}
+	assert.NoError(t, exp.Close())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var got []core.Span
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var s core.Span
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		got = append(got, s)
+	}
+	assert.Equal(t, spans, got)
+}
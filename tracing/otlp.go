@@ -0,0 +1,350 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/metrics"
+)
+
+var (
+	ErrExporterFull   = errors.New("span exporter queue is full")
+	ErrExporterClosed = errors.New("span exporter is closed")
+)
+
+const (
+	spanKindInternal = 1
+	statusCodeOK     = 1
+	statusCodeError  = 2
+)
+
+type OTLPOption func(e *OTLPExporter)
+
+// WithServiceName sets the service.name resource attribute, "ohmychat" by
+// default.
+func WithServiceName(name string) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.serviceName = name
+	}
+}
+
+func WithHTTPClient(client *http.Client) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.client = client
+	}
+}
+
+// WithBatch sets how many spans are sent per request and how long a span may
+// wait for its batch to fill.
+func WithBatch(size int, interval time.Duration) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.batchSize = size
+		e.interval = interval
+	}
+}
+
+// WithQueueSize bounds how many spans may wait to be sent. Spans exported
+// while the queue is full are dropped and counted, see Dropped. Only the first
+// span dropped since the queue last had room is refused with ErrExporterFull,
+// so an overload is reported once rather than for every span.
+func WithQueueSize(size int) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.queueSize = size
+	}
+}
+
+// WithExportErrorHandler is called with the errors of the requests sent to
+// the collector.
+func WithExportErrorHandler(fn func(error)) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.onError = fn
+	}
+}
+
+// WithMetrics reports the spans dropped by the exporter to reg as
+// ohmychat_spans_dropped_total.
+func WithMetrics(reg metrics.Registry) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.registry = reg
+	}
+}
+
+// OTLPExporter sends the spans to an OpenTelemetry collector through the
+// OTLP/HTTP JSON protocol, in batches sent from a background goroutine.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	batchSize   int
+	interval    time.Duration
+	queueSize   int
+	onError     func(error)
+	registry    metrics.Registry
+
+	queue    chan core.Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	dropped  atomic.Uint64
+	dropping atomic.Bool
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewOTLPExporter starts an exporter sending to endpoint, the collector's
+// traces URL such as http://localhost:4318/v1/traces.
+func NewOTLPExporter(endpoint string, opts ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: "ohmychat",
+		client:      &http.Client{Timeout: 10 * time.Second},
+		batchSize:   100,
+		interval:    5 * time.Second,
+		queueSize:   1000,
+		onError:     func(error) {},
+		registry:    metrics.Nop{},
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	e.registry.CounterFunc("ohmychat_spans_dropped_total", "Spans dropped by the full OTLP exporter queue.", func() float64 {
+		return float64(e.Dropped())
+	})
+
+	e.queue = make(chan core.Span, e.queueSize)
+	e.flush = make(chan chan struct{})
+	e.done = make(chan struct{})
+	go e.run()
+	return e
+}
+
+// ExportSpan queues span to be sent. It fails with ErrExporterClosed once the
+// exporter is closed.
+func (e *OTLPExporter) ExportSpan(span core.Span) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return ErrExporterClosed
+	}
+
+	select {
+	case e.queue <- span:
+		e.dropping.Store(false)
+		return nil
+	default:
+		e.dropped.Add(1)
+		if e.dropping.Swap(true) {
+			return nil
+		}
+		return ErrExporterFull
+	}
+}
+
+// Dropped returns how many spans were dropped because the queue was full.
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Flush sends the queued spans and waits for the request to finish.
+func (e *OTLPExporter) Flush(ctx context.Context) error {
+	sent := make(chan struct{})
+	select {
+	case e.flush <- sent:
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the queued spans and stops the exporter. Spans exported after
+// Close are refused with ErrExporterClosed.
+func (e *OTLPExporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	<-e.done
+	return nil
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]core.Span, 0, e.batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.onError(err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case sent := <-e.flush:
+			for drained := false; !drained; {
+				select {
+				case span, ok := <-e.queue:
+					if !ok {
+						drained = true
+						break
+					}
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(sent)
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []core.Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp collector replied %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below follow the JSON encoding of the OTLP trace service request.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *OTLPExporter) request(spans []core.Span) otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		status := otlpStatus{Code: statusCodeOK}
+		if s.Error != "" {
+			status = otlpStatus{Code: statusCodeError, Message: s.Error}
+		}
+
+		out = append(out, otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+			Status:            status,
+		})
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: e.serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/guiflemes/ohmychat"},
+			Spans: out,
+		}},
+	}}}
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	attrs := make([]otlpAttribute, 0, len(m))
+	for k, v := range m {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
@@ -0,0 +1,193 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/metrics"
+	"github.com/guiflemes/ohmychat/tracing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectorStub decodes the OTLP/HTTP JSON requests it receives.
+type collectorStub struct {
+	mu       sync.Mutex
+	requests []map[string]any
+	status   int
+	hold     chan struct{}
+}
+
+func (c *collectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]any
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" ||
+		json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if c.hold != nil {
+		<-c.hold
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+	}
+}
+
+func (c *collectorStub) spans() []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var spans []map[string]any
+	for _, req := range c.requests {
+		for _, rs := range req["resourceSpans"].([]any) {
+			for _, ss := range rs.(map[string]any)["scopeSpans"].([]any) {
+				for _, s := range ss.(map[string]any)["spans"].([]any) {
+					spans = append(spans, s.(map[string]any))
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("sends batches to the collector", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &collectorStub{}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		exp := tracing.NewOTLPExporter(srv.URL+"/v1/traces",
+			tracing.WithServiceName("pedidos"),
+			tracing.WithBatch(2, time.Hour),
+		)
+
+		start := time.Unix(0, 1_000)
+		assert.NoError(t, exp.ExportSpan(core.Span{
+			TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331", Name: "message.process",
+			Start: start, End: start.Add(time.Microsecond),
+			Attributes: map[string]string{"user.id": "ace"},
+		}))
+		assert.NoError(t, exp.ExportSpan(core.Span{
+			TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "00f067aa0ba902b7", ParentID: "b7ad6b7169203331",
+			Name: "connector.dispatch", Start: start, End: start, Error: "boom",
+		}))
+		assert.NoError(t, exp.ExportSpan(core.Span{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "e457b5a2e4d86bd1", Name: "session.save"}))
+		assert.NoError(t, exp.Close())
+
+		collector.mu.Lock()
+		assert.Len(t, collector.requests, 2, "a full batch, then the rest on close")
+		resource := collector.requests[0]["resourceSpans"].([]any)[0].(map[string]any)["resource"]
+		collector.mu.Unlock()
+		assert.Equal(t, map[string]any{"attributes": []any{
+			map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "pedidos"}},
+		}}, resource)
+
+		spans := collector.spans()
+		assert.Len(t, spans, 3)
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[0]["traceId"])
+		assert.Equal(t, "b7ad6b7169203331", spans[0]["spanId"])
+		assert.Equal(t, "1000", spans[0]["startTimeUnixNano"])
+		assert.Equal(t, "2000", spans[0]["endTimeUnixNano"])
+		assert.Equal(t, map[string]any{"code": float64(1)}, spans[0]["status"])
+		assert.Equal(t, []any{map[string]any{"key": "user.id", "value": map[string]any{"stringValue": "ace"}}}, spans[0]["attributes"])
+		assert.Equal(t, "b7ad6b7169203331", spans[1]["parentSpanId"])
+		assert.Equal(t, map[string]any{"code": float64(2), "message": "boom"}, spans[1]["status"])
+	})
+
+	t.Run("flush sends the queued spans", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &collectorStub{}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		exp := tracing.NewOTLPExporter(srv.URL+"/v1/traces", tracing.WithBatch(100, time.Hour))
+		defer exp.Close()
+
+		assert.NoError(t, exp.ExportSpan(core.Span{TraceID: "t", SpanID: "s", Name: "engine.handle"}))
+		assert.NoError(t, exp.Flush(context.Background()))
+		assert.Len(t, collector.spans(), 1)
+	})
+
+	t.Run("reports collector errors", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &collectorStub{status: http.StatusServiceUnavailable}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		errs := make(chan error, 1)
+		exp := tracing.NewOTLPExporter(srv.URL+"/v1/traces",
+			tracing.WithBatch(1, time.Hour),
+			tracing.WithExportErrorHandler(func(err error) { errs <- err }),
+		)
+		defer exp.Close()
+
+		assert.NoError(t, exp.ExportSpan(core.Span{Name: "x"}))
+		assert.ErrorContains(t, <-errs, "503")
+	})
+
+	t.Run("drops spans while the queue is full", func(t *testing.T) {
+		t.Parallel()
+
+		collector := &collectorStub{hold: make(chan struct{})}
+		srv := httptest.NewServer(collector)
+		defer srv.Close()
+
+		reg := metrics.NewPrometheusRegistry()
+		exp := tracing.NewOTLPExporter(srv.URL+"/v1/traces",
+			tracing.WithBatch(1, time.Hour),
+			tracing.WithQueueSize(0),
+			tracing.WithMetrics(reg),
+		)
+
+		// with no queue a span is only taken while the exporter is idle
+		assert.Eventually(t, func() bool {
+			return exp.ExportSpan(core.Span{Name: "x"}) == nil
+		}, time.Second, time.Millisecond)
+		dropped := exp.Dropped()
+
+		// the overload is reported once, every dropped span is counted
+		assert.ErrorIs(t, exp.ExportSpan(core.Span{Name: "y"}), tracing.ErrExporterFull)
+		assert.NoError(t, exp.ExportSpan(core.Span{Name: "z"}))
+		assert.Equal(t, dropped+2, exp.Dropped())
+
+		var sb strings.Builder
+		_, err := reg.WriteTo(&sb)
+		assert.NoError(t, err)
+		assert.Contains(t, sb.String(), fmt.Sprintf("ohmychat_spans_dropped_total %d\n", dropped+2))
+
+		close(collector.hold)
+		assert.NoError(t, exp.Close())
+		assert.Len(t, collector.spans(), 1)
+	})
+
+	t.Run("refuses spans once closed", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(&collectorStub{})
+		defer srv.Close()
+
+		exp := tracing.NewOTLPExporter(srv.URL + "/v1/traces")
+		assert.NoError(t, exp.Close())
+		assert.NoError(t, exp.Close())
+		assert.ErrorIs(t, exp.ExportSpan(core.Span{Name: "x"}), tracing.ErrExporterClosed)
+	})
+}
@@ -0,0 +1,149 @@
+// Package transcript provides stores for the history of the conversations.
+package transcript
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/guiflemes/ohmychat/core"
+)
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries []core.TranscriptEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(_ context.Context, entry core.TranscriptEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *MemoryStore) Query(_ context.Context, q core.TranscriptQuery) ([]core.TranscriptEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []core.TranscriptEntry
+	for _, e := range s.entries {
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return q.Apply(entries), nil
+}
+
+// FileStore appends the entries to a JSON lines file and answers queries by
+// scanning it, so the history survives restarts. Queries read the file on a
+// handle of their own and never hold back Append. Lines that cannot be read,
+// such as one torn by a crash, are skipped.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileStore opens the store at path, creating the file if needed. A last
+// line torn by a crash is ended, so the next entry starts a line of its own.
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := endLastLine(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileStore{path: path, file: f}, nil
+}
+
+// endLastLine appends a newline to f unless it is empty or already ends with
+// one.
+func endLastLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+func (s *FileStore) Append(_ context.Context, entry core.TranscriptEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// a single write on a file opened for appending, so a query reading the
+	// file sees the whole line or at most a part of the last one
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileStore) Query(ctx context.Context, q core.TranscriptQuery) ([]core.TranscriptEntry, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := scan(ctx, f, q)
+	if err != nil {
+		return nil, fmt.Errorf("read transcript %s: %w", s.path, err)
+	}
+	return q.Apply(entries), nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// scan returns the entries of r matching q, skipping the lines that cannot be
+// read. A last line without its newline is being written and is skipped too.
+func scan(ctx context.Context, r io.Reader, q core.TranscriptQuery) ([]core.TranscriptEntry, error) {
+	var entries []core.TranscriptEntry
+
+	reader := bufio.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var e core.TranscriptEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			continue
+		}
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package transcript_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guiflemes/ohmychat/core"
+	"github.com/guiflemes/ohmychat/message"
+	"github.com/guiflemes/ohmychat/transcript"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []core.TranscriptEntry{
+		{MessageID: "1", Direction: core.Inbound, UserID: "luffy", ChannelID: "c1", Connector: message.Cli, Text: "oi", Time: base},
+		{MessageID: "3", Direction: core.Inbound, UserID: "zoro", ChannelID: "c2", Connector: message.Telegram, Text: "hey", Time: base.Add(2 * time.Minute)},
+		// a reply may be appended before the message it answers
+		{MessageID: "2", Direction: core.Outbound, UserID: "luffy", ChannelID: "c1", Connector: message.Cli, Text: "olá", Time: base.Add(time.Minute)},
+		{MessageID: "4", Direction: core.Outbound, UserID: "zoro", ChannelID: "c2", Connector: message.Telegram, Text: "yo", Time: base.Add(3 * time.Minute)},
+	}
+
+	stores := map[string]func(t *testing.T) core.TranscriptStore{
+		"memory": func(*testing.T) core.TranscriptStore { return transcript.NewMemoryStore() },
+		"file": func(t *testing.T) core.TranscriptStore {
+			store, err := transcript.NewFileStore(filepath.Join(t.TempDir(), "transcript.jsonl"))
+			assert.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+
+	queries := []struct {
+		name  string
+		query core.TranscriptQuery
+		ids   []string
+	}{
+		{"everything in time order", core.TranscriptQuery{}, []string{"1", "2", "3", "4"}},
+		{"by user", core.TranscriptQuery{UserID: "luffy"}, []string{"1", "2"}},
+		{"by channel", core.TranscriptQuery{ChannelID: "c2"}, []string{"3", "4"}},
+		{"by connector", core.TranscriptQuery{Connector: message.Telegram}, []string{"3", "4"}},
+		{"by time range", core.TranscriptQuery{From: base.Add(time.Minute), To: base.Add(3 * time.Minute)}, []string{"2", "3"}},
+		{"latest entries", core.TranscriptQuery{Limit: 3}, []string{"2", "3", "4"}},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store := newStore(t)
+			for _, e := range entries {
+				assert.NoError(t, store.Append(context.Background(), e))
+			}
+
+			for _, q := range queries {
+				got, err := store.Query(context.Background(), q.query)
+				assert.NoError(t, err, q.name)
+
+				var ids []string
+				for _, e := range got {
+					ids = append(ids, e.MessageID)
+				}
+				assert.Equal(t, q.ids, ids, q.name)
+			}
+		})
+	}
+
+	t.Run("file store keeps the history across reopens", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		store, err := transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Append(context.Background(), entries[0]))
+		assert.NoError(t, store.Close())
+
+		store, err = transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		defer store.Close()
+		assert.NoError(t, store.Append(context.Background(), entries[2]))
+
+		got, err := store.Query(context.Background(), core.TranscriptQuery{})
+		assert.NoError(t, err)
+		assert.Equal(t, []core.TranscriptEntry{entries[0], entries[2]}, got)
+	})
+	t.Run("file store skips a line being written", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		store, err := transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		defer store.Close()
+		assert.NoError(t, store.Append(context.Background(), entries[0]))
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		_, err = f.WriteString(`{"message_id":"2","direc`)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		got, err := store.Query(context.Background(), core.TranscriptQuery{})
+		assert.NoError(t, err)
+		assert.Equal(t, []core.TranscriptEntry{entries[0]}, got)
+	})
+	t.Run("file store skips a last line torn by a crash", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		store, err := transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Append(context.Background(), entries[0]))
+		assert.NoError(t, store.Close())
+
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		_, err = f.WriteString(`{"message_id":"2","direc`)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		store, err = transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		defer store.Close()
+		assert.NoError(t, store.Append(context.Background(), entries[2]))
+
+		got, err := store.Query(context.Background(), core.TranscriptQuery{})
+		assert.NoError(t, err)
+		assert.Equal(t, []core.TranscriptEntry{entries[0], entries[2]}, got)
+	})
+	t.Run("file store skips the lines it cannot read", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		line, err := json.Marshal(entries[0])
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, append([]byte("not json\n"), append(line, '\n')...), 0o644))
+
+		store, err := transcript.NewFileStore(path)
+		assert.NoError(t, err)
+		defer store.Close()
+
+		got, err := store.Query(context.Background(), core.TranscriptQuery{})
+		assert.NoError(t, err)
+		assert.Equal(t, []core.TranscriptEntry{entries[0]}, got)
+	})
+}